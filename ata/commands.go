@@ -7,11 +7,18 @@ package ata
 
 const (
 	// ATA commands
-	ATA_SMART           = 0xb0
-	ATA_IDENTIFY_DEVICE = 0xec
+	ATA_SMART                     = 0xb0
+	ATA_IDENTIFY_DEVICE           = 0xec
+	ATA_SECURITY_SET_PASSWORD     = 0xf1
+	ATA_SECURITY_ERASE_PREPARE    = 0xf3
+	ATA_SECURITY_ERASE_UNIT       = 0xf4
+	ATA_SECURITY_DISABLE_PASSWORD = 0xf6
 
 	// ATA feature register values for SMART
 	SMART_READ_DATA     = 0xd0
 	SMART_READ_LOG      = 0xd5
 	SMART_RETURN_STATUS = 0xda
+
+	// Maximum length of an ATA security password
+	SECURITY_PASSWORD_LEN = 32
 )
@@ -0,0 +1,45 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+// Raw ATA data dumps, for offline analysis.
+
+package ata
+
+import (
+	"fmt"
+
+	"github.com/madper/smart/utils"
+)
+
+// Layout of an ATA dump. The raw data structures are stored back-to-back, exactly as returned by
+// the device, without any header.
+const (
+	ATA_DUMP_IDENTIFY_OFFSET = 0   // IDENTIFY DEVICE data (512 bytes)
+	ATA_DUMP_SMART_OFFSET    = 512 // SMART READ DATA response (512 bytes)
+	ATA_DUMP_SIZE            = 1024
+)
+
+// Dump holds the data structures decoded from an ATA dump.
+type Dump struct {
+	Identify IdentifyDeviceData
+	SMART    SmartPage
+}
+
+// ParseDump decodes an ATA dump.
+func ParseDump(b []byte) (*Dump, error) {
+	var d Dump
+
+	if len(b) != ATA_DUMP_SIZE {
+		return nil, fmt.Errorf("invalid ATA dump size %d, expected %d", len(b), ATA_DUMP_SIZE)
+	}
+
+	if err := utils.DecodeInto(b[ATA_DUMP_IDENTIFY_OFFSET:], &d.Identify); err != nil {
+		return nil, err
+	}
+
+	if err := utils.DecodeInto(b[ATA_DUMP_SMART_OFFSET:], &d.SMART); err != nil {
+		return nil, err
+	}
+
+	return &d, nil
+}
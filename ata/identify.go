@@ -7,6 +7,8 @@ package ata
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/madper/smart/utils"
 )
@@ -67,33 +69,49 @@ var ataMinorVersions = map[uint16]string{
 }
 
 // ATA IDENTIFY DEVICE struct. ATA8-ACS defines this as a page of 16-bit words. Some fields span
-// multiple words (e.g., model number), but must (?) be byteswapped. Some fields use less than a
-// single word, and are bitmasked together with other fields. Since many of the fields are now
-// retired / obsolete, we only define the fields that are currently used by this package.
+// multiple words (e.g., model number), and store two ASCII characters per word with the first
+// character in the high byte. Some fields use less than a single word, and are bitmasked together
+// with other fields. Since many of the fields are now retired / obsolete, we only define the fields
+// that are currently used by this package.
 type IdentifyDeviceData struct {
-	GeneralConfig       uint16      // Word 0, general configuration. If bit 15 is zero, device is ATA.
-	_                   [9]uint16   // ...
-	SerialNumberRaw     [20]byte    // Word 10..19, device serial number, padded with spaces (20h).
-	_                   [3]uint16   // ...
-	FirmwareRevisionRaw [8]byte     // Word 23..26, device firmware revision, padded with spaces (20h).
-	ModelNumberRaw      [40]byte    // Word 27..46, device model number, padded with spaces (20h).
-	_                   [28]uint16  // ...
-	SATACap             uint16      // Word 76, SATA capabilities.
-	SATACapAddl         uint16      // Word 77, SATA additional capabilities.
-	_                   [3]uint16   // ...
-	MajorVersion        uint16      // Word 80, major version number.
-	MinorVersion        uint16      // Word 81, minor version number.
-	_                   [3]uint16   // ...
-	Word85              uint16      // Word 85, supported commands and feature sets.
-	_                   uint16      // ...
-	Word87              uint16      // Word 87, supported commands and feature sets.
-	_                   [20]uint16  // ...
-	WWNRaw              [4]uint16   // Word 108..111, WWN (World Wide Name).
-	_                   [105]uint16 // ...
-	RotationRate        uint16      // Word 217, nominal media rotation rate.
-	_                   [4]uint16   // ...
-	TransportMajor      uint16      // Word 222, transport major version number.
-	_                   [33]uint16  // ...
+	GeneralConfig       uint16     // Word 0, general configuration. If bit 15 is zero, device is ATA.
+	_                   [9]uint16  // ...
+	SerialNumberRaw     [10]uint16 // Word 10..19, device serial number, padded with spaces (20h).
+	_                   [3]uint16  // ...
+	FirmwareRevisionRaw [4]uint16  // Word 23..26, device firmware revision, padded with spaces (20h).
+	ModelNumberRaw      [20]uint16 // Word 27..46, device model number, padded with spaces (20h).
+	_                   [13]uint16 // ...
+	LBA28Sectors        uint32     // Word 60..61, total number of user addressable sectors (28-bit).
+	_                   [14]uint16 // ...
+	SATACap             uint16     // Word 76, SATA capabilities.
+	SATACapAddl         uint16     // Word 77, SATA additional capabilities.
+	_                   [2]uint16  // ...
+	MajorVersion        uint16     // Word 80, major version number.
+	MinorVersion        uint16     // Word 81, minor version number.
+	Word82              uint16     // Word 82, supported commands and feature sets.
+	Word83              uint16     // Word 83, supported commands and feature sets.
+	Word84              uint16     // Word 84, supported commands and feature sets.
+	Word85              uint16     // Word 85, enabled commands and feature sets.
+	Word86              uint16     // Word 86, enabled commands and feature sets.
+	Word87              uint16     // Word 87, enabled commands and feature sets.
+	_                   uint16     // ...
+	Word89              uint16     // Word 89, time required for normal security erase.
+	Word90              uint16     // Word 90, time required for enhanced security erase.
+	_                   [9]uint16  // ...
+	LBA48Sectors        uint64     // Word 100..103, total number of user addressable sectors (48-bit).
+	_                   [2]uint16  // ...
+	Word106             uint16     // Word 106, physical / logical sector size.
+	_                   uint16     // ...
+	WWNRaw              [4]uint16  // Word 108..111, WWN (World Wide Name).
+	_                   [5]uint16  // ...
+	LogicalSectorWords  [2]uint16  // Word 117..118, logical sector size in words (if word 106 bit 12 set).
+	_                   [9]uint16  // ...
+	SecurityStatus      uint16     // Word 128, security status.
+	_                   [88]uint16 // ...
+	RotationRate        uint16     // Word 217, nominal media rotation rate.
+	_                   [4]uint16  // ...
+	TransportMajor      uint16     // Word 222, transport major version number.
+	_                   [33]uint16 // ...
 } // 512 bytes
 
 // ATAMajorVersion returns the ATA major version from an ATA IDENTIFY command.
@@ -143,19 +161,117 @@ func (d *IdentifyDeviceData) ATAMinorVersion() string {
 	return "unknown"
 }
 
-// FirmwareVersion returns the firmware version of a device from an ATA IDENTIFY command.
+// FirmwareRevision returns the firmware revision of a device from an ATA IDENTIFY command.
 func (d *IdentifyDeviceData) FirmwareRevision() []byte {
-	return d.swapBytes(d.FirmwareRevisionRaw[:])
+	return []byte(ataString(d.FirmwareRevisionRaw[:]))
 }
 
 // ModelNumber returns the model number of a device from an ATA IDENTIFY command.
 func (d *IdentifyDeviceData) ModelNumber() []byte {
-	return d.swapBytes(d.ModelNumberRaw[:])
+	return []byte(ataString(d.ModelNumberRaw[:]))
 }
 
-// ModelNumber returns the serial number of a device from an ATA IDENTIFY command.
+// SerialNumber returns the serial number of a device from an ATA IDENTIFY command.
 func (d *IdentifyDeviceData) SerialNumber() []byte {
-	return d.swapBytes(d.SerialNumberRaw[:])
+	return []byte(ataString(d.SerialNumberRaw[:]))
+}
+
+// SMARTSupported reports whether the SMART feature set is supported (word 82 bit 0).
+func (d *IdentifyDeviceData) SMARTSupported() bool {
+	return d.Word82&0x0001 != 0
+}
+
+// SMARTEnabled reports whether the SMART feature set is enabled (word 85 bit 0).
+func (d *IdentifyDeviceData) SMARTEnabled() bool {
+	return d.Word85&0x0001 != 0
+}
+
+// SecuritySupported reports whether the Security feature set is supported (word 82 bit 1).
+func (d *IdentifyDeviceData) SecuritySupported() bool {
+	return d.Word82&0x0002 != 0
+}
+
+// SecurityEnabled reports whether a user password is set (word 128 bit 1).
+func (d *IdentifyDeviceData) SecurityEnabled() bool {
+	return d.SecurityStatus&0x0002 != 0
+}
+
+// SecurityLocked reports whether the device is locked, i.e. requires unlocking with the user
+// password before media access (word 128 bit 2).
+func (d *IdentifyDeviceData) SecurityLocked() bool {
+	return d.SecurityStatus&0x0004 != 0
+}
+
+// SecurityFrozen reports whether the security configuration is frozen until the next power cycle
+// (word 128 bit 3). Many BIOSes freeze the security configuration of drives during boot.
+func (d *IdentifyDeviceData) SecurityFrozen() bool {
+	return d.SecurityStatus&0x0008 != 0
+}
+
+// SecurityCountExpired reports whether the password attempt counter has been exceeded
+// (word 128 bit 4).
+func (d *IdentifyDeviceData) SecurityCountExpired() bool {
+	return d.SecurityStatus&0x0010 != 0
+}
+
+// EnhancedEraseSupported reports whether the enhanced security erase mode is supported (word 128
+// bit 5).
+func (d *IdentifyDeviceData) EnhancedEraseSupported() bool {
+	return d.SecurityStatus&0x0020 != 0
+}
+
+// SecurityEraseTime returns the estimated time required for a normal or enhanced security erase
+// (words 89 and 90), or zero if the device does not report it.
+func (d *IdentifyDeviceData) SecurityEraseTime(enhanced bool) time.Duration {
+	word := d.Word89
+	if enhanced {
+		word = d.Word90
+	}
+
+	// ACS-3 extended format in bits 14:0 if bit 15 is set, otherwise bits 7:0
+	if word&0x8000 != 0 {
+		word &= 0x7fff
+	} else {
+		word &= 0x00ff
+	}
+
+	// Values are in units of two minutes
+	return time.Duration(word) * 2 * time.Minute
+}
+
+// WriteCacheSupported reports whether the volatile write cache is supported (word 82 bit 5).
+func (d *IdentifyDeviceData) WriteCacheSupported() bool {
+	return d.Word82&0x0020 != 0
+}
+
+// LBA48Supported reports whether the 48-bit Address feature set is supported (word 83 bit 10).
+func (d *IdentifyDeviceData) LBA48Supported() bool {
+	return d.Word83&0x0400 != 0
+}
+
+// TotalLBAs returns the total number of user addressable logical sectors, preferring the 48-bit
+// value (words 100..103) if the device supports 48-bit addressing.
+func (d *IdentifyDeviceData) TotalLBAs() uint64 {
+	if d.LBA48Supported() && d.LBA48Sectors != 0 {
+		return d.LBA48Sectors
+	}
+
+	return uint64(d.LBA28Sectors)
+}
+
+// LogicalSectorSize returns the logical sector size in bytes. Word 106 is only valid if bit 14 is
+// set and bit 15 is cleared; otherwise the traditional 512 byte sector size is assumed.
+func (d *IdentifyDeviceData) LogicalSectorSize() uint64 {
+	if (d.Word106&0xc000 == 0x4000) && (d.Word106&0x1000 != 0) {
+		return (uint64(d.LogicalSectorWords[1])<<16 | uint64(d.LogicalSectorWords[0])) * 2
+	}
+
+	return 512
+}
+
+// Capacity returns the user addressable capacity of the device in bytes.
+func (d *IdentifyDeviceData) Capacity() uint64 {
+	return d.TotalLBAs() * d.LogicalSectorSize()
 }
 
 func (d *IdentifyDeviceData) Transport() (s string) {
@@ -200,6 +316,18 @@ func (d *IdentifyDeviceData) Transport() (s string) {
 	return
 }
 
+// WWNSupported reports whether the device reports a World Wide Name in words 108..111 (word 87
+// bit 8).
+func (d *IdentifyDeviceData) WWNSupported() bool {
+	return d.Word87&0xc100 == 0x4100
+}
+
+// WWN64 returns the 64-bit World Wide Name of the device from words 108..111.
+func (d *IdentifyDeviceData) WWN64() uint64 {
+	return uint64(d.WWNRaw[0])<<48 | uint64(d.WWNRaw[1])<<32 | uint64(d.WWNRaw[2])<<16 | uint64(d.WWNRaw[3])
+}
+
+// WWN returns the World Wide Name of the device, formatted as NAA, IEEE OUI and unique ID.
 func (d *IdentifyDeviceData) WWN() string {
 	naa := d.WWNRaw[0] >> 12
 	oui := (uint32(d.WWNRaw[0]&0x0fff) << 12) | (uint32(d.WWNRaw[1]) >> 4)
@@ -208,12 +336,14 @@ func (d *IdentifyDeviceData) WWN() string {
 	return fmt.Sprintf("%x %06x %09x", naa, oui, uniqueID)
 }
 
-func (d *IdentifyDeviceData) swapBytes(b []byte) []byte {
-	tmp := make([]byte, len(b))
+// ataString decodes an ATA string field, which stores two ASCII characters per 16-bit word with
+// the first character in the high byte, and trims the space (20h) / NUL padding.
+func ataString(words []uint16) string {
+	b := make([]byte, 0, len(words)*2)
 
-	for i := 0; i < len(b); i += 2 {
-		tmp[i], tmp[i+1] = b[i+1], b[i]
+	for _, w := range words {
+		b = append(b, byte(w>>8), byte(w))
 	}
 
-	return tmp
+	return strings.Trim(string(b), " \x00")
 }
@@ -7,6 +7,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"testing"
+	"time"
 	"unsafe"
 
 	"github.com/stretchr/testify/assert"
@@ -58,12 +59,46 @@ func TestATAIdentify(t *testing.T) {
 	assert.Equal(uintptr(512), unsafe.Sizeof(d))
 	binary.Read(bytes.NewBuffer(ataIdentifyData[:]), utils.NativeEndian, &d)
 
-	assert.Equal("S1DMNEAD123456B     ", string(d.SerialNumber()))
+	assert.Equal("S1DMNEAD123456B", string(d.SerialNumber()))
 	assert.Equal("EXT0DB6Q", string(d.FirmwareRevision()))
-	assert.Equal("Samsung SSD 840 EVO 750GB               ", string(d.ModelNumber()))
+	assert.Equal("Samsung SSD 840 EVO 750GB", string(d.ModelNumber()))
 	assert.Equal("5 002538 85009397f", d.WWN())
+	assert.True(d.WWNSupported())
+	assert.Equal(uint64(0x500253885009397f), d.WWN64())
 
 	assert.Equal(uint16(1), d.RotationRate)
+
+	assert.True(d.SMARTSupported())
+	assert.True(d.SMARTEnabled())
+	assert.True(d.LBA48Supported())
+	assert.Equal(uint64(1465149168), d.TotalLBAs())
+	assert.Equal(uint64(512), d.LogicalSectorSize())
+	assert.Equal(uint64(750156374016), d.Capacity())
+}
+
+func TestATAString(t *testing.T) {
+	var words [20]uint16
+
+	assert := assert.New(t)
+
+	// Model number field (words 27..46) of the identify dump above
+	binary.Read(bytes.NewBuffer(ataIdentifyData[54:94]), utils.NativeEndian, &words)
+	assert.Equal("Samsung SSD 840 EVO 750GB", ataString(words[:]))
+
+	assert.Equal("AB", ataString([]uint16{0x4142}))
+	assert.Equal("ABC", ataString([]uint16{0x2020, 0x4142, 0x4320, 0x2020}))
+	assert.Equal("", ataString([]uint16{0x2020, 0x0000}))
+}
+
+func TestSecurityEraseTime(t *testing.T) {
+	assert := assert.New(t)
+
+	d := IdentifyDeviceData{Word89: 0x0001, Word90: 0x8100, SecurityStatus: 0x0029}
+	assert.Equal(2*time.Minute, d.SecurityEraseTime(false))
+	assert.Equal(512*time.Minute, d.SecurityEraseTime(true))
+	assert.True(d.SecurityFrozen())
+	assert.True(d.EnhancedEraseSupported())
+	assert.False(d.SecurityLocked())
 }
 
 // swapBytes swaps the order of every second byte in a byte slice (modifies slice in-place).
@@ -6,6 +6,7 @@ import (
 	"strconv"
 
 	"github.com/madper/smart/drivedb"
+	"github.com/madper/smart/utils"
 )
 
 // Individual SMART attribute (12 bytes)
@@ -60,6 +61,103 @@ type SmartSelfTestLog struct {
 	Checksum       byte   // Two's complement checksum of first 511 bytes
 }
 
+// RawInterpreter decodes the six vendor-specific raw bytes of a SMART attribute into a meaningful
+// value, e.g. a number of hours or a temperature in degrees Celsius.
+type RawInterpreter func(raw [6]byte) int64
+
+// RawInterpreters maps SMART attribute IDs to the interpreter used to decode their raw values.
+// Attributes not present in the map are decoded as a little-endian 48-bit integer. Callers may add
+// or replace entries to handle vendor-specific encodings.
+var RawInterpreters = map[uint8]RawInterpreter{
+	5:   rawLow16, // Reallocated_Sector_Ct, upper words sometimes hold vendor-specific data
+	9:   rawLow24, // Power_On_Hours, upper byte(s) sometimes hold minutes / milliseconds
+	190: rawTemp,  // Airflow_Temperature_Cel, upper bytes often pack min / max temperature
+	194: rawTemp,  // Temperature_Celsius, upper bytes often pack min / max temperature
+}
+
+// raw48 decodes the raw bytes as a little-endian 48-bit integer.
+func raw48(raw [6]byte) int64 {
+	var r int64
+
+	for i := 5; i >= 0; i-- {
+		r = (r << 8) | int64(raw[i])
+	}
+
+	return r
+}
+
+// rawLow16 decodes the least significant word of the raw bytes.
+func rawLow16(raw [6]byte) int64 {
+	return int64(raw[1])<<8 | int64(raw[0])
+}
+
+// rawLow24 decodes the least significant three bytes of the raw bytes.
+func rawLow24(raw [6]byte) int64 {
+	return int64(raw[2])<<16 | int64(raw[1])<<8 | int64(raw[0])
+}
+
+// rawTemp decodes the least significant byte of the raw bytes as a signed temperature.
+func rawTemp(raw [6]byte) int64 {
+	return int64(int8(raw[0]))
+}
+
+// Temperature returns the drive temperature from the Temperature_Celsius (194) attribute, falling
+// back to Airflow_Temperature_Cel (190). The second return value is false if neither is present.
+func (sp *SmartPage) Temperature() (utils.Temperature, bool) {
+	for _, id := range []uint8{194, 190} {
+		for _, attr := range sp.Attrs {
+			if attr.Id == id {
+				return utils.TemperatureFromCelsius(float64(rawTemp(attr.VendorBytes))), true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// MediaErrorCount returns the sum of the Reallocated_Sector_Ct (5) and Current_Pending_Sector
+// (197) raw values. Attributes which are not present count as zero.
+func (sp *SmartPage) MediaErrorCount() uint64 {
+	var count uint64
+
+	for _, attr := range sp.Attrs {
+		if attr.Id == 5 || attr.Id == 197 {
+			if v := attr.RawValue(); v > 0 {
+				count += uint64(v)
+			}
+		}
+	}
+
+	return count
+}
+
+// SMARTDataTemperature extracts the drive temperature from a raw SMART READ DATA response, in the
+// same manner as SmartPage.Temperature, but without decoding the whole page.
+func SMARTDataTemperature(buf []byte) (utils.Temperature, bool) {
+	const attrLen = 12 // size of a smartAttr
+
+	for _, id := range []uint8{194, 190} {
+		// Attribute table starts after the two-byte version number
+		for off := 2; off+attrLen <= len(buf) && off < 2+30*attrLen; off += attrLen {
+			if buf[off] == id {
+				return utils.TemperatureFromCelsius(float64(int8(buf[off+5]))), true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// RawValue returns the raw value of the attribute, decoded by the interpreter registered in
+// RawInterpreters for the attribute ID, or as a little-endian 48-bit integer otherwise.
+func (sa *smartAttr) RawValue() int64 {
+	if f, ok := RawInterpreters[sa.Id]; ok {
+		return f(sa.VendorBytes)
+	}
+
+	return raw48(sa.VendorBytes)
+}
+
 // decodeVendorBytes decodes the six-byte vendor byte array based on the conversion rule passed as
 // conv. The conversion may also include the reserved byte, normalised value or worst value byte.
 func (sa *smartAttr) decodeVendorBytes(conv string) uint64 {
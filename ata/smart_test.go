@@ -0,0 +1,81 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+package ata
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRawValue(t *testing.T) {
+	assert := assert.New(t)
+
+	// Power_On_Hours with minutes packed into the upper bytes
+	attr := smartAttr{Id: 9, VendorBytes: [6]byte{0x10, 0x27, 0x00, 0x1e, 0x00, 0x00}}
+	assert.Equal(int64(10000), attr.RawValue())
+
+	// Temperature_Celsius with min / max packed into the upper bytes
+	attr = smartAttr{Id: 194, VendorBytes: [6]byte{0x24, 0x00, 0x12, 0x00, 0x37, 0x00}}
+	assert.Equal(int64(36), attr.RawValue())
+
+	// Reallocated_Sector_Ct with vendor-specific data in the upper words
+	attr = smartAttr{Id: 5, VendorBytes: [6]byte{0x08, 0x00, 0xff, 0xff, 0x00, 0x00}}
+	assert.Equal(int64(8), attr.RawValue())
+
+	// Attribute without a registered interpreter
+	attr = smartAttr{Id: 241, VendorBytes: [6]byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06}}
+	assert.Equal(int64(0x060504030201), attr.RawValue())
+}
+
+func TestTemperature(t *testing.T) {
+	assert := assert.New(t)
+
+	var smart SmartPage
+
+	_, ok := smart.Temperature()
+	assert.False(ok)
+
+	smart.Attrs[0] = smartAttr{Id: 190, VendorBytes: [6]byte{0x28}}
+	temp, ok := smart.Temperature()
+	assert.True(ok)
+	assert.InDelta(40, temp.Celsius(), 0.001)
+
+	// Temperature_Celsius takes precedence over Airflow_Temperature_Cel
+	smart.Attrs[1] = smartAttr{Id: 194, VendorBytes: [6]byte{0x24}}
+	temp, _ = smart.Temperature()
+	assert.InDelta(36, temp.Celsius(), 0.001)
+	assert.InDelta(96.8, temp.Fahrenheit(), 0.001)
+	assert.InDelta(309.15, temp.Kelvin(), 0.001)
+}
+
+func TestSMARTDataTemperature(t *testing.T) {
+	assert := assert.New(t)
+
+	buf := make([]byte, 512)
+	_, ok := SMARTDataTemperature(buf)
+	assert.False(ok)
+
+	buf[2], buf[7] = 190, 0x28
+	temp, ok := SMARTDataTemperature(buf)
+	assert.True(ok)
+	assert.InDelta(40, temp.Celsius(), 0.001)
+
+	buf[14], buf[19] = 194, 0x24
+	temp, _ = SMARTDataTemperature(buf)
+	assert.InDelta(36, temp.Celsius(), 0.001)
+}
+
+func TestMediaErrorCount(t *testing.T) {
+	assert := assert.New(t)
+
+	var smart SmartPage
+
+	assert.Equal(uint64(0), smart.MediaErrorCount())
+
+	smart.Attrs[0] = smartAttr{Id: 5, VendorBytes: [6]byte{0x08, 0x00, 0xff, 0xff}}
+	smart.Attrs[1] = smartAttr{Id: 194, VendorBytes: [6]byte{0x24}}
+	smart.Attrs[2] = smartAttr{Id: 197, VendorBytes: [6]byte{0x02}}
+	assert.Equal(uint64(10), smart.MediaErrorCount())
+}
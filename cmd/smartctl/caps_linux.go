@@ -0,0 +1,55 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	_LINUX_CAPABILITY_VERSION_3 = 0x20080522
+
+	CAP_SYS_RAWIO = 1 << 17
+	CAP_SYS_ADMIN = 1 << 21
+)
+
+type capHeader struct {
+	version uint32
+	pid     int
+}
+
+type capData struct {
+	effective   uint32
+	permitted   uint32
+	inheritable uint32
+}
+
+type capsV3 struct {
+	hdr  capHeader
+	data [2]capData
+}
+
+// checkCaps invokes the capget syscall to check for necessary capabilities. Note that this depends
+// on the binary having the capabilities set (i.e., via the `setcap` utility), and on VFS support.
+// Alternatively, if the binary is executed as root, it automatically has all capabilities set.
+func checkCaps() {
+	caps := new(capsV3)
+	caps.hdr.version = _LINUX_CAPABILITY_VERSION_3
+
+	// Use RawSyscall since we do not expect it to block
+	_, _, e1 := unix.RawSyscall(unix.SYS_CAPGET, uintptr(unsafe.Pointer(&caps.hdr)), uintptr(unsafe.Pointer(&caps.data)), 0)
+	if e1 != 0 {
+		fmt.Println("capget() failed:", e1.Error())
+		return
+	}
+
+	if (caps.data[0].effective&CAP_SYS_RAWIO == 0) && (caps.data[0].effective&CAP_SYS_ADMIN == 0) {
+		fmt.Println("Neither cap_sys_rawio nor cap_sys_admin are in effect. Device access will probably fail.")
+	}
+}
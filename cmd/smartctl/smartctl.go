@@ -11,9 +11,6 @@ import (
 	"os"
 	"runtime"
 	"strings"
-	"unsafe"
-
-	"golang.org/x/sys/unix"
 
 	"github.com/madper/smart"
 	"github.com/madper/smart/drivedb"
@@ -22,48 +19,6 @@ import (
 	"github.com/madper/smart/scsi"
 )
 
-const (
-	_LINUX_CAPABILITY_VERSION_3 = 0x20080522
-
-	CAP_SYS_RAWIO = 1 << 17
-	CAP_SYS_ADMIN = 1 << 21
-)
-
-type capHeader struct {
-	version uint32
-	pid     int
-}
-
-type capData struct {
-	effective   uint32
-	permitted   uint32
-	inheritable uint32
-}
-
-type capsV3 struct {
-	hdr  capHeader
-	data [2]capData
-}
-
-// checkCaps invokes the capget syscall to check for necessary capabilities. Note that this depends
-// on the binary having the capabilities set (i.e., via the `setcap` utility), and on VFS support.
-// Alternatively, if the binary is executed as root, it automatically has all capabilities set.
-func checkCaps() {
-	caps := new(capsV3)
-	caps.hdr.version = _LINUX_CAPABILITY_VERSION_3
-
-	// Use RawSyscall since we do not expect it to block
-	_, _, e1 := unix.RawSyscall(unix.SYS_CAPGET, uintptr(unsafe.Pointer(&caps.hdr)), uintptr(unsafe.Pointer(&caps.data)), 0)
-	if e1 != 0 {
-		fmt.Println("capget() failed:", e1.Error())
-		return
-	}
-
-	if (caps.data[0].effective&CAP_SYS_RAWIO == 0) && (caps.data[0].effective&CAP_SYS_ADMIN == 0) {
-		fmt.Println("Neither cap_sys_rawio nor cap_sys_admin are in effect. Device access will probably fail.")
-	}
-}
-
 func scanDevices() {
 	for _, device := range smart.ScanDevices() {
 		fmt.Printf("%#v\n", device)
@@ -98,8 +53,7 @@ func main() {
 		)
 
 		if strings.HasPrefix(*device, "/dev/nvme") {
-			d = nvme.NewNVMeDevice(*device)
-			err = d.Open()
+			d, err = nvme.OpenNVMeDevice(*device)
 		} else {
 			d, err = scsi.OpenSCSIAutodetect(*device)
 		}
@@ -0,0 +1,181 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+// Protocol-independent device interface.
+
+package smart
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/madper/smart/scsi"
+	"github.com/madper/smart/utils"
+)
+
+// ErrNotSupported is returned when a device does not support the requested operation.
+var ErrNotSupported = errors.New("smart: operation not supported by device")
+
+// DeviceType identifies the protocol used to communicate with a device.
+type DeviceType int
+
+const (
+	DEVICE_TYPE_UNKNOWN DeviceType = iota
+	DEVICE_TYPE_NVME
+	DEVICE_TYPE_SATA
+	DEVICE_TYPE_SCSI
+)
+
+func (t DeviceType) String() string {
+	switch t {
+	case DEVICE_TYPE_NVME:
+		return "NVMe"
+	case DEVICE_TYPE_SATA:
+		return "SATA"
+	case DEVICE_TYPE_SCSI:
+		return "SCSI"
+	default:
+		return "unknown"
+	}
+}
+
+// Special values returned by RotationRate, as defined for ATA IDENTIFY DEVICE word 217 and the
+// SCSI Block Device Characteristics VPD page
+const (
+	ROTATION_RATE_NOT_REPORTED = 0
+	ROTATION_RATE_NON_ROTATING = 1
+)
+
+// Identity holds the identifying strings reported by a device. Fields which a device does not
+// report are left empty.
+type Identity struct {
+	Model    string
+	Serial   string
+	Firmware string
+}
+
+// Device is a protocol-independent handle to an NVMe, SATA or SCSI device, as returned by Open.
+type Device interface {
+	// Close closes the device.
+	Close() error
+
+	// Type returns the protocol used to communicate with the device.
+	Type() DeviceType
+
+	// Identity returns the model, serial number and firmware revision of the device.
+	Identity() (Identity, error)
+
+	// WWN returns the World Wide Name of the device, formatted as in the Linux /dev/disk/by-id
+	// names, e.g. "0x500253885009397f" for ATA devices or "eui.0025388b71b0e7d1" for NVMe.
+	WWN() (string, error)
+
+	// SupportsSMART reports whether the device supports SMART health monitoring.
+	SupportsSMART() (bool, error)
+
+	// Temperature returns the current temperature of the device.
+	Temperature() (utils.Temperature, error)
+
+	// RotationRate returns the nominal media rotation rate of the device in RPM, or
+	// ROTATION_RATE_NON_ROTATING for solid state devices. ROTATION_RATE_NOT_REPORTED is returned if
+	// the device does not report its rotation rate.
+	RotationRate() (int, error)
+
+	// MediaErrorCount returns the number of media errors reported by the device: the Media and
+	// Data Integrity Errors count (lower 64 bits) for NVMe, the sum of reallocated and pending
+	// sectors for SATA, and the total uncorrected read and write errors for SCSI.
+	MediaErrorCount() (uint64, error)
+}
+
+// OpenOptions controls how device nodes are opened.
+type OpenOptions struct {
+	// NonBlock opens the device node with O_NONBLOCK. Some controllers block indefinitely on
+	// open() of a faulty drive, which this avoids. Commands issued to the device still block.
+	NonBlock bool
+
+	// ReadOnly opens the device node read-only, which suffices for identity and health queries
+	// and requires fewer privileges. Commands which modify the device may be rejected.
+	ReadOnly bool
+}
+
+// Open opens the named device node, auto-detecting whether it is an NVMe, SATA or SCSI device.
+func Open(name string) (Device, error) {
+	return OpenWithOptions(name, OpenOptions{})
+}
+
+// OpenWithOptions is like Open, but opens the device node as specified by opts.
+func OpenWithOptions(name string, opts OpenOptions) (Device, error) {
+	// MegaRAID physical disks returned by Scan are not device nodes
+	if isMegaRAIDName(name) {
+		return nil, ErrNotSupported
+	}
+
+	if strings.HasPrefix(name, "/dev/nvme") {
+		return openNVMe(name, opts)
+	}
+
+	d, err := scsi.OpenSCSIDeviceAutodetect(scsi.SCSIDevice{Name: name, NonBlock: opts.NonBlock, ReadOnly: opts.ReadOnly})
+	if err != nil {
+		return nil, err
+	}
+
+	switch dev := d.(type) {
+	case *scsi.SATDevice:
+		return &sataDevice{dev}, nil
+	case *scsi.SCSIDevice:
+		return &scsiDevice{dev}, nil
+	}
+
+	d.Close()
+
+	return nil, ErrNotSupported
+}
+
+// WWN opens the named device and returns its World Wide Name.
+func WWN(name string) (string, error) {
+	d, err := Open(name)
+	if err != nil {
+		return "", err
+	}
+
+	defer d.Close()
+
+	return d.WWN()
+}
+
+// SupportsSMART opens the named device and reports whether it supports SMART health monitoring.
+func SupportsSMART(name string) (bool, error) {
+	d, err := Open(name)
+	if err != nil {
+		return false, err
+	}
+
+	defer d.Close()
+
+	return d.SupportsSMART()
+}
+
+// Temperature opens the named device and returns its current temperature. Only the part of the
+// SMART data holding the temperature is decoded, so this is suitable for frequent polling. Use the
+// Celsius method of the result for a value in degrees Celsius.
+func Temperature(name string) (utils.Temperature, error) {
+	d, err := Open(name)
+	if err != nil {
+		return 0, err
+	}
+
+	defer d.Close()
+
+	return d.Temperature()
+}
+
+// compile-time interface checks
+var (
+	_ Device = (*nvmeDevice)(nil)
+	_ Device = (*sataDevice)(nil)
+	_ Device = (*scsiDevice)(nil)
+	_ Device = (*MockDevice)(nil)
+	_ Device = (*nvmeDump)(nil)
+	_ Device = (*sataDump)(nil)
+
+	_ NamespaceDevice = (*nvmeDevice)(nil)
+)
@@ -0,0 +1,130 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+// Device interface backed by raw data dumps, for offline analysis.
+
+package smart
+
+import (
+	"errors"
+	"io/ioutil"
+
+	"github.com/madper/smart/ata"
+	"github.com/madper/smart/nvme"
+	"github.com/madper/smart/utils"
+)
+
+// OpenDump reads a dump of raw device data structures from the named file, and returns a Device
+// which answers queries from the dump instead of a real device. The dump layout for each device
+// type is documented in the nvme (NVMe) and ata (SATA) packages. SCSI dumps are not supported.
+func OpenDump(path string, devType DeviceType) (Device, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch devType {
+	case DEVICE_TYPE_NVME:
+		dump, err := nvme.ParseDump(b)
+		if err != nil {
+			return nil, err
+		}
+
+		return &nvmeDump{dump}, nil
+	case DEVICE_TYPE_SATA:
+		dump, err := ata.ParseDump(b)
+		if err != nil {
+			return nil, err
+		}
+
+		return &sataDump{dump}, nil
+	}
+
+	return nil, ErrNotSupported
+}
+
+// nvmeDump implements the Device interface for an NVMe dump.
+type nvmeDump struct {
+	dump *nvme.Dump
+}
+
+func (d *nvmeDump) Close() error {
+	return nil
+}
+
+func (d *nvmeDump) Type() DeviceType {
+	return DEVICE_TYPE_NVME
+}
+
+func (d *nvmeDump) Identity() (Identity, error) {
+	return nvmeIdentity(&d.dump.Controller), nil
+}
+
+func (d *nvmeDump) WWN() (string, error) {
+	return nvmeWWN(&d.dump.Namespace)
+}
+
+func (d *nvmeDump) SupportsSMART() (bool, error) {
+	return true, nil
+}
+
+func (d *nvmeDump) Temperature() (utils.Temperature, error) {
+	return d.dump.SMART.CompositeTemperature(), nil
+}
+
+func (d *nvmeDump) RotationRate() (int, error) {
+	return ROTATION_RATE_NON_ROTATING, nil
+}
+
+func (d *nvmeDump) MediaErrorCount() (uint64, error) {
+	return nvmeMediaErrors(&d.dump.SMART), nil
+}
+
+func (d *nvmeDump) wear() (availSpare, percentUsed uint8, err error) {
+	return d.dump.SMART.AvailSpare, d.dump.SMART.PercentUsed, nil
+}
+
+func (d *nvmeDump) nvmeSMART() (*nvme.NVMeSMARTData, error) {
+	return &d.dump.SMART, nil
+}
+
+// sataDump implements the Device interface for an ATA dump.
+type sataDump struct {
+	dump *ata.Dump
+}
+
+func (d *sataDump) Close() error {
+	return nil
+}
+
+func (d *sataDump) Type() DeviceType {
+	return DEVICE_TYPE_SATA
+}
+
+func (d *sataDump) Identity() (Identity, error) {
+	return sataIdentity(&d.dump.Identify), nil
+}
+
+func (d *sataDump) WWN() (string, error) {
+	return sataWWN(&d.dump.Identify)
+}
+
+func (d *sataDump) SupportsSMART() (bool, error) {
+	return d.dump.Identify.SMARTSupported(), nil
+}
+
+func (d *sataDump) Temperature() (utils.Temperature, error) {
+	if t, ok := d.dump.SMART.Temperature(); ok {
+		return t, nil
+	}
+
+	return 0, errors.New("dump does not contain a temperature attribute")
+}
+
+func (d *sataDump) RotationRate() (int, error) {
+	return sataRotationRate(&d.dump.Identify), nil
+}
+
+func (d *sataDump) MediaErrorCount() (uint64, error) {
+	return d.dump.SMART.MediaErrorCount(), nil
+}
@@ -0,0 +1,55 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+package smart
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/madper/smart/nvme"
+)
+
+func TestOpenDump(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "smart")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	b := make([]byte, nvme.NVME_DUMP_SIZE)
+	copy(b[nvme.NVME_DUMP_CONTROLLER_OFFSET+24:], "Samsung SSD 960 PRO 512GB    ")
+	copy(b[nvme.NVME_DUMP_NAMESPACE_OFFSET+120:], []byte{0x00, 0x25, 0x38, 0x8b, 0x71, 0xb0, 0xe7, 0xd1})
+	b[nvme.NVME_DUMP_SMART_OFFSET+1] = 0x38 // 312 Kelvin
+	b[nvme.NVME_DUMP_SMART_OFFSET+2] = 0x01
+
+	path := filepath.Join(dir, "nvme0.dump")
+	assert.NoError(ioutil.WriteFile(path, b, 0644))
+
+	d, err := OpenDump(path, DEVICE_TYPE_NVME)
+	assert.NoError(err)
+
+	ident, _ := d.Identity()
+	assert.Equal("Samsung SSD 960 PRO 512GB", ident.Model)
+
+	wwn, _ := d.WWN()
+	assert.Equal("eui.0025388b71b0e7d1", wwn)
+
+	temp, _ := d.Temperature()
+	assert.InDelta(312, temp.Kelvin(), 0.001)
+
+	rpm, _ := d.RotationRate()
+	assert.Equal(ROTATION_RATE_NON_ROTATING, rpm)
+
+	// Truncated dump
+	assert.NoError(ioutil.WriteFile(path, b[:4096], 0644))
+	_, err = OpenDump(path, DEVICE_TYPE_NVME)
+	assert.Error(err)
+
+	_, err = OpenDump(path, DEVICE_TYPE_SCSI)
+	assert.Equal(ErrNotSupported, err)
+}
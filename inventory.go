@@ -0,0 +1,205 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+// Concurrent inventory and health collection for all devices in the system.
+
+package smart
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/madper/smart/nvme"
+	"github.com/madper/smart/utils"
+)
+
+const (
+	// Default maximum number of devices queried concurrently by InventoryReport
+	inventoryConcurrency = 8
+
+	// Maximum difference (in degrees) between the composite temperature of an NVMe device and the
+	// nearest of its temperature sensors before the composite temperature is considered bogus
+	compositeTemperatureTolerance = 20
+)
+
+// SMARTSummary is a brief summary of the health of a device.
+type SMARTSummary struct {
+	Supported   bool
+	Temperature utils.Temperature // Zero if not reported by the device
+	Warnings    []string          // Inconsistencies found in the reported health information
+}
+
+// nvmeSMARTReporter is implemented by NVMe devices, which report their full SMART / Health
+// Information log.
+type nvmeSMARTReporter interface {
+	nvmeSMART() (*nvme.NVMeSMARTData, error)
+}
+
+// DeviceReport is the inventory and health information collected for a single device. If any
+// step of the collection failed, Err holds the first error, and the information collected
+// thus far.
+type DeviceReport struct {
+	Path     string
+	Type     DeviceType
+	Identity Identity
+	SMART    SMARTSummary
+	Err      error
+}
+
+type indexedReport struct {
+	index  int
+	report DeviceReport
+}
+
+// InventoryReport discovers all devices in the system with Scan (using the default scan options),
+// and collects their identity and SMART summary concurrently. If ctx is done before all devices
+// have been queried, the reports collected thus far are returned along with ctx.Err(), and the
+// Err field of the remaining reports is set to ctx.Err(). Note that commands already issued to
+// devices cannot be cancelled, so goroutines querying unresponsive devices may outlive the call.
+func InventoryReport(ctx context.Context) ([]DeviceReport, error) {
+	return InventoryReportWithOptions(ctx, ScanOptions{})
+}
+
+// InventoryReportWithOptions is like InventoryReport, but discovers devices with the specified
+// scan options, and throttles the queries according to their Concurrency and MaxRate. MegaRAID
+// physical disks (see ScanOptions.IncludeMegaRAID) are not included in the report, since they
+// cannot be opened as devices.
+func InventoryReportWithOptions(ctx context.Context, opts ScanOptions) ([]DeviceReport, error) {
+	scanned, err := Scan(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, path := range scanned {
+		if !isMegaRAIDName(path) {
+			paths = append(paths, path)
+		}
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = inventoryConcurrency
+	}
+
+	var throttle <-chan time.Time
+	if opts.MaxRate > 0 {
+		// Rates beyond one query per nanosecond are effectively unlimited
+		if interval := time.Duration(float64(time.Second) / opts.MaxRate); interval > 0 {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			throttle = ticker.C
+		}
+	}
+
+	reports := make([]DeviceReport, len(paths))
+	for i, path := range paths {
+		reports[i].Path = path
+	}
+
+	// Buffered, so that workers finishing after a deadline do not block forever
+	results := make(chan indexedReport, len(paths))
+	sem := make(chan struct{}, concurrency)
+
+	// Stops the dispatcher once all reports have been received, or the deadline has passed
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		for i, path := range paths {
+			if throttle != nil && i > 0 {
+				select {
+				case <-throttle:
+				case <-ctx.Done():
+					return
+				case <-done:
+					return
+				}
+			}
+
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+
+			go func(i int, path string) {
+				defer func() { <-sem }()
+				results <- indexedReport{i, collectReport(path)}
+			}(i, path)
+		}
+	}()
+
+	received := make([]bool, len(paths))
+
+	for n := 0; n < len(paths); n++ {
+		select {
+		case r := <-results:
+			reports[r.index] = r.report
+			received[r.index] = true
+		case <-ctx.Done():
+			for i := range reports {
+				if !received[i] {
+					reports[i].Err = ctx.Err()
+				}
+			}
+
+			return reports, ctx.Err()
+		}
+	}
+
+	return reports, nil
+}
+
+// collectReport opens the named device and collects its inventory and health information.
+func collectReport(path string) DeviceReport {
+	r := DeviceReport{Path: path}
+
+	// Avoid hanging the whole report on the open of a single faulty device
+	d, err := OpenWithOptions(path, OpenOptions{NonBlock: true})
+	if err != nil {
+		r.Err = err
+		return r
+	}
+
+	defer d.Close()
+
+	r.Type = d.Type()
+
+	if r.Identity, r.Err = d.Identity(); r.Err != nil {
+		return r
+	}
+
+	if r.SMART.Supported, r.Err = d.SupportsSMART(); r.Err != nil || !r.SMART.Supported {
+		return r
+	}
+
+	// Not all devices report their temperature, which is not considered an error. NVMe devices
+	// report it in the SMART / Health Information log, which is only read once for both the
+	// temperature and the warnings.
+	if nr, ok := d.(nvmeSMARTReporter); ok {
+		if sl, err := nr.nvmeSMART(); err == nil {
+			r.SMART.Temperature = sl.CompositeTemperature()
+			r.SMART.Warnings = nvmeSMARTWarnings(sl)
+		}
+	} else if t, err := d.Temperature(); err == nil {
+		r.SMART.Temperature = t
+	}
+
+	return r
+}
+
+// nvmeSMARTWarnings cross-checks the health information reported in an NVMe SMART / Health
+// Information log, and describes any inconsistencies found.
+func nvmeSMARTWarnings(sl *nvme.NVMeSMARTData) []string {
+	var warnings []string
+
+	if sl.CompositeTemperatureMismatch(compositeTemperatureTolerance) {
+		warnings = append(warnings, fmt.Sprintf(
+			"composite temperature %s differs by more than %d degrees from all sensors %v",
+			sl.CompositeTemperature(), compositeTemperatureTolerance, sl.SensorTemperatures()))
+	}
+
+	return warnings
+}
@@ -0,0 +1,26 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+package smart
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/madper/smart/nvme"
+)
+
+func TestNVMeSMARTWarnings(t *testing.T) {
+	assert := assert.New(t)
+
+	sl := nvme.NVMeSMARTData{Temperature: [2]uint8{0x38, 0x01}} // 312 Kelvin
+	sl.TempSensor[0] = 315
+	assert.Empty(nvmeSMARTWarnings(&sl))
+
+	// Temperature sensor far from the composite temperature
+	sl.TempSensor[0] = 346
+	warnings := nvmeSMARTWarnings(&sl)
+	assert.Len(warnings, 1)
+	assert.Contains(warnings[0], "composite temperature")
+}
@@ -7,9 +7,12 @@
 package ioctl
 
 import (
-	"golang.org/x/sys/unix"
+	"errors"
 )
 
+// ErrUnsupportedPlatform is returned by device entry points on platforms other than Linux.
+var ErrUnsupportedPlatform = errors.New("smart: platform not supported")
+
 const (
 	directionNone  = 0
 	directionWrite = 1
@@ -36,6 +39,11 @@ func _ioc(dir, t, nr, size uintptr) uintptr {
 	return (dir << directionShift) | (t << typeShift) | (nr << numberShift) | (size << sizeShift)
 }
 
+// Io calculates the ioctl command for an ioctl of the specified type and number without data
+func Io(t, nr uintptr) uintptr {
+	return _ioc(directionNone, t, nr, 0)
+}
+
 // Ior calculates the ioctl command for a read-ioctl of the specified type, number and size
 func Ior(t, nr, size uintptr) uintptr {
 	return _ioc(directionRead, t, nr, size)
@@ -50,12 +58,3 @@ func Iow(t, nr, size uintptr) uintptr {
 func Iowr(t, nr, size uintptr) uintptr {
 	return _ioc(directionWrite|directionRead, t, nr, size)
 }
-
-// ioctl executes an ioctl command on the specified file descriptor
-func Ioctl(fd, cmd, ptr uintptr) error {
-	_, _, errno := unix.Syscall(unix.SYS_IOCTL, fd, cmd, ptr)
-	if errno != 0 {
-		return errno
-	}
-	return nil
-}
@@ -0,0 +1,27 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+//go:build linux
+
+package ioctl
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// ioctl executes an ioctl command on the specified file descriptor
+func Ioctl(fd, cmd, ptr uintptr) error {
+	_, err := IoctlResult(fd, cmd, ptr)
+	return err
+}
+
+// IoctlResult executes an ioctl command on the specified file descriptor, and also returns the
+// non-negative return value of the ioctl system call, which some drivers use to report a
+// command-specific status.
+func IoctlResult(fd, cmd, ptr uintptr) (uintptr, error) {
+	r, _, errno := unix.Syscall(unix.SYS_IOCTL, fd, cmd, ptr)
+	if errno != 0 {
+		return 0, errno
+	}
+	return r, nil
+}
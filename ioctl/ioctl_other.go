@@ -0,0 +1,16 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+//go:build !linux
+
+package ioctl
+
+// Ioctl is not supported on this platform and always returns ErrUnsupportedPlatform.
+func Ioctl(fd, cmd, ptr uintptr) error {
+	return ErrUnsupportedPlatform
+}
+
+// IoctlResult is not supported on this platform and always returns ErrUnsupportedPlatform.
+func IoctlResult(fd, cmd, ptr uintptr) (uintptr, error) {
+	return 0, ErrUnsupportedPlatform
+}
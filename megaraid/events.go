@@ -0,0 +1,156 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+// MegaRAID controller event log.
+
+package megaraid
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/madper/smart/utils"
+)
+
+const (
+	MR_DCMD_CTRL_EVENT_GET = 0x01040300
+
+	// Event classes (enum mfi_evt_class)
+	MFI_EVT_CLASS_DEBUG    = -2
+	MFI_EVT_CLASS_PROGRESS = -1
+	MFI_EVT_CLASS_INFO     = 0
+	MFI_EVT_CLASS_WARNING  = 1
+	MFI_EVT_CLASS_CRITICAL = 2
+	MFI_EVT_CLASS_FATAL    = 3
+	MFI_EVT_CLASS_DEAD     = 4
+
+	// Event locale matching all events
+	MFI_EVT_LOCALE_ALL = 0xffff
+
+	// Size of struct mfi_evt_detail
+	eventDetailSize = 256
+
+	// Number of events requested per MR_DCMD_CTRL_EVENT_GET command
+	eventBatchSize = 32
+)
+
+// The controller clock counts seconds from 2000-01-01 00:00:00 UTC
+var mfiEpoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// EventClass is the severity class of a controller event.
+type EventClass int8
+
+func (c EventClass) String() string {
+	switch c {
+	case MFI_EVT_CLASS_DEBUG:
+		return "Debug"
+	case MFI_EVT_CLASS_PROGRESS:
+		return "Progress"
+	case MFI_EVT_CLASS_INFO:
+		return "Info"
+	case MFI_EVT_CLASS_WARNING:
+		return "Warning"
+	case MFI_EVT_CLASS_CRITICAL:
+		return "Critical"
+	case MFI_EVT_CLASS_FATAL:
+		return "Fatal"
+	case MFI_EVT_CLASS_DEAD:
+		return "Dead"
+	default:
+		return fmt.Sprintf("Unknown (%d)", int8(c))
+	}
+}
+
+// MegasasEvent is an entry of the controller event log (struct mfi_evt_detail).
+type MegasasEvent struct {
+	SeqNum      uint32        // Sequence number
+	Time        time.Time     // Zero if the controller clock was not set when the event occurred
+	SinceBoot   time.Duration // Time since controller boot, if Time is zero
+	Code        uint32        // Event code
+	Locale      uint16        // Event locale (bitmap of affected components)
+	Class       EventClass    // Event class
+	ArgType     uint8         // Type of the event arguments
+	Args        [96]byte      // Undecoded event arguments
+	Description string        // Human-readable description, formatted by the controller firmware
+}
+
+func (e *MegasasEvent) String() string {
+	ts := e.Time.Format(time.RFC3339)
+	if e.Time.IsZero() {
+		ts = fmt.Sprintf("boot+%s", e.SinceBoot)
+	}
+
+	return fmt.Sprintf("%d %s %s: %s", e.SeqNum, ts, e.Class, e.Description)
+}
+
+// GetEventLog reads the events of all classes and locales logged by the controller of the
+// specified host, starting with the specified sequence number, in increasing order of sequence
+// number.
+func (m *MegasasIoctl) GetEventLog(host uint16, startSeq uint32) ([]MegasasEvent, error) {
+	var events []MegasasEvent
+
+	// Lowest class, i.e. events of all classes
+	class := int8(MFI_EVT_CLASS_DEBUG)
+
+	// struct mfi_evt_list: count, reserved, followed by the events
+	respBuf := make([]byte, 8+eventBatchSize*eventDetailSize)
+
+	for seq := startSeq; ; {
+		// mbox holds the start sequence number, followed by struct mfi_evt (locale, class)
+		var mbox [12]byte
+		utils.NativeEndian.PutUint32(mbox[0:], seq)
+		utils.NativeEndian.PutUint16(mbox[4:], MFI_EVT_LOCALE_ALL)
+		mbox[7] = byte(class)
+
+		if err := m.DCMD(host, MR_DCMD_CTRL_EVENT_GET, mbox, respBuf); err != nil {
+			// No events were logged after the start sequence number
+			if e, ok := err.(*MFIError); ok && e.Status == MFI_STAT_NOT_FOUND {
+				break
+			}
+
+			return nil, err
+		}
+
+		count := int(utils.NativeEndian.Uint32(respBuf))
+		if count > eventBatchSize {
+			count = eventBatchSize
+		}
+
+		for i := 0; i < count; i++ {
+			off := 8 + i*eventDetailSize
+			events = append(events, decodeEvent(respBuf[off:off+eventDetailSize]))
+		}
+
+		if count < eventBatchSize {
+			break
+		}
+
+		seq = events[len(events)-1].SeqNum + 1
+	}
+
+	return events, nil
+}
+
+// decodeEvent decodes a 256-byte struct mfi_evt_detail.
+func decodeEvent(buf []byte) MegasasEvent {
+	e := MegasasEvent{
+		SeqNum:      utils.NativeEndian.Uint32(buf[0:]),
+		Code:        utils.NativeEndian.Uint32(buf[8:]),
+		Locale:      utils.NativeEndian.Uint16(buf[12:]),
+		Class:       EventClass(int8(buf[15])),
+		ArgType:     buf[16],
+		Description: string(bytes.TrimRight(buf[128:256], "\x00 ")),
+	}
+
+	copy(e.Args[:], buf[32:128])
+
+	// If the upper byte is 0xff, the lower 24 bits hold seconds since controller boot
+	if ts := utils.NativeEndian.Uint32(buf[4:]); ts>>24 == 0xff {
+		e.SinceBoot = time.Duration(ts&0xffffff) * time.Second
+	} else {
+		e.Time = mfiEpoch.Add(time.Duration(ts) * time.Second)
+	}
+
+	return e
+}
@@ -0,0 +1,45 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+package megaraid
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/madper/smart/utils"
+)
+
+func TestDecodeEvent(t *testing.T) {
+	assert := assert.New(t)
+
+	buf := make([]byte, eventDetailSize)
+	utils.NativeEndian.PutUint32(buf[0:], 4711)
+	utils.NativeEndian.PutUint32(buf[4:], 86400) // 2000-01-02 00:00:00 UTC
+	utils.NativeEndian.PutUint32(buf[8:], 0x0071)
+	utils.NativeEndian.PutUint16(buf[12:], 0x0002)
+	buf[15] = 0xff // MFI_EVT_CLASS_PROGRESS
+	buf[16] = 0x01
+	buf[32] = 0xaa
+	copy(buf[128:], "Rebuild progress on PD 0c(e0x20/s12) is 50.00%(600s)")
+
+	e := decodeEvent(buf)
+	assert.Equal(uint32(4711), e.SeqNum)
+	assert.Equal(time.Date(2000, time.January, 2, 0, 0, 0, 0, time.UTC), e.Time)
+	assert.Zero(e.SinceBoot)
+	assert.Equal(uint32(0x0071), e.Code)
+	assert.Equal(uint16(0x0002), e.Locale)
+	assert.Equal(EventClass(MFI_EVT_CLASS_PROGRESS), e.Class)
+	assert.Equal("Progress", e.Class.String())
+	assert.Equal(uint8(0x01), e.ArgType)
+	assert.Equal(uint8(0xaa), e.Args[0])
+	assert.Equal("Rebuild progress on PD 0c(e0x20/s12) is 50.00%(600s)", e.Description)
+
+	// Timestamps with an upper byte of 0xff are relative to controller boot
+	utils.NativeEndian.PutUint32(buf[4:], 0xff000e10)
+	e = decodeEvent(buf)
+	assert.True(e.Time.IsZero())
+	assert.Equal(time.Hour, e.SinceBoot)
+}
@@ -0,0 +1,67 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+// MegaRAID foreign configuration detection.
+
+package megaraid
+
+import (
+	"bytes"
+
+	"github.com/madper/smart/utils"
+)
+
+const (
+	MR_DCMD_CFG_FOREIGN_SCAN = 0x04060100
+
+	// Maximum number of foreign configurations reported by MR_DCMD_CFG_FOREIGN_SCAN
+	MAX_FOREIGN_CONFIGS = 8
+
+	// Size of each struct mfi_foreign_scan_cfg in the scan response, and of the name it begins
+	// with (the remainder is reserved)
+	foreignConfigLen     = 48
+	foreignConfigNameLen = 24
+)
+
+// ForeignConfig describes the foreign configurations found by the controller, i.e. array
+// configurations present on physical disks which were moved from another controller. These must
+// be imported or cleared before the disks can be used.
+type ForeignConfig struct {
+	Names []string // Name (GUID) of each foreign configuration
+}
+
+// Present reports whether any foreign configurations were found.
+func (fc *ForeignConfig) Present() bool {
+	return len(fc.Names) > 0
+}
+
+// GetForeignConfig scans the physical disks attached to the specified host for foreign
+// configurations.
+func (m *MegasasIoctl) GetForeignConfig(host uint16) (*ForeignConfig, error) {
+	// struct mfi_foreign_scan_info: count, followed by a struct mfi_foreign_scan_cfg for each
+	// configuration
+	respBuf := make([]byte, 4+MAX_FOREIGN_CONFIGS*foreignConfigLen)
+
+	if err := m.MFI(host, MR_DCMD_CFG_FOREIGN_SCAN, respBuf); err != nil {
+		return nil, err
+	}
+
+	return decodeForeignConfig(respBuf), nil
+}
+
+func decodeForeignConfig(buf []byte) *ForeignConfig {
+	var fc ForeignConfig
+
+	count := int(utils.NativeEndian.Uint32(buf))
+	if count > MAX_FOREIGN_CONFIGS {
+		count = MAX_FOREIGN_CONFIGS
+	}
+
+	for i := 0; i < count; i++ {
+		off := 4 + i*foreignConfigLen
+		name := bytes.TrimRight(buf[off:off+foreignConfigNameLen], "\x00 ")
+		fc.Names = append(fc.Names, string(name))
+	}
+
+	return &fc
+}
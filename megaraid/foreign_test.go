@@ -0,0 +1,32 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+package megaraid
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/madper/smart/utils"
+)
+
+func TestDecodeForeignConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	buf := make([]byte, 4+MAX_FOREIGN_CONFIGS*foreignConfigLen)
+	assert.False(decodeForeignConfig(buf).Present())
+
+	utils.NativeEndian.PutUint32(buf, 2)
+	copy(buf[4:], "5f0a3c21-first")
+	copy(buf[4+foreignConfigNameLen:], "reserved") // Must not be taken as a name
+	copy(buf[4+foreignConfigLen:], "5f0a3c22-second  ")
+
+	fc := decodeForeignConfig(buf)
+	assert.True(fc.Present())
+	assert.Equal([]string{"5f0a3c21-first", "5f0a3c22-second"}, fc.Names)
+
+	// Implausible counts are clamped to MAX_FOREIGN_CONFIGS
+	utils.NativeEndian.PutUint32(buf, 1000)
+	assert.Len(decodeForeignConfig(buf).Names, MAX_FOREIGN_CONFIGS)
+}
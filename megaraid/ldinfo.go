@@ -0,0 +1,123 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+// MegaRAID logical drive cache policy.
+
+package megaraid
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+const (
+	MR_DCMD_LD_GET_PROPERTIES = 0x03030000
+
+	// Logical drive cache policy bits
+	MR_LD_CACHE_WRITE_BACK          = 0x01
+	MR_LD_CACHE_WRITE_ADAPTIVE      = 0x02
+	MR_LD_CACHE_READ_AHEAD          = 0x04
+	MR_LD_CACHE_READ_ADAPTIVE       = 0x08
+	MR_LD_CACHE_WRITE_CACHE_BAD_BBU = 0x10 // Use write-back even if the BBU is bad
+	MR_LD_CACHE_ALLOW_WRITE_CACHE   = 0x20
+	MR_LD_CACHE_ALLOW_READ_CACHE    = 0x40
+
+	// Physical disk cache policies
+	MR_PD_CACHE_UNCHANGED = 0x00
+	MR_PD_CACHE_ENABLE    = 0x01
+	MR_PD_CACHE_DISABLE   = 0x02
+)
+
+// DiskCachePolicy is the cache policy applied to the physical disks of a logical drive.
+type DiskCachePolicy uint8
+
+func (p DiskCachePolicy) String() string {
+	switch p {
+	case MR_PD_CACHE_UNCHANGED:
+		return "Disk's Default"
+	case MR_PD_CACHE_ENABLE:
+		return "Enabled"
+	case MR_PD_CACHE_DISABLE:
+		return "Disabled"
+	default:
+		return fmt.Sprintf("Unknown (%#02x)", uint8(p))
+	}
+}
+
+// CachePolicy is the cache policy of a logical drive. The controller may override the configured
+// (default) policy, e.g. switching from write-back to write-through while the BBU is failed or
+// relearning, in which case the current policy differs from the default one.
+type CachePolicy struct {
+	Name          string          // Logical drive name
+	DefaultPolicy uint8           // Configured policy, MR_LD_CACHE_* bits
+	CurrentPolicy uint8           // Policy in effect, MR_LD_CACHE_* bits
+	DiskCache     DiskCachePolicy // Physical disk cache policy
+}
+
+// WriteBack reports whether write-back caching is currently in effect.
+func (p *CachePolicy) WriteBack() bool {
+	return p.CurrentPolicy&MR_LD_CACHE_WRITE_BACK != 0
+}
+
+// ReadAhead reports whether read-ahead is currently in effect.
+func (p *CachePolicy) ReadAhead() bool {
+	return p.CurrentPolicy&MR_LD_CACHE_READ_AHEAD != 0
+}
+
+// Degraded reports whether the logical drive is configured for write-back caching, but the
+// controller has currently fallen back to write-through.
+func (p *CachePolicy) Degraded() bool {
+	return p.DefaultPolicy&MR_LD_CACHE_WRITE_BACK != 0 && !p.WriteBack()
+}
+
+func (p *CachePolicy) String() string {
+	return fmt.Sprintf("current: %s, default: %s, disk cache: %s",
+		cachePolicyString(p.CurrentPolicy), cachePolicyString(p.DefaultPolicy), p.DiskCache)
+}
+
+// cachePolicyString describes MR_LD_CACHE_* bits in the style of MegaCli, e.g. "WB, RA".
+func cachePolicyString(policy uint8) string {
+	s := []string{"WT"}
+	if policy&MR_LD_CACHE_WRITE_BACK != 0 {
+		s[0] = "WB"
+	}
+
+	if policy&MR_LD_CACHE_READ_AHEAD != 0 {
+		s = append(s, "RA")
+	} else {
+		s = append(s, "NORA")
+	}
+
+	if policy&MR_LD_CACHE_WRITE_CACHE_BAD_BBU != 0 {
+		s = append(s, "Write Cache OK if Bad BBU")
+	}
+
+	return strings.Join(s, ", ")
+}
+
+// GetLDCachePolicy retrieves the cache policy of the specified logical drive on the specified
+// host.
+func (m *MegasasIoctl) GetLDCachePolicy(host uint16, ldID uint8) (*CachePolicy, error) {
+	// struct mfi_ld_props
+	respBuf := make([]byte, 32)
+
+	var mbox [12]byte
+	mbox[0] = ldID
+
+	if err := m.DCMD(host, MR_DCMD_LD_GET_PROPERTIES, mbox, respBuf); err != nil {
+		return nil, err
+	}
+
+	return decodeLDProperties(respBuf), nil
+}
+
+// decodeLDProperties decodes the cache policy fields of the 32-byte struct mfi_ld_props.
+func decodeLDProperties(buf []byte) *CachePolicy {
+	return &CachePolicy{
+		Name:          string(bytes.TrimRight(buf[4:20], "\x00 ")),
+		DefaultPolicy: buf[20],
+		DiskCache:     DiskCachePolicy(buf[22]),
+		CurrentPolicy: buf[23],
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+package megaraid
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeLDProperties(t *testing.T) {
+	assert := assert.New(t)
+
+	// struct mfi_ld_props of logical drive 0, named "data", configured as WB, RA with the disk
+	// cache disabled, but currently WT, NORA due to a failed BBU
+	buf := []byte{
+		0x00, 0x00, 0x00, 0x00, // LD reference: target ID, reserved, sequence number
+		'd', 'a', 't', 'a', 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x05, 0x00, 0x02, 0x00, // default policy, access policy, disk cache policy, current policy
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+
+	p := decodeLDProperties(buf)
+	assert.Equal("data", p.Name)
+	assert.False(p.WriteBack())
+	assert.False(p.ReadAhead())
+	assert.True(p.Degraded())
+	assert.Equal(DiskCachePolicy(MR_PD_CACHE_DISABLE), p.DiskCache)
+	assert.Equal("current: WT, NORA, default: WB, RA, disk cache: Disabled", p.String())
+
+	// Space padded name, running with the configured WB, RA policy
+	copy(buf[4:20], "boot volume     ")
+	buf[20] = MR_LD_CACHE_WRITE_BACK | MR_LD_CACHE_READ_AHEAD | MR_LD_CACHE_WRITE_CACHE_BAD_BBU
+	buf[22] = MR_PD_CACHE_UNCHANGED
+	buf[23] = buf[20]
+
+	p = decodeLDProperties(buf)
+	assert.Equal("boot volume", p.Name)
+	assert.True(p.WriteBack())
+	assert.True(p.ReadAhead())
+	assert.False(p.Degraded())
+	assert.Equal("WB, RA, Write Cache OK if Bad BBU", cachePolicyString(p.CurrentPolicy))
+	assert.Equal("Disk's Default", p.DiskCache.String())
+}
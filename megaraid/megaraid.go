@@ -9,19 +9,17 @@
 package megaraid
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"unsafe"
 
-	"golang.org/x/sys/unix"
-
 	"github.com/madper/smart/ata"
 	"github.com/madper/smart/drivedb"
 	"github.com/madper/smart/ioctl"
@@ -32,6 +30,9 @@ import (
 const (
 	SYSFS_SCSI_HOST_DIR = "/sys/class/scsi_host"
 
+	// Device node created by CreateMegasasIoctl for the megaraid_sas ioctl character device
+	MEGASAS_IOCTL_NODE = "/dev/megaraid_sas_ioctl_node"
+
 	MAX_IOCTL_SGE = 16
 
 	MFI_CMD_PD_SCSI_IO = 0x04
@@ -40,13 +41,21 @@ const (
 	MR_DCMD_CTRL_GET_INFO = 0x01010000
 	MR_DCMD_PD_GET_LIST   = 0x02010000 // Obsolete / deprecated command
 	MR_DCMD_PD_LIST_QUERY = 0x02010100
+	MR_DCMD_PD_GET_INFO   = 0x02020000
 
 	MFI_FRAME_DIR_NONE  = 0x0000
 	MFI_FRAME_DIR_WRITE = 0x0008
 	MFI_FRAME_DIR_READ  = 0x0010
 	MFI_FRAME_DIR_BOTH  = 0x0018
+
+	// Maximum number of physical devices per controller (MEGASAS_MAX_PD)
+	MAX_PHYSICAL_DEVICES = 256
 )
 
+// ErrMegaRAIDNotPresent is returned by CreateMegasasIoctl if the megaraid_sas_ioctl character
+// device is not listed in /proc/devices, i.e. the megaraid_sas driver is not loaded.
+var ErrMegaRAIDNotPresent = errors.New("megaraid_sas_ioctl device not found in /proc/devices")
+
 type megasas_sge64 struct {
 	phys_addr uint32
 	length    uint32
@@ -117,10 +126,47 @@ type MegasasPDAddress struct {
 	SASAddr           [2]uint64
 }
 
+// MegasasIoctlOptions controls how CreateMegasasIoctlWithOptions opens the ioctl device.
+type MegasasIoctlOptions struct {
+	// DevicePath is the path of an existing megaraid_sas ioctl device node, e.g. one created by
+	// udev or bind-mounted into a container. If set, the node is opened as is, without looking up
+	// the device major number in /proc/devices or creating the node with mknod. If empty, the node
+	// is created at MEGASAS_IOCTL_NODE if necessary.
+	DevicePath string
+
+	// Logger receives diagnostic messages of the MegasasIoctl, see MegasasIoctl.Logger.
+	Logger Logger
+}
+
+// Logger is the interface through which MegasasIoctl logs diagnostic messages. It is satisfied by
+// *log.Logger.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// nopLogger is the default Logger, which discards all messages.
+type nopLogger struct{}
+
+func (nopLogger) Printf(format string, v ...interface{}) {}
+
 // Holder for megaraid_sas ioctl device
 type MegasasIoctl struct {
 	DeviceMajor uint32
-	fd          int
+
+	// Logger receives diagnostic messages, e.g. commands rejected by the controller firmware. If
+	// nil, messages are discarded.
+	Logger Logger
+
+	fd int
+}
+
+// logger returns the Logger of m, or a no-op Logger if none is set.
+func (m *MegasasIoctl) logger() Logger {
+	if m.Logger == nil {
+		return nopLogger{}
+	}
+
+	return m.Logger
 }
 
 type MegasasDevice struct {
@@ -143,74 +189,52 @@ func (ioc *megasas_iocpacket) PackedBytes() []byte {
 	return b.Bytes()
 }
 
-// CreateMegasasIoctl determines the device ID for the MegaRAID SAS ioctl device, creates it
-// if necessary, and returns a MegasasIoctl struct to interact with the megaraid_sas driver.
-func CreateMegasasIoctl() (MegasasIoctl, error) {
-	var (
-		m   MegasasIoctl
-		err error
-	)
-
-	// megaraid_sas driver does not automatically create ioctl device node, so find out the device
-	// major number and create it.
-	if file, err := os.Open("/proc/devices"); err == nil {
-		defer file.Close()
-
-		scanner := bufio.NewScanner(file)
-		for scanner.Scan() {
-			if strings.HasSuffix(scanner.Text(), "megaraid_sas_ioctl") {
-				if _, err := fmt.Sscanf(scanner.Text(), "%d", &m.DeviceMajor); err == nil {
-					break
-				}
-			}
-		}
-
-		if m.DeviceMajor == 0 {
-			log.Println("Could not determine megaraid major number!")
-			return m, nil
-		}
-
-		unix.Mknod("/dev/megaraid_sas_ioctl_node", unix.S_IFCHR, int(unix.Mkdev(m.DeviceMajor, 0)))
-	} else {
-		return m, err
-	}
-
-	m.fd, err = unix.Open("/dev/megaraid_sas_ioctl_node", unix.O_RDWR, 0600)
-
-	if err != nil {
-		return m, err
-	}
-
-	return m, nil
-}
-
-// Close closes the file descriptor of the MegasasIoctl instance
-func (m *MegasasIoctl) Close() {
-	unix.Close(m.fd)
-}
-
 // MFI sends a MegaRAID Firmware Interface (MFI) command to the specified host
 func (m *MegasasIoctl) MFI(host uint16, opcode uint32, b []byte) error {
+	return m.DCMD(host, opcode, [12]byte{}, b)
+}
+
+// DCMD sends a MFI direct command (DCMD) to the specified host, with the command-specific
+// parameters in mbox, which the firmware interprets as bytes, words or dwords depending on the
+// opcode. The driver passes the contents of b to the controller, and copies the data returned by
+// the controller back into b, which may be empty for commands without a data transfer.
+func (m *MegasasIoctl) DCMD(host uint16, opcode uint32, mbox [12]byte, b []byte) error {
 	ioc := megasas_iocpacket{host_no: host}
 
 	// Approximation of C union behaviour
 	dcmd := (*megasas_dcmd_frame)(unsafe.Pointer(&ioc.frame))
 	dcmd.cmd = MFI_CMD_DCMD
+	dcmd.cmd_status = 0xff
 	dcmd.opcode = opcode
-	dcmd.data_xfer_len = uint32(len(b))
-	dcmd.sge_count = 1
-
-	ioc.sge_count = 1
+	dcmd.mbox = mbox
 	ioc.sgl_off = uint32(unsafe.Offsetof(dcmd.sgl))
-	ioc.sgl[0] = Iovec{uint64(uintptr(unsafe.Pointer(&b[0]))), uint64(len(b))}
+
+	if len(b) > 0 {
+		dcmd.data_xfer_len = uint32(len(b))
+		dcmd.sge_count = 1
+
+		ioc.sge_count = 1
+		ioc.sgl[0] = Iovec{uint64(uintptr(unsafe.Pointer(&b[0]))), uint64(len(b))}
+	}
 
 	iocBuf := ioc.PackedBytes()
 
 	// Note pointer to first item in iocBuf buffer
-	if err := ioctl.Ioctl(uintptr(m.fd), MEGASAS_IOC_FIRMWARE, uintptr(unsafe.Pointer(&iocBuf[0]))); err != nil {
+	err := ioctl.Ioctl(uintptr(m.fd), MEGASAS_IOC_FIRMWARE, uintptr(unsafe.Pointer(&iocBuf[0])))
+
+	// The kernel only sees b via the address packed into iocBuf, so keep it alive until the
+	// ioctl has returned
+	runtime.KeepAlive(b)
+
+	if err != nil {
 		return err
 	}
 
+	// The driver copies the firmware's command status back into the frame header
+	if status := MFIStatus(iocBuf[iocStatusOffset]); status != MFI_STAT_OK {
+		return &MFIError{Opcode: opcode, Status: status}
+	}
+
 	return nil
 }
 
@@ -247,23 +271,52 @@ func (m *MegasasIoctl) PassThru(host uint16, diskNum uint8, cdb []byte, buf []by
 	iocBuf := ioc.PackedBytes()
 
 	// Note pointer to first item in iocBuf buffer
-	return ioctl.Ioctl(uintptr(m.fd), MEGASAS_IOC_FIRMWARE, uintptr(unsafe.Pointer(&iocBuf[0])))
+	err := ioctl.Ioctl(uintptr(m.fd), MEGASAS_IOC_FIRMWARE, uintptr(unsafe.Pointer(&iocBuf[0])))
+	runtime.KeepAlive(buf)
+
+	return err
 }
 
-// GetPDList retrieves a list of physical devices attached to the specified host
+// GetPDList retrieves a list of physical devices attached to the specified host. An *MFIError is
+// returned if the controller firmware rejected the command, e.g. with MFI_STAT_INVALID_DCMD if it
+// does not support it. A host without any physical devices returns an empty list. If the list does
+// not fit in the default response buffer, the command is retried with a buffer sized to hold all
+// reported devices.
 func (m *MegasasIoctl) GetPDList(host uint16) ([]MegasasPDAddress, error) {
+	entrySize := binary.Size(MegasasPDAddress{})
 	respBuf := make([]byte, 4096)
 
-	if err := m.MFI(0, MR_DCMD_PD_GET_LIST, respBuf); err != nil {
-		log.Println(err)
+	if err := m.MFI(host, MR_DCMD_PD_GET_LIST, respBuf); err != nil {
+		m.logger().Printf("megaraid: MR_DCMD_PD_GET_LIST on host %d: %v", host, err)
 		return nil, err
 	}
 
 	respCount := utils.NativeEndian.Uint32(respBuf[4:])
 
+	// Guard against buggy firmware reporting an implausible number of devices
+	if respCount > MAX_PHYSICAL_DEVICES {
+		return nil, fmt.Errorf("implausible physical device count %d (max. %d) in PD list",
+			respCount, MAX_PHYSICAL_DEVICES)
+	}
+
+	if need := 8 + int(respCount)*entrySize; need > len(respBuf) {
+		respBuf = make([]byte, need)
+
+		if err := m.MFI(host, MR_DCMD_PD_GET_LIST, respBuf); err != nil {
+			return nil, err
+		}
+
+		// The device count may have changed between the two commands
+		if n := utils.NativeEndian.Uint32(respBuf[4:]); n < respCount {
+			respCount = n
+		}
+	}
+
 	// Create a device array large enough to hold the specified number of devices
 	devices := make([]MegasasPDAddress, respCount)
-	binary.Read(bytes.NewBuffer(respBuf[8:]), utils.NativeEndian, &devices)
+	if err := utils.DecodeInto(respBuf[8:], &devices); err != nil {
+		return nil, err
+	}
 
 	return devices, nil
 }
@@ -333,14 +386,21 @@ func (d *MegasasDevice) inquiry() scsi.InquiryResponse {
 		return inqBuf
 	}
 
-	binary.Read(bytes.NewReader(respBuf), utils.NativeEndian, &inqBuf)
+	if err := utils.DecodeInto(respBuf, &inqBuf); err != nil {
+		return scsi.InquiryResponse{}
+	}
+
 	return inqBuf
 }
 
 func OpenMegasasIoctl(host uint16, diskNum uint8) error {
 	var respBuf []byte
 
-	m, _ := CreateMegasasIoctl()
+	m, err := CreateMegasasIoctl()
+	if err != nil {
+		return err
+	}
+
 	fmt.Printf("%#v\n", m)
 
 	defer m.Close()
@@ -365,7 +425,9 @@ func OpenMegasasIoctl(host uint16, diskNum uint8) error {
 	}
 
 	ident_buf := ata.IdentifyDeviceData{}
-	binary.Read(bytes.NewBuffer(respBuf), utils.NativeEndian, &ident_buf)
+	if err := utils.DecodeInto(respBuf, &ident_buf); err != nil {
+		return err
+	}
 
 	fmt.Println("\nATA IDENTIFY data follows:")
 	fmt.Printf("Serial Number: %s\n", ident_buf.SerialNumber())
@@ -395,7 +457,10 @@ func OpenMegasasIoctl(host uint16, diskNum uint8) error {
 	}
 
 	smart := ata.SmartPage{}
-	binary.Read(bytes.NewBuffer(respBuf[:362]), utils.NativeEndian, &smart)
+	if err := utils.DecodeInto(respBuf, &smart); err != nil {
+		return err
+	}
+
 	ata.PrintSMARTPage(smart, thisDrive)
 
 	return nil
@@ -403,17 +468,33 @@ func OpenMegasasIoctl(host uint16, diskNum uint8) error {
 
 // Scan system for MegaRAID adapters and their devices
 func MegaScan() {
-	m, _ := CreateMegasasIoctl()
+	m, err := CreateMegasasIoctl()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
 	defer m.Close()
 
 	hosts, _ := m.ScanHosts()
 	for _, hostNum := range hosts {
 		devices, _ := m.GetPDList(hostNum)
 
-		fmt.Println("\nEncl.  Slot  Device Id  SAS Address")
+		fmt.Println("\nEncl.  Slot  Device Id  SAS Address         State")
 		for _, pd := range devices {
 			if pd.SCSIDevType == 0 { // SCSI disk
-				fmt.Printf("%5d   %3d      %5d  %#x\n", pd.EnclosureId, pd.SlotNumber, pd.DeviceId, pd.SASAddr[0])
+				state := "?"
+
+				if info, err := m.GetPDInfo(hostNum, pd.DeviceId); err == nil {
+					state = info.State.String()
+
+					if pct, err := m.GetRebuildProgress(hostNum, pd.DeviceId); err == nil {
+						state = fmt.Sprintf("%s %d%%", state, pct)
+					}
+				}
+
+				fmt.Printf("%5d   %3d      %5d  %-18s  %s\n", pd.EnclosureId, pd.SlotNumber, pd.DeviceId,
+					strings.Join(pd.SASAddresses(), ","), state)
 			}
 		}
 
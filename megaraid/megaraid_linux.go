@@ -0,0 +1,74 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+//go:build linux
+
+package megaraid
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// CreateMegasasIoctl determines the device ID for the MegaRAID SAS ioctl device, creates it
+// if necessary, and returns a MegasasIoctl struct to interact with the megaraid_sas driver.
+// ErrMegaRAIDNotPresent is returned if the megaraid_sas driver is not loaded.
+func CreateMegasasIoctl() (MegasasIoctl, error) {
+	return CreateMegasasIoctlWithOptions(MegasasIoctlOptions{})
+}
+
+// CreateMegasasIoctlWithOptions is like CreateMegasasIoctl, but opens the ioctl device as
+// specified by opts. DeviceMajor is not populated when an existing device node is opened.
+func CreateMegasasIoctlWithOptions(opts MegasasIoctlOptions) (MegasasIoctl, error) {
+	var (
+		m   MegasasIoctl
+		err error
+	)
+
+	m.Logger = opts.Logger
+
+	if opts.DevicePath != "" {
+		m.fd, err = unix.Open(opts.DevicePath, unix.O_RDWR, 0600)
+		return m, err
+	}
+
+	// megaraid_sas driver does not automatically create ioctl device node, so find out the device
+	// major number and create it.
+	if file, err := os.Open("/proc/devices"); err == nil {
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			if strings.HasSuffix(scanner.Text(), "megaraid_sas_ioctl") {
+				if _, err := fmt.Sscanf(scanner.Text(), "%d", &m.DeviceMajor); err == nil {
+					break
+				}
+			}
+		}
+
+		if m.DeviceMajor == 0 {
+			return m, ErrMegaRAIDNotPresent
+		}
+
+		unix.Mknod(MEGASAS_IOCTL_NODE, unix.S_IFCHR, int(unix.Mkdev(m.DeviceMajor, 0)))
+	} else {
+		return m, err
+	}
+
+	m.fd, err = unix.Open(MEGASAS_IOCTL_NODE, unix.O_RDWR, 0600)
+
+	if err != nil {
+		return m, err
+	}
+
+	return m, nil
+}
+
+// Close closes the file descriptor of the MegasasIoctl instance
+func (m *MegasasIoctl) Close() {
+	unix.Close(m.fd)
+}
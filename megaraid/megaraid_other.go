@@ -0,0 +1,25 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+//go:build !linux
+
+package megaraid
+
+import (
+	"github.com/madper/smart/ioctl"
+)
+
+// CreateMegasasIoctl is not supported on this platform and always returns
+// ioctl.ErrUnsupportedPlatform.
+func CreateMegasasIoctl() (MegasasIoctl, error) {
+	return MegasasIoctl{}, ioctl.ErrUnsupportedPlatform
+}
+
+// CreateMegasasIoctlWithOptions is not supported on this platform and always returns
+// ioctl.ErrUnsupportedPlatform.
+func CreateMegasasIoctlWithOptions(opts MegasasIoctlOptions) (MegasasIoctl, error) {
+	return MegasasIoctl{}, ioctl.ErrUnsupportedPlatform
+}
+
+// Close is a no-op on this platform
+func (m *MegasasIoctl) Close() {}
@@ -0,0 +1,219 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+// MegaRAID physical device information and state.
+
+package megaraid
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/madper/smart/utils"
+)
+
+const (
+	MR_DCMD_PD_STATE_SET = 0x02030100
+
+	// Physical device firmware states
+	MR_PD_STATE_UNCONFIGURED_GOOD = 0x00
+	MR_PD_STATE_UNCONFIGURED_BAD  = 0x01
+	MR_PD_STATE_HOT_SPARE         = 0x02
+	MR_PD_STATE_OFFLINE           = 0x10
+	MR_PD_STATE_FAILED            = 0x11
+	MR_PD_STATE_REBUILD           = 0x14
+	MR_PD_STATE_ONLINE            = 0x18
+	MR_PD_STATE_COPYBACK          = 0x20
+	MR_PD_STATE_SYSTEM            = 0x40 // JBOD
+
+	// Bits of the active operations field of the PD progress structure
+	MFI_PD_PROGRESS_REBUILD = 1 << 0
+	MFI_PD_PROGRESS_PATROL  = 1 << 1
+	MFI_PD_PROGRESS_CLEAR   = 1 << 2
+)
+
+// ErrNotRebuilding is returned by GetRebuildProgress if the physical device is not rebuilding.
+var ErrNotRebuilding = errors.New("physical device is not rebuilding")
+
+// PDState is the firmware state of a physical device.
+type PDState uint16
+
+func (s PDState) String() string {
+	switch s {
+	case MR_PD_STATE_UNCONFIGURED_GOOD:
+		return "Unconfigured Good"
+	case MR_PD_STATE_UNCONFIGURED_BAD:
+		return "Unconfigured Bad"
+	case MR_PD_STATE_HOT_SPARE:
+		return "Hot Spare"
+	case MR_PD_STATE_OFFLINE:
+		return "Offline"
+	case MR_PD_STATE_FAILED:
+		return "Failed"
+	case MR_PD_STATE_REBUILD:
+		return "Rebuild"
+	case MR_PD_STATE_ONLINE:
+		return "Online"
+	case MR_PD_STATE_COPYBACK:
+		return "Copyback"
+	case MR_PD_STATE_SYSTEM:
+		return "JBOD"
+	default:
+		return fmt.Sprintf("Unknown (%#02x)", uint16(s))
+	}
+}
+
+// Healthy reports whether the physical device is in a state in which it is usable, i.e. online,
+// a hot spare, unconfigured good or exposed as JBOD.
+func (s PDState) Healthy() bool {
+	switch s {
+	case MR_PD_STATE_ONLINE, MR_PD_STATE_HOT_SPARE, MR_PD_STATE_UNCONFIGURED_GOOD, MR_PD_STATE_SYSTEM:
+		return true
+	default:
+		return false
+	}
+}
+
+// Failed reports whether the physical device has failed, been taken offline or been marked bad by
+// the controller firmware.
+func (s PDState) Failed() bool {
+	switch s {
+	case MR_PD_STATE_FAILED, MR_PD_STATE_OFFLINE, MR_PD_STATE_UNCONFIGURED_BAD:
+		return true
+	default:
+		return false
+	}
+}
+
+// Rebuilding reports whether data is being rebuilt or copied back onto the physical device.
+func (s PDState) Rebuilding() bool {
+	return s == MR_PD_STATE_REBUILD || s == MR_PD_STATE_COPYBACK
+}
+
+// pdStateTransitions lists the states from which a physical device may be set to each state by
+// SetPDState.
+var pdStateTransitions = map[PDState][]PDState{
+	MR_PD_STATE_OFFLINE:           {MR_PD_STATE_ONLINE},
+	MR_PD_STATE_ONLINE:            {MR_PD_STATE_OFFLINE},
+	MR_PD_STATE_UNCONFIGURED_GOOD: {MR_PD_STATE_UNCONFIGURED_BAD, MR_PD_STATE_SYSTEM},
+	MR_PD_STATE_UNCONFIGURED_BAD:  {MR_PD_STATE_UNCONFIGURED_GOOD},
+	MR_PD_STATE_SYSTEM:            {MR_PD_STATE_UNCONFIGURED_GOOD},
+}
+
+// CanTransition reports whether SetPDState may change a physical device from state s to the
+// specified state.
+func (s PDState) CanTransition(to PDState) bool {
+	for _, from := range pdStateTransitions[to] {
+		if from == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MegasasPDInfo holds selected fields of the physical device information (struct mfi_pd_info)
+// returned by the MR_DCMD_PD_GET_INFO command.
+type MegasasPDInfo struct {
+	DeviceId        uint16
+	SeqNum          uint16 // Sequence number, incremented on each state change
+	SCSIDevType     uint8
+	MediaErrors     uint32 // Media error count
+	OtherErrors     uint32 // Other error count
+	PredFailCount   uint32 // Predictive failure count
+	State           PDState
+	RawSize         uint64 // Raw size (sectors)
+	CoercedSize     uint64 // Coerced size (sectors)
+	EnclosureId     uint16
+	SlotNumber      uint8
+	ActiveOps       uint32 // Active operations (MFI_PD_PROGRESS_* bits)
+	RebuildProgress uint16 // Rebuild progress, in units of 1/65535
+}
+
+// decodePDInfo decodes the 512-byte struct mfi_pd_info.
+func decodePDInfo(buf []byte) MegasasPDInfo {
+	return MegasasPDInfo{
+		DeviceId:        utils.NativeEndian.Uint16(buf[0:]),
+		SeqNum:          utils.NativeEndian.Uint16(buf[2:]),
+		SCSIDevType:     buf[165],
+		MediaErrors:     utils.NativeEndian.Uint32(buf[168:]),
+		OtherErrors:     utils.NativeEndian.Uint32(buf[172:]),
+		PredFailCount:   utils.NativeEndian.Uint32(buf[176:]),
+		State:           PDState(utils.NativeEndian.Uint16(buf[184:])),
+		RawSize:         utils.NativeEndian.Uint64(buf[232:]),
+		CoercedSize:     utils.NativeEndian.Uint64(buf[248:]),
+		EnclosureId:     utils.NativeEndian.Uint16(buf[256:]),
+		SlotNumber:      buf[259],
+		ActiveOps:       utils.NativeEndian.Uint32(buf[260:]),
+		RebuildProgress: utils.NativeEndian.Uint16(buf[264:]),
+	}
+}
+
+// SASAddresses returns the non-zero SAS addresses of the physical device as hex WWN strings,
+// e.g. "5000c500a1b2c3d4". Dual-ported SAS drives report one address per port; SATA drives
+// typically report only the address assigned by the expander or controller.
+func (pd *MegasasPDAddress) SASAddresses() []string {
+	var addrs []string
+
+	for _, addr := range pd.SASAddr {
+		if addr != 0 {
+			addrs = append(addrs, fmt.Sprintf("%016x", addr))
+		}
+	}
+
+	return addrs
+}
+
+// GetPDInfo retrieves information about the specified physical device on the specified host
+func (m *MegasasIoctl) GetPDInfo(host uint16, deviceID uint16) (*MegasasPDInfo, error) {
+	respBuf := make([]byte, 512)
+
+	var mbox [12]byte
+	utils.NativeEndian.PutUint16(mbox[:], deviceID)
+
+	if err := m.DCMD(host, MR_DCMD_PD_GET_INFO, mbox, respBuf); err != nil {
+		return nil, err
+	}
+
+	info := decodePDInfo(respBuf)
+
+	return &info, nil
+}
+
+// GetRebuildProgress returns the rebuild progress in percent of the specified physical device, or
+// ErrNotRebuilding if the device is not currently being rebuilt.
+func (m *MegasasIoctl) GetRebuildProgress(host uint16, deviceID uint16) (percent int, err error) {
+	info, err := m.GetPDInfo(host, deviceID)
+	if err != nil {
+		return 0, err
+	}
+
+	if info.State != MR_PD_STATE_REBUILD || info.ActiveOps&MFI_PD_PROGRESS_REBUILD == 0 {
+		return 0, ErrNotRebuilding
+	}
+
+	return int(info.RebuildProgress) * 100 / 0xffff, nil
+}
+
+// SetPDState changes the firmware state of the specified physical device, e.g. to
+// MR_PD_STATE_OFFLINE before removing it, or to MR_PD_STATE_UNCONFIGURED_GOOD after inserting a
+// replacement which the controller marked bad. An error is returned without issuing the command
+// if the transition from the current state is not permitted (see PDState.CanTransition).
+func (m *MegasasIoctl) SetPDState(host uint16, deviceID uint16, state PDState) error {
+	info, err := m.GetPDInfo(host, deviceID)
+	if err != nil {
+		return err
+	}
+
+	if !info.State.CanTransition(state) {
+		return fmt.Errorf("cannot change state of physical device %d from %s to %s", deviceID, info.State, state)
+	}
+
+	// The sequence number guards against a concurrent state change since GetPDInfo
+	var mbox [12]byte
+	utils.NativeEndian.PutUint16(mbox[0:], deviceID)
+	utils.NativeEndian.PutUint16(mbox[2:], info.SeqNum)
+	utils.NativeEndian.PutUint16(mbox[4:], uint16(state))
+
+	return m.DCMD(host, MR_DCMD_PD_STATE_SET, mbox, nil)
+}
@@ -0,0 +1,42 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+package megaraid
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanTransition(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		from, to PDState
+		ok       bool
+	}{
+		{MR_PD_STATE_ONLINE, MR_PD_STATE_OFFLINE, true},
+		{MR_PD_STATE_OFFLINE, MR_PD_STATE_ONLINE, true},
+		{MR_PD_STATE_UNCONFIGURED_BAD, MR_PD_STATE_UNCONFIGURED_GOOD, true},
+		{MR_PD_STATE_SYSTEM, MR_PD_STATE_UNCONFIGURED_GOOD, true},
+		{MR_PD_STATE_UNCONFIGURED_GOOD, MR_PD_STATE_UNCONFIGURED_BAD, true},
+		{MR_PD_STATE_UNCONFIGURED_GOOD, MR_PD_STATE_SYSTEM, true},
+
+		// Configured drives must be taken offline first
+		{MR_PD_STATE_ONLINE, MR_PD_STATE_UNCONFIGURED_GOOD, false},
+		{MR_PD_STATE_ONLINE, MR_PD_STATE_SYSTEM, false},
+		{MR_PD_STATE_REBUILD, MR_PD_STATE_OFFLINE, false},
+		{MR_PD_STATE_FAILED, MR_PD_STATE_ONLINE, false},
+		{MR_PD_STATE_UNCONFIGURED_BAD, MR_PD_STATE_SYSTEM, false},
+
+		// States which SetPDState cannot set
+		{MR_PD_STATE_OFFLINE, MR_PD_STATE_REBUILD, false},
+		{MR_PD_STATE_UNCONFIGURED_GOOD, MR_PD_STATE_HOT_SPARE, false},
+		{MR_PD_STATE_ONLINE, MR_PD_STATE_ONLINE, false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(tt.ok, tt.from.CanTransition(tt.to), "%s -> %s", tt.from, tt.to)
+	}
+}
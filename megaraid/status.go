@@ -0,0 +1,71 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+// MegaRAID firmware command status codes.
+
+package megaraid
+
+import (
+	"fmt"
+)
+
+const (
+	// Selected MFI command status codes, as defined in <drivers/scsi/megaraid/megaraid_sas.h>
+	MFI_STAT_OK                   = 0x00
+	MFI_STAT_INVALID_CMD          = 0x01
+	MFI_STAT_INVALID_DCMD         = 0x02
+	MFI_STAT_INVALID_PARAMETER    = 0x03
+	MFI_STAT_DEVICE_NOT_FOUND     = 0x0c
+	MFI_STAT_MEMORY_NOT_AVAILABLE = 0x20
+	MFI_STAT_NOT_FOUND            = 0x23
+	MFI_STAT_SCSI_DONE_WITH_ERROR = 0x2d
+	MFI_STAT_SCSI_IO_FAILED       = 0x2e
+	MFI_STAT_WRONG_STATE          = 0x32
+	MFI_STAT_INVALID_STATUS       = 0xff
+
+	// Offset of the frame header cmd_status field in a packed megasas_iocpacket
+	iocStatusOffset = 22
+)
+
+// MFIStatus is the command status returned by the MegaRAID controller firmware.
+type MFIStatus uint8
+
+func (s MFIStatus) String() string {
+	switch s {
+	case MFI_STAT_OK:
+		return "OK"
+	case MFI_STAT_INVALID_CMD:
+		return "invalid command"
+	case MFI_STAT_INVALID_DCMD:
+		return "invalid / unsupported DCMD opcode"
+	case MFI_STAT_INVALID_PARAMETER:
+		return "invalid parameter"
+	case MFI_STAT_DEVICE_NOT_FOUND:
+		return "device not found"
+	case MFI_STAT_MEMORY_NOT_AVAILABLE:
+		return "memory not available"
+	case MFI_STAT_NOT_FOUND:
+		return "not found"
+	case MFI_STAT_SCSI_DONE_WITH_ERROR:
+		return "SCSI command completed with error"
+	case MFI_STAT_SCSI_IO_FAILED:
+		return "SCSI I/O failed"
+	case MFI_STAT_WRONG_STATE:
+		return "wrong state"
+	case MFI_STAT_INVALID_STATUS:
+		return "status not set by firmware"
+	default:
+		return fmt.Sprintf("status %#02x", uint8(s))
+	}
+}
+
+// MFIError is returned when the controller firmware completes a command with a status other than
+// MFI_STAT_OK. Transport-level failures of the ioctl itself are returned as syscall errors instead.
+type MFIError struct {
+	Opcode uint32    // DCMD opcode
+	Status MFIStatus // Firmware command status
+}
+
+func (e *MFIError) Error() string {
+	return fmt.Sprintf("megaraid: DCMD %#08x failed: %s", e.Opcode, e.Status)
+}
@@ -0,0 +1,90 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+// Mock device backend for testing code which uses this package without real hardware.
+
+package smart
+
+import (
+	"github.com/madper/smart/utils"
+)
+
+// MockOptions specifies the values reported by a MockDevice.
+type MockOptions struct {
+	Type           DeviceType
+	Identity       Identity
+	WWN            string // Empty to return ErrNotSupported
+	SMARTSupported bool
+	Temperature    utils.Temperature
+	AvailableSpare uint8 // Available spare (percent, checked by Monitor)
+	PercentUsed    uint8 // Percentage of rated endurance used (checked by Monitor)
+	RotationRate   int
+	MediaErrors    uint64
+
+	// Err, if set, is returned by all methods other than Close and Type.
+	Err error
+}
+
+// MockDevice is a Device which reports the values in its Options, which may be modified at any
+// time to simulate changes in device health. It is not safe for concurrent use while being
+// modified.
+type MockDevice struct {
+	Options MockOptions
+	closed  bool
+}
+
+// NewMockDevice returns a MockDevice which reports the values specified in opts.
+func NewMockDevice(opts MockOptions) *MockDevice {
+	return &MockDevice{Options: opts}
+}
+
+// Close marks the device as closed.
+func (d *MockDevice) Close() error {
+	d.closed = true
+	return nil
+}
+
+// Closed reports whether Close has been called.
+func (d *MockDevice) Closed() bool {
+	return d.closed
+}
+
+func (d *MockDevice) Type() DeviceType {
+	return d.Options.Type
+}
+
+func (d *MockDevice) Identity() (Identity, error) {
+	return d.Options.Identity, d.Options.Err
+}
+
+func (d *MockDevice) WWN() (string, error) {
+	if d.Options.Err != nil {
+		return "", d.Options.Err
+	}
+
+	if d.Options.WWN == "" {
+		return "", ErrNotSupported
+	}
+
+	return d.Options.WWN, nil
+}
+
+func (d *MockDevice) SupportsSMART() (bool, error) {
+	return d.Options.SMARTSupported, d.Options.Err
+}
+
+func (d *MockDevice) Temperature() (utils.Temperature, error) {
+	return d.Options.Temperature, d.Options.Err
+}
+
+func (d *MockDevice) RotationRate() (int, error) {
+	return d.Options.RotationRate, d.Options.Err
+}
+
+func (d *MockDevice) MediaErrorCount() (uint64, error) {
+	return d.Options.MediaErrors, d.Options.Err
+}
+
+func (d *MockDevice) wear() (availSpare, percentUsed uint8, err error) {
+	return d.Options.AvailableSpare, d.Options.PercentUsed, d.Options.Err
+}
@@ -0,0 +1,92 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+// Threshold-based device health monitoring.
+
+package smart
+
+import (
+	"fmt"
+
+	"github.com/madper/smart/utils"
+)
+
+// Attributes checked by Monitor
+const (
+	ALERT_TEMPERATURE     = "temperature"
+	ALERT_AVAILABLE_SPARE = "available_spare"
+	ALERT_PERCENT_USED    = "percent_used"
+)
+
+// Monitor checks devices against configurable health thresholds. A zero threshold disables the
+// corresponding check.
+type Monitor struct {
+	MaxTemperature    utils.Temperature // Maximum temperature
+	MinAvailableSpare uint8             // Minimum available spare capacity (percent, NVMe only)
+	MaxPercentUsed    uint8             // Maximum percentage of rated endurance used (NVMe only)
+}
+
+// Alert describes a breached threshold.
+type Alert struct {
+	Device    string  // Device name
+	Attribute string  // One of the ALERT_* attributes
+	Value     float64 // Current value
+	Threshold float64 // Breached threshold
+}
+
+func (a Alert) String() string {
+	return fmt.Sprintf("%s: %s %g breaches threshold %g", a.Device, a.Attribute, a.Value, a.Threshold)
+}
+
+// wearReporter is implemented by devices which report wear levelling statistics.
+type wearReporter interface {
+	wear() (availSpare, percentUsed uint8, err error)
+}
+
+// Check opens the named device and returns an Alert for each threshold it breaches. Temperatures
+// are compared in degrees Celsius.
+func (m *Monitor) Check(dev string) ([]Alert, error) {
+	d, err := Open(dev)
+	if err != nil {
+		return nil, err
+	}
+
+	defer d.Close()
+
+	return m.check(dev, d)
+}
+
+func (m *Monitor) check(name string, d Device) ([]Alert, error) {
+	var alerts []Alert
+
+	if m.MaxTemperature != 0 {
+		temp, err := d.Temperature()
+		if err != nil {
+			return nil, err
+		}
+
+		if temp > m.MaxTemperature {
+			alerts = append(alerts, Alert{name, ALERT_TEMPERATURE, temp.Celsius(), m.MaxTemperature.Celsius()})
+		}
+	}
+
+	w, ok := d.(wearReporter)
+	if !ok || (m.MinAvailableSpare == 0 && m.MaxPercentUsed == 0) {
+		return alerts, nil
+	}
+
+	availSpare, percentUsed, err := w.wear()
+	if err != nil {
+		return nil, err
+	}
+
+	if m.MinAvailableSpare != 0 && availSpare < m.MinAvailableSpare {
+		alerts = append(alerts, Alert{name, ALERT_AVAILABLE_SPARE, float64(availSpare), float64(m.MinAvailableSpare)})
+	}
+
+	if m.MaxPercentUsed != 0 && percentUsed > m.MaxPercentUsed {
+		alerts = append(alerts, Alert{name, ALERT_PERCENT_USED, float64(percentUsed), float64(m.MaxPercentUsed)})
+	}
+
+	return alerts, nil
+}
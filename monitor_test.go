@@ -0,0 +1,49 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+package smart
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/madper/smart/utils"
+)
+
+func TestMonitor(t *testing.T) {
+	assert := assert.New(t)
+
+	d := NewMockDevice(MockOptions{
+		Type:           DEVICE_TYPE_NVME,
+		SMARTSupported: true,
+		Temperature:    utils.TemperatureFromCelsius(45),
+		AvailableSpare: 100,
+		PercentUsed:    3,
+	})
+
+	m := Monitor{
+		MaxTemperature:    utils.TemperatureFromCelsius(60),
+		MinAvailableSpare: 10,
+		MaxPercentUsed:    90,
+	}
+
+	alerts, err := m.check("nvme0", d)
+	assert.NoError(err)
+	assert.Empty(alerts)
+
+	d.Options.Temperature = utils.TemperatureFromCelsius(70)
+	d.Options.AvailableSpare = 5
+
+	alerts, err = m.check("nvme0", d)
+	assert.NoError(err)
+	assert.Len(alerts, 2)
+	assert.Equal(ALERT_TEMPERATURE, alerts[0].Attribute)
+	assert.InDelta(70, alerts[0].Value, 0.001)
+	assert.Equal(Alert{"nvme0", ALERT_AVAILABLE_SPARE, 5, 10}, alerts[1])
+
+	d.Options.Err = errors.New("device gone")
+	_, err = m.check("nvme0", d)
+	assert.Error(err)
+}
@@ -0,0 +1,180 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+// Device interface adapter for NVMe devices.
+
+package smart
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/madper/smart/nvme"
+	"github.com/madper/smart/utils"
+)
+
+// nvmeDevice adapts an nvme.NVMeDevice to the Device interface. Namespace-specific queries are
+// directed at nsid, which is derived from the device name (e.g. /dev/nvme0n2), or defaults to the
+// first namespace if a controller device (e.g. /dev/nvme0) was opened.
+type nvmeDevice struct {
+	*nvme.NVMeDevice
+	nsid uint32
+}
+
+func openNVMe(name string, opts OpenOptions) (*nvmeDevice, error) {
+	var ctrl, nsid uint32
+
+	if n, _ := fmt.Sscanf(filepath.Base(name), "nvme%dn%d", &ctrl, &nsid); n < 2 {
+		nsid = 1
+	}
+
+	return openNVMeNamespace(name, nsid, opts)
+}
+
+// openNVMeNamespace opens the named NVMe device node, directing namespace-specific queries at
+// nsid.
+func openNVMeNamespace(name string, nsid uint32, opts OpenOptions) (*nvmeDevice, error) {
+	d := nvme.NewNVMeDevice(name)
+	d.NonBlock = opts.NonBlock
+	d.ReadOnly = opts.ReadOnly
+
+	if err := d.Open(); err != nil {
+		return nil, err
+	}
+
+	return &nvmeDevice{d, nsid}, nil
+}
+
+// NamespaceDevice is a Device directed at a single namespace of an NVMe controller, as returned
+// by OpenAllNamespaces.
+type NamespaceDevice interface {
+	Device
+
+	// NamespaceID returns the ID of the namespace.
+	NamespaceID() uint32
+
+	// Capacity returns the utilization and total size of the namespace in bytes.
+	Capacity() (used, total uint64, err error)
+}
+
+func (d *nvmeDevice) NamespaceID() uint32 {
+	return d.nsid
+}
+
+func (d *nvmeDevice) Capacity() (used, total uint64, err error) {
+	return d.NamespaceCapacity(d.nsid)
+}
+
+// OpenAllNamespaces opens the named NVMe controller device (e.g. /dev/nvme0), and returns a
+// NamespaceDevice for each of its active namespaces, in increasing order of namespace ID. Each
+// NamespaceDevice has its own handle on the controller device, and must be closed separately.
+// Namespace-specific queries, such as WWN and Capacity, are directed at the respective namespace,
+// whereas SMART health information (e.g. Temperature) is reported for the controller as a whole.
+func OpenAllNamespaces(controller string) ([]NamespaceDevice, error) {
+	d, err := openNVMeNamespace(controller, 0, OpenOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	nsids, err := d.ActiveNamespaces()
+	d.Close()
+
+	if err != nil {
+		return nil, err
+	}
+
+	devices := make([]NamespaceDevice, 0, len(nsids))
+
+	for _, nsid := range nsids {
+		nd, err := openNVMeNamespace(controller, nsid, OpenOptions{})
+		if err != nil {
+			for _, dev := range devices {
+				dev.Close()
+			}
+
+			return nil, err
+		}
+
+		devices = append(devices, nd)
+	}
+
+	return devices, nil
+}
+
+func (d *nvmeDevice) Type() DeviceType {
+	return DEVICE_TYPE_NVME
+}
+
+func (d *nvmeDevice) Identity() (Identity, error) {
+	controller, err := d.IdentifyController()
+	if err != nil {
+		return Identity{}, err
+	}
+
+	return nvmeIdentity(controller), nil
+}
+
+func (d *nvmeDevice) WWN() (string, error) {
+	ns, err := d.IdentifyNamespace(d.nsid)
+	if err != nil {
+		return "", err
+	}
+
+	return nvmeWWN(ns)
+}
+
+// SupportsSMART always returns true, since the SMART / Health Information log page is mandatory for
+// all NVMe controllers.
+func (d *nvmeDevice) SupportsSMART() (bool, error) {
+	return true, nil
+}
+
+// wear returns the available spare and percentage used from the SMART / Health Information log.
+func (d *nvmeDevice) wear() (availSpare, percentUsed uint8, err error) {
+	sl, err := d.ReadSMART()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return sl.AvailSpare, sl.PercentUsed, nil
+}
+
+func (d *nvmeDevice) nvmeSMART() (*nvme.NVMeSMARTData, error) {
+	return d.ReadSMART()
+}
+
+func (d *nvmeDevice) MediaErrorCount() (uint64, error) {
+	sl, err := d.ReadSMART()
+	if err != nil {
+		return 0, err
+	}
+
+	return nvmeMediaErrors(sl), nil
+}
+
+func nvmeIdentity(controller *nvme.NVMeController) Identity {
+	return Identity{
+		Model:    strings.Trim(string(controller.ModelNumber[:]), " \x00"),
+		Serial:   strings.Trim(string(controller.SerialNumber[:]), " \x00"),
+		Firmware: strings.Trim(string(controller.Firmware[:]), " \x00"),
+	}
+}
+
+func nvmeWWN(ns *nvme.NVMeNamespace) (string, error) {
+	if wwn := ns.WWN(); wwn != "" {
+		return wwn, nil
+	}
+
+	return "", ErrNotSupported
+}
+
+// RotationRate always reports a non-rotating medium, since NVMe devices are solid state.
+func (d *nvmeDevice) RotationRate() (int, error) {
+	return ROTATION_RATE_NON_ROTATING, nil
+}
+
+// nvmeMediaErrors returns the lower 64 bits of the 128-bit Media and Data Integrity Errors count.
+func nvmeMediaErrors(sl *nvme.NVMeSMARTData) uint64 {
+	return utils.NativeEndian.Uint64(sl.MediaErrors[:8])
+}
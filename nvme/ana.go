@@ -0,0 +1,156 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+// NVMe Asymmetric Namespace Access (ANA) reporting.
+
+package nvme
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/madper/smart/utils"
+)
+
+const (
+	NVME_LOG_ANA = 0x0c
+
+	// Controller Multi-Path I/O and Namespace Sharing Capabilities (CMIC) bits
+	NVME_CMIC_ANA = 1 << 3
+
+	// Log Page Attributes (LPA) bits
+	NVME_LPA_EXTENDED_DATA = 1 << 2 // Get Log Page supports offsets and extended transfer lengths
+
+	// ANA states
+	NVME_ANA_OPTIMIZED       = 0x01
+	NVME_ANA_NON_OPTIMIZED   = 0x02
+	NVME_ANA_INACCESSIBLE    = 0x03
+	NVME_ANA_PERSISTENT_LOSS = 0x04
+	NVME_ANA_CHANGE          = 0x0f
+
+	// Sizes of the ANA log page header and group descriptor header
+	anaHeaderLen     = 16
+	anaDescriptorLen = 32
+)
+
+// ErrANANotSupported is returned by ANAGroups if the controller does not support Asymmetric
+// Namespace Access reporting.
+var ErrANANotSupported = errors.New("controller does not support asymmetric namespace access reporting")
+
+// ANAState is the asymmetric namespace access state of an ANA group, as seen through the
+// controller which reported it.
+type ANAState uint8
+
+func (s ANAState) String() string {
+	switch s {
+	case NVME_ANA_OPTIMIZED:
+		return "optimized"
+	case NVME_ANA_NON_OPTIMIZED:
+		return "non-optimized"
+	case NVME_ANA_INACCESSIBLE:
+		return "inaccessible"
+	case NVME_ANA_PERSISTENT_LOSS:
+		return "persistent loss"
+	case NVME_ANA_CHANGE:
+		return "change"
+	default:
+		return fmt.Sprintf("reserved (%#02x)", uint8(s))
+	}
+}
+
+// NVMeANAGroup is an ANA group descriptor of the Asymmetric Namespace Access log page. All
+// namespaces in a group share the same access state.
+type NVMeANAGroup struct {
+	ID          uint32   // ANA Group ID
+	ChangeCount uint64   // Change Count
+	State       ANAState // ANA State
+	Namespaces  []uint32 // Namespace IDs attached to the controller which belong to the group
+}
+
+// ANAGroups reads the Asymmetric Namespace Access log page, returning the access state of each ANA
+// group and the namespaces belonging to it.
+func (d *NVMeDevice) ANAGroups() ([]NVMeANAGroup, error) {
+	controller, err := d.IdentifyController()
+	if err != nil {
+		return nil, err
+	}
+
+	if controller.Cmic&NVME_CMIC_ANA == 0 {
+		return nil, ErrANANotSupported
+	}
+
+	// The header holds the number of group descriptors which follow
+	hdrp := getBuffer(anaHeaderLen)
+	defer putBuffer(hdrp)
+	hdr := *hdrp
+
+	if err := d.getLogPage(NVME_LOG_ANA, 0, 0, 0, hdr); err != nil {
+		return nil, err
+	}
+
+	count := int(utils.NativeEndian.Uint16(hdr[8:]))
+	if count > int(controller.Nanagrpid) {
+		count = int(controller.Nanagrpid)
+	}
+
+	// Each namespace belongs to at most one group, which bounds the size of the NSID lists
+	nn := controller.Nn
+	if nn > 1024 {
+		nn = 1024
+	}
+
+	data := alignedBuffer(anaHeaderLen + count*anaDescriptorLen + int(nn)*4)
+
+	// Without offset support, the whole log page must be read in a single transfer
+	if controller.Lpa&NVME_LPA_EXTENDED_DATA == 0 {
+		if err := d.getLogPage(NVME_LOG_ANA, 0, 0, 0, data); err != nil {
+			return nil, err
+		}
+
+		return decodeANALog(data), nil
+	}
+
+	chunkp := getBuffer(4096)
+	defer putBuffer(chunkp)
+	chunk := *chunkp
+
+	for off := 0; off < len(data); off += len(chunk) {
+		if err := d.getLogPage(NVME_LOG_ANA, 0, 0, uint64(off), chunk); err != nil {
+			return nil, err
+		}
+
+		copy(data[off:], chunk)
+	}
+
+	return decodeANALog(data), nil
+}
+
+// decodeANALog decodes the ANA group descriptors of the Asymmetric Namespace Access log page.
+func decodeANALog(data []byte) []NVMeANAGroup {
+	if len(data) < anaHeaderLen {
+		return nil
+	}
+
+	count := int(utils.NativeEndian.Uint16(data[8:]))
+	groups := make([]NVMeANAGroup, 0, count)
+
+	for off := anaHeaderLen; len(groups) < count && off+anaDescriptorLen <= len(data); {
+		g := NVMeANAGroup{
+			ID:          utils.NativeEndian.Uint32(data[off:]),
+			ChangeCount: utils.NativeEndian.Uint64(data[off+8:]),
+			State:       ANAState(data[off+16] & 0x0f),
+		}
+
+		n := int(utils.NativeEndian.Uint32(data[off+4:]))
+		off += anaDescriptorLen
+
+		for i := 0; i < n && off+4 <= len(data); i++ {
+			g.Namespaces = append(g.Namespaces, utils.NativeEndian.Uint32(data[off:]))
+			off += 4
+		}
+
+		groups = append(groups, g)
+	}
+
+	return groups
+}
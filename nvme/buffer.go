@@ -0,0 +1,72 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+// Pooled data buffers for NVMe admin commands.
+
+package nvme
+
+import (
+	"sync"
+	"unsafe"
+)
+
+const (
+	// Alignment of pooled buffers. Page alignment avoids the kernel having to bounce unaligned
+	// user buffers for DMA.
+	bufferAlign = 4096
+)
+
+// bufferPools holds a sync.Pool for each buffer size commonly used by admin and log page commands.
+// Buffers are returned to the pool once the response has been decoded into a struct, which avoids
+// a fresh allocation for every command when devices are polled at high frequency.
+var bufferPools = map[int]*sync.Pool{
+	512:  newBufferPool(512),
+	4096: newBufferPool(4096),
+}
+
+func newBufferPool(size int) *sync.Pool {
+	return &sync.Pool{
+		New: func() interface{} {
+			b := alignedBuffer(size)
+			return &b
+		},
+	}
+}
+
+// alignedBuffer allocates a zeroed byte slice of the specified size, starting on a bufferAlign
+// boundary.
+func alignedBuffer(size int) []byte {
+	b := make([]byte, size+bufferAlign-1)
+
+	offset := 0
+	if rem := int(uintptr(unsafe.Pointer(&b[0])) % bufferAlign); rem != 0 {
+		offset = bufferAlign - rem
+	}
+
+	return b[offset : offset+size : offset+size]
+}
+
+// getBuffer returns a zeroed, aligned buffer of the specified size, from a pool if possible. The
+// pointer itself is pooled, so that neither getting nor putting a pooled buffer allocates.
+func getBuffer(size int) *[]byte {
+	pool, ok := bufferPools[size]
+	if !ok {
+		b := alignedBuffer(size)
+		return &b
+	}
+
+	bp := pool.Get().(*[]byte)
+	for i := range *bp {
+		(*bp)[i] = 0
+	}
+
+	return bp
+}
+
+// putBuffer returns a buffer obtained from getBuffer to its pool. The buffer must not be used
+// afterwards.
+func putBuffer(bp *[]byte) {
+	if pool, ok := bufferPools[len(*bp)]; ok {
+		pool.Put(bp)
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+// Differences between two samples of the NVMe SMART / Health Information log.
+
+package nvme
+
+import (
+	"github.com/madper/smart/utils"
+)
+
+// SMARTDelta is the change in the SMART / Health Information log between two samples.
+type SMARTDelta struct {
+	BytesRead           uint64  // Bytes read by the host
+	BytesWritten        uint64  // Bytes written by the host
+	HostReads           uint64  // Read commands completed
+	HostWrites          uint64  // Write commands completed
+	PowerOnHours        uint64  // Power on hours
+	MediaErrors         uint64  // New media and data integrity errors
+	ErrorLogEntries     uint64  // New error information log entries
+	TemperatureChange   float64 // Change in composite temperature (degrees Celsius or Kelvin)
+	PercentUsedIncrease int     // Increase in percentage used
+}
+
+// DiffSMART returns the change from the old to the new SMART / Health Information log sample.
+// Counters are compared in their lower 64 bits, and are assumed to have wrapped at most once if
+// the new value is smaller than the old one.
+func DiffSMART(old, new *NVMeSMARTData) SMARTDelta {
+	return SMARTDelta{
+		BytesRead:           counterDelta(old.DataUnitsRead, new.DataUnitsRead) * dataUnitBytes.Uint64(),
+		BytesWritten:        counterDelta(old.DataUnitsWritten, new.DataUnitsWritten) * dataUnitBytes.Uint64(),
+		HostReads:           counterDelta(old.HostReads, new.HostReads),
+		HostWrites:          counterDelta(old.HostWrites, new.HostWrites),
+		PowerOnHours:        counterDelta(old.PowerOnHours, new.PowerOnHours),
+		MediaErrors:         counterDelta(old.MediaErrors, new.MediaErrors),
+		ErrorLogEntries:     counterDelta(old.NumErrLogEntries, new.NumErrLogEntries),
+		TemperatureChange:   new.CompositeTemperature().Kelvin() - old.CompositeTemperature().Kelvin(),
+		PercentUsedIncrease: new.PercentageUsed() - old.PercentageUsed(),
+	}
+}
+
+// counterDelta returns the difference between the lower 64 bits of two 128-bit counters. Unsigned
+// subtraction yields the correct difference if the counter wrapped once between the samples.
+func counterDelta(old, new [16]byte) uint64 {
+	return utils.NativeEndian.Uint64(new[:8]) - utils.NativeEndian.Uint64(old[:8])
+}
@@ -0,0 +1,93 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+// Raw NVMe data dumps, for offline analysis.
+
+package nvme
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/madper/smart/utils"
+)
+
+// Layout of an NVMe dump, as written by SaveDump and read by ParseDump. The raw data structures are
+// stored back-to-back, exactly as returned by the controller, without any header.
+const (
+	NVME_DUMP_CONTROLLER_OFFSET = 0    // Identify controller data structure (4096 bytes)
+	NVME_DUMP_NAMESPACE_OFFSET  = 4096 // Identify namespace data structure (4096 bytes)
+	NVME_DUMP_SMART_OFFSET      = 8192 // SMART / Health Information log page (512 bytes)
+	NVME_DUMP_SIZE              = 8704
+)
+
+// Dump holds the data structures decoded from an NVMe dump.
+type Dump struct {
+	Controller NVMeController
+	Namespace  NVMeNamespace
+	SMART      NVMeSMARTData
+}
+
+// ParseDump decodes an NVMe dump.
+func ParseDump(b []byte) (*Dump, error) {
+	var d Dump
+
+	if len(b) != NVME_DUMP_SIZE {
+		return nil, fmt.Errorf("invalid NVMe dump size %d, expected %d", len(b), NVME_DUMP_SIZE)
+	}
+
+	controller, err := ParseNVMeIdentController(b[NVME_DUMP_CONTROLLER_OFFSET:NVME_DUMP_NAMESPACE_OFFSET])
+	if err != nil {
+		return nil, err
+	}
+
+	d.Controller = *controller
+
+	ns, err := ParseNVMeIdentNamespace(b[NVME_DUMP_NAMESPACE_OFFSET:NVME_DUMP_SMART_OFFSET])
+	if err != nil {
+		return nil, err
+	}
+
+	d.Namespace = *ns
+
+	if err := utils.DecodeInto(b[NVME_DUMP_SMART_OFFSET:], &d.SMART); err != nil {
+		return nil, err
+	}
+
+	return &d, nil
+}
+
+// SaveDump writes the raw identify controller data structure, identify namespace data structure of
+// namespace 1, and SMART / Health Information log page to the named file, in the layout described
+// by the NVME_DUMP_* constants.
+func (d *NVMeDevice) SaveDump(path string) error {
+	b := make([]byte, NVME_DUMP_SIZE)
+
+	bufp := getBuffer(4096)
+	defer putBuffer(bufp)
+	buf := *bufp
+
+	if err := d.identify(NVME_IDENTIFY_CNS_CONTROLLER, 0, 0, buf); err != nil {
+		return err
+	}
+
+	copy(b[NVME_DUMP_CONTROLLER_OFFSET:], buf)
+
+	if err := d.identify(NVME_IDENTIFY_CNS_NAMESPACE, 1, 0, buf); err != nil {
+		return err
+	}
+
+	copy(b[NVME_DUMP_NAMESPACE_OFFSET:], buf)
+
+	smartBufp := getBuffer(512)
+	defer putBuffer(smartBufp)
+	smartBuf := *smartBufp
+
+	if err := d.readLogPage(NVME_LOG_SMART, &smartBuf); err != nil {
+		return err
+	}
+
+	copy(b[NVME_DUMP_SMART_OFFSET:], smartBuf)
+
+	return ioutil.WriteFile(path, b, 0644)
+}
@@ -0,0 +1,327 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+// NVMe Persistent Event Log.
+
+package nvme
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/madper/smart/utils"
+)
+
+const (
+	NVME_LOG_PERSISTENT_EVENT = 0x0d
+
+	// Persistent Event Log log specific field (LSP) actions
+	NVME_PEL_READ        = 0x00 // Read log data
+	NVME_PEL_ESTABLISH   = 0x01 // Establish context and read log data
+	NVME_PEL_RELEASE_CTX = 0x02 // Release context
+
+	// Persistent event types
+	NVME_PEL_SMART_SNAPSHOT  = 0x01
+	NVME_PEL_FW_COMMIT       = 0x02
+	NVME_PEL_TIMESTAMP       = 0x03
+	NVME_PEL_POWER_ON_RESET  = 0x04
+	NVME_PEL_HW_ERROR        = 0x05
+	NVME_PEL_CHANGE_NS       = 0x06
+	NVME_PEL_FORMAT_START    = 0x07
+	NVME_PEL_FORMAT_COMPLETE = 0x08
+	NVME_PEL_SANITIZE_START  = 0x09
+	NVME_PEL_SANITIZE_DONE   = 0x0a
+	NVME_PEL_SET_FEATURE     = 0x0b
+	NVME_PEL_TELEMETRY_CRT   = 0x0c
+	NVME_PEL_THERMAL_EXCURS  = 0x0d
+
+	// Size of the chunks in which the log is read after its header
+	pelChunkSize = 4096
+
+	// Upper bound on the total log length accepted from the log header, regardless of the
+	// Persistent Event Log Size (PELS) reported by the controller
+	pelMaxLength = 64 << 20
+)
+
+// nvmePELHeader is the Persistent Event Log header.
+type nvmePELHeader struct {
+	LogID           uint8
+	Rsvd1           [3]byte
+	TotalEvents     uint32    // Total Number of Events (TNEV)
+	TotalLength     uint64    // Total Log Length (TLL), including this header
+	LogRevision     uint8     // Log Revision
+	Rsvd17          uint8     // ...
+	HeaderLength    uint16    // Log Header Length
+	Timestamp       uint64    // Timestamp
+	PowerOnHours    [16]byte  // Power on Hours
+	PowerCycles     uint64    // Power Cycle Count
+	VendorID        uint16    // PCI Vendor ID
+	Ssvid           uint16    // PCI Subsystem Vendor ID
+	SerialNumber    [20]byte  // Serial Number
+	ModelNumber     [40]byte  // Model Number
+	SubNQN          [256]byte // NVM Subsystem NVMe Qualified Name
+	Rsvd372         [108]byte // ...
+	SupportedEvents [32]byte  // Supported Events Bitmap
+} // 512 bytes
+
+// nvmePELEventHeader is the header preceding each persistent event.
+type nvmePELEventHeader struct {
+	Type         uint8   // Event Type
+	Revision     uint8   // Event Type Revision
+	HeaderLength uint8   // Event Header Length, excluding the first three bytes
+	Rsvd3        uint8   // ...
+	ControllerID uint16  // Controller Identifier
+	Timestamp    uint64  // Event Timestamp
+	Rsvd14       [6]byte // ...
+	VSILength    uint16  // Vendor Specific Information Length
+	Length       uint16  // Event Length, including vendor specific information
+} // 24 bytes
+
+// PersistentEventFirmwareCommit is the event data of a firmware commit event.
+type PersistentEventFirmwareCommit struct {
+	OldFirmware  [8]byte // Old Firmware Revision
+	NewFirmware  [8]byte // New Firmware Revision
+	CommitAction uint8   // Firmware Commit Action
+	Slot         uint8   // Firmware Slot
+	StatusType   uint8   // Status Code Type of the Firmware Commit command
+	Status       uint8   // Status Code of the Firmware Commit command
+	VendorStatus uint16  // Vendor Assigned Firmware Commit Status Code
+}
+
+// PersistentEventPowerOn is the event data of a power-on or reset event.
+type PersistentEventPowerOn struct {
+	Firmware    [8]byte // Firmware Revision
+	Controllers []PersistentEventPowerOnController
+}
+
+// PersistentEventPowerOnController is the power-on or reset information for a single controller.
+type PersistentEventPowerOnController struct {
+	ControllerID       uint16 // Controller ID
+	FirmwareActivation uint8  // Firmware Activation
+	OpInProgress       uint8  // Operation in Progress
+	Rsvd4              [12]byte
+	PowerCycle         uint32 // Controller Power Cycle
+	PowerOnMillis      uint64 // Power on milliseconds
+	Timestamp          uint64 // Controller Timestamp
+} // 36 bytes
+
+// PersistentEventThermal is the event data of a thermal excursion event.
+type PersistentEventThermal struct {
+	OverTemperature uint8 // Over Temperature
+	Threshold       uint8 // Threshold
+}
+
+// PersistentEvent is a single event of the Persistent Event Log. Data holds the undecoded event
+// data, and for recognised event types one of the typed fields is also populated.
+type PersistentEvent struct {
+	Type         uint8
+	Revision     uint8
+	ControllerID uint16
+	Timestamp    time.Time // Zero if the controller did not report a timestamp
+	Data         []byte
+
+	SMART          *NVMeSMARTData
+	FirmwareCommit *PersistentEventFirmwareCommit
+	PowerOn        *PersistentEventPowerOn
+	Thermal        *PersistentEventThermal
+}
+
+// TypeName returns a human-readable name for the event type.
+func (e *PersistentEvent) TypeName() string {
+	switch e.Type {
+	case NVME_PEL_SMART_SNAPSHOT:
+		return "SMART / Health Log Snapshot"
+	case NVME_PEL_FW_COMMIT:
+		return "Firmware Commit"
+	case NVME_PEL_TIMESTAMP:
+		return "Timestamp Change"
+	case NVME_PEL_POWER_ON_RESET:
+		return "Power-on or Reset"
+	case NVME_PEL_HW_ERROR:
+		return "NVM Subsystem Hardware Error"
+	case NVME_PEL_CHANGE_NS:
+		return "Change Namespace"
+	case NVME_PEL_FORMAT_START:
+		return "Format NVM Start"
+	case NVME_PEL_FORMAT_COMPLETE:
+		return "Format NVM Completion"
+	case NVME_PEL_SANITIZE_START:
+		return "Sanitize Start"
+	case NVME_PEL_SANITIZE_DONE:
+		return "Sanitize Completion"
+	case NVME_PEL_SET_FEATURE:
+		return "Set Feature"
+	case NVME_PEL_TELEMETRY_CRT:
+		return "Telemetry Log Create"
+	case NVME_PEL_THERMAL_EXCURS:
+		return "Thermal Excursion"
+	default:
+		return fmt.Sprintf("Unknown (%#02x)", e.Type)
+	}
+}
+
+func (e *PersistentEvent) String() string {
+	return fmt.Sprintf("%s %s (controller %d)",
+		e.Timestamp.Format(time.RFC3339), e.TypeName(), e.ControllerID)
+}
+
+// PersistentEventLog reads and decodes the Persistent Event Log. A reporting context is established
+// for the duration of the read, so that the log is not modified by the controller while it is read
+// in several chunks, and released again afterwards.
+func (d *NVMeDevice) PersistentEventLog() ([]PersistentEvent, error) {
+	var hdr nvmePELHeader
+
+	controller, err := d.IdentifyController()
+	if err != nil {
+		return nil, err
+	}
+
+	bufp := getBuffer(512)
+	defer putBuffer(bufp)
+	buf := *bufp
+
+	if err := d.getLogPage(NVME_LOG_PERSISTENT_EVENT, NVME_PEL_ESTABLISH, 0, 0, buf); err != nil {
+		return nil, err
+	}
+
+	defer d.getLogPage(NVME_LOG_PERSISTENT_EVENT, NVME_PEL_RELEASE_CTX, 0, 0, buf)
+
+	if err := utils.DecodeInto(buf, &hdr); err != nil {
+		return nil, err
+	}
+
+	if hdr.TotalLength <= uint64(len(buf)) {
+		return nil, nil
+	}
+
+	if err := checkPELLength(hdr.TotalLength, controller.Pels); err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, hdr.TotalLength-uint64(len(buf)))
+	chunkp := getBuffer(pelChunkSize)
+	defer putBuffer(chunkp)
+	chunk := *chunkp
+
+	for off := 0; off < len(data); off += pelChunkSize {
+		offset := uint64(len(buf) + off)
+
+		if err := d.getLogPage(NVME_LOG_PERSISTENT_EVENT, NVME_PEL_READ, 0, offset, chunk); err != nil {
+			return nil, err
+		}
+
+		copy(data[off:], chunk)
+	}
+
+	return decodePersistentEvents(data, hdr.TotalEvents), nil
+}
+
+// checkPELLength validates the total log length reported in the Persistent Event Log header
+// against the maximum log size reported by the controller (PELS, in units of 64 KiB), if any, and
+// against pelMaxLength, so that a corrupt header cannot cause a huge allocation.
+func checkPELLength(tll uint64, pels uint32) error {
+	limit := uint64(pelMaxLength)
+	if max := uint64(pels) * 64 * 1024; max > 0 && max < limit {
+		limit = max
+	}
+
+	if tll > limit {
+		return fmt.Errorf("persistent event log length %d exceeds maximum of %d bytes", tll, limit)
+	}
+
+	return nil
+}
+
+// decodePersistentEvents decodes up to count events from the event data following the log header.
+func decodePersistentEvents(data []byte, count uint32) []PersistentEvent {
+	var events []PersistentEvent
+
+	hdrLen := binary.Size(nvmePELEventHeader{})
+
+	for off := 0; uint32(len(events)) < count && off+hdrLen <= len(data); {
+		var eh nvmePELEventHeader
+
+		if utils.DecodeInto(data[off:], &eh) != nil {
+			break
+		}
+
+		start := off + int(eh.HeaderLength) + 3 + int(eh.VSILength)
+		end := off + int(eh.HeaderLength) + 3 + int(eh.Length)
+		if start > end || end > len(data) {
+			break
+		}
+
+		e := PersistentEvent{
+			Type:         eh.Type,
+			Revision:     eh.Revision,
+			ControllerID: eh.ControllerID,
+			Timestamp:    nvmeTimestamp(eh.Timestamp),
+			Data:         data[start:end],
+		}
+		e.decode()
+
+		events = append(events, e)
+		off = end
+	}
+
+	return events
+}
+
+// decode populates the typed event data field matching the event type, if the event data is
+// long enough.
+func (e *PersistentEvent) decode() {
+	r := bytes.NewReader(e.Data)
+
+	switch e.Type {
+	case NVME_PEL_SMART_SNAPSHOT:
+		var sl NVMeSMARTData
+		if binary.Read(r, utils.NativeEndian, &sl) == nil {
+			e.SMART = &sl
+		}
+	case NVME_PEL_FW_COMMIT:
+		var fc PersistentEventFirmwareCommit
+		if binary.Read(r, utils.NativeEndian, &fc) == nil {
+			e.FirmwareCommit = &fc
+		}
+	case NVME_PEL_POWER_ON_RESET:
+		var po PersistentEventPowerOn
+		if binary.Read(r, utils.NativeEndian, &po.Firmware) != nil {
+			return
+		}
+
+		for {
+			var c PersistentEventPowerOnController
+			if binary.Read(r, utils.NativeEndian, &c) != nil {
+				break
+			}
+
+			po.Controllers = append(po.Controllers, c)
+		}
+
+		e.PowerOn = &po
+	case NVME_PEL_THERMAL_EXCURS:
+		var th PersistentEventThermal
+		if binary.Read(r, utils.NativeEndian, &th) == nil {
+			e.Thermal = &th
+		}
+	}
+}
+
+// FirmwareRevision returns the firmware revision as a string.
+func (po *PersistentEventPowerOn) FirmwareRevision() string {
+	return strings.TrimRight(string(po.Firmware[:]), " \x00")
+}
+
+// nvmeTimestamp decodes an NVMe timestamp, whose lower 48 bits hold the number of milliseconds
+// since the Unix epoch.
+func nvmeTimestamp(ts uint64) time.Time {
+	ms := int64(ts & (1<<48 - 1))
+	if ms == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(ms/1000, (ms%1000)*int64(time.Millisecond))
+}
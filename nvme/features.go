@@ -0,0 +1,324 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+// NVMe Get Features command and decoders for individual features.
+
+package nvme
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"time"
+	"unsafe"
+
+	"github.com/madper/smart/utils"
+)
+
+const (
+	NVME_ADMIN_SET_FEATURES = 0x09
+	NVME_ADMIN_GET_FEATURES = 0x0a
+
+	// Feature identifiers
+	NVME_FEAT_ARBITRATION  = 0x01
+	NVME_FEAT_POWER_MGMT   = 0x02
+	NVME_FEAT_ERR_RECOVERY = 0x05 // Error Recovery
+	NVME_FEAT_VWC          = 0x06 // Volatile Write Cache
+	NVME_FEAT_NUM_QUEUES   = 0x07 // Number of Queues
+	NVME_FEAT_APST         = 0x0c // Autonomous Power State Transition
+)
+
+var (
+	// ErrNoVolatileWriteCache is returned by the write cache functions if the controller does not
+	// have a volatile write cache.
+	ErrNoVolatileWriteCache = errors.New("controller does not have a volatile write cache")
+
+	// ErrDULBENotSupported is returned by SetErrorRecovery when enabling DULBE on a namespace
+	// which does not support it.
+	ErrDULBENotSupported = errors.New("namespace does not support deallocated or unwritten logical block errors")
+)
+
+// NVMeArbitration is the decoded Arbitration feature (01h).
+type NVMeArbitration struct {
+	Burst        uint8 // Arbitration Burst (log2 of commands; 7 means no limit)
+	LowWeight    uint8 // Low Priority Weight
+	MediumWeight uint8 // Medium Priority Weight
+	HighWeight   uint8 // High Priority Weight
+}
+
+// NVMePowerManagement is the decoded Power Management feature (02h).
+type NVMePowerManagement struct {
+	PowerState   uint8 // Current power state
+	WorkloadHint uint8 // Workload Hint
+}
+
+// NVMeErrorRecovery is the decoded Error Recovery feature (05h) of a namespace.
+type NVMeErrorRecovery struct {
+	TimeLimit time.Duration // Time Limited Error Recovery (TLER), zero if not limited
+	DULBE     bool          // Deallocated or Unwritten Logical Block Error Enable
+}
+
+// NVMeQueueCount is the number of I/O submission and completion queues, as decoded from the
+// Number of Queues feature (07h).
+type NVMeQueueCount struct {
+	Submission int // Number of I/O Submission Queues
+	Completion int // Number of I/O Completion Queues
+}
+
+// NVMeAPSTEntry is a single entry of the Autonomous Power State Transition table, describing the
+// idle power state the controller transitions to from the power state at the entry's index.
+type NVMeAPSTEntry struct {
+	IdlePowerState uint8  // Idle Transition Power State
+	IdleTime       uint32 // Idle Time Prior to Transition (milliseconds)
+}
+
+// NVMeAPST is the decoded Autonomous Power State Transition feature (0Ch).
+type NVMeAPST struct {
+	Enabled bool
+	Entries [32]NVMeAPSTEntry // Indexed by power state
+}
+
+// GetFeature issues a Get Features command for the current value of the specified feature,
+// returning Dword 0 of the completion queue entry. Features which return a data structure write it
+// into buf, which may be nil otherwise.
+func (d *NVMeDevice) GetFeature(fid uint8, nsid uint32, cdw11 uint32, buf []byte) (uint32, error) {
+	cmd := nvmePassthruCommand{
+		opcode: NVME_ADMIN_GET_FEATURES,
+		nsid:   nsid,
+		cdw10:  uint32(fid), // SEL (bits 10:8) = 0, current value
+		cdw11:  cdw11,
+	}
+
+	if len(buf) > 0 {
+		cmd.addr = uint64(uintptr(unsafe.Pointer(&buf[0])))
+		cmd.data_len = uint32(len(buf))
+	}
+
+	result, err := d.adminCommand(&cmd)
+	runtime.KeepAlive(buf)
+
+	return result, err
+}
+
+// SetFeature issues a Set Features command for the specified feature, with the feature value in
+// cdw11. The value is not saved across power cycles. Features which take a data structure read it
+// from buf, which may be nil otherwise. Dword 0 of the completion queue entry is returned.
+func (d *NVMeDevice) SetFeature(fid uint8, nsid uint32, cdw11 uint32, buf []byte) (uint32, error) {
+	cmd := nvmePassthruCommand{
+		opcode: NVME_ADMIN_SET_FEATURES,
+		nsid:   nsid,
+		cdw10:  uint32(fid), // SV (bit 31) = 0, do not save
+		cdw11:  cdw11,
+	}
+
+	if len(buf) > 0 {
+		cmd.addr = uint64(uintptr(unsafe.Pointer(&buf[0])))
+		cmd.data_len = uint32(len(buf))
+	}
+
+	result, err := d.adminCommand(&cmd)
+	runtime.KeepAlive(buf)
+
+	return result, err
+}
+
+// Arbitration returns the controller's command arbitration settings.
+func (d *NVMeDevice) Arbitration() (*NVMeArbitration, error) {
+	dw0, err := d.GetFeature(NVME_FEAT_ARBITRATION, 0, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &NVMeArbitration{
+		Burst:        uint8(dw0 & 0x07),
+		LowWeight:    uint8(dw0 >> 8),
+		MediumWeight: uint8(dw0 >> 16),
+		HighWeight:   uint8(dw0 >> 24),
+	}, nil
+}
+
+// PowerManagement returns the controller's current power state and workload hint.
+func (d *NVMeDevice) PowerManagement() (*NVMePowerManagement, error) {
+	dw0, err := d.GetFeature(NVME_FEAT_POWER_MGMT, 0, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &NVMePowerManagement{
+		PowerState:   uint8(dw0 & 0x1f),
+		WorkloadHint: uint8((dw0 >> 5) & 0x07),
+	}, nil
+}
+
+// ErrorRecovery returns the error recovery settings of the specified namespace. With DULBE
+// enabled, reads of deallocated or unwritten logical blocks fail with an error, rather than
+// returning the data pattern reported in the namespace's DLFEAT field (typically zeroes).
+func (d *NVMeDevice) ErrorRecovery(nsid uint32) (*NVMeErrorRecovery, error) {
+	dw0, err := d.GetFeature(NVME_FEAT_ERR_RECOVERY, nsid, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &NVMeErrorRecovery{
+		TimeLimit: time.Duration(dw0&0xffff) * 100 * time.Millisecond,
+		DULBE:     dw0&(1<<16) != 0,
+	}, nil
+}
+
+// SetErrorRecovery changes the error recovery settings of the specified namespace. The time limit
+// is rounded down to the 100 ms granularity of the feature. DULBE may only be enabled on
+// namespaces which support it (NSFEAT bit 2), otherwise ErrDULBENotSupported is returned.
+func (d *NVMeDevice) SetErrorRecovery(nsid uint32, er NVMeErrorRecovery) error {
+	tler := er.TimeLimit / (100 * time.Millisecond)
+	if tler < 0 || tler > 0xffff {
+		return fmt.Errorf("invalid error recovery time limit: %s", er.TimeLimit)
+	}
+
+	cdw11 := uint32(tler)
+
+	if er.DULBE {
+		ns, err := d.IdentifyNamespace(nsid)
+		if err != nil {
+			return err
+		}
+
+		if ns.Nsfeat&0x04 == 0 {
+			return ErrDULBENotSupported
+		}
+
+		cdw11 |= 1 << 16
+	}
+
+	_, err := d.SetFeature(NVME_FEAT_ERR_RECOVERY, nsid, cdw11, nil)
+
+	return err
+}
+
+// NumberOfQueues returns the number of I/O submission and completion queues allocated by the
+// controller, as negotiated by the host driver.
+func (d *NVMeDevice) NumberOfQueues() (*NVMeQueueCount, error) {
+	dw0, err := d.GetFeature(NVME_FEAT_NUM_QUEUES, 0, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeQueueCount(dw0), nil
+}
+
+// SetNumberOfQueues requests the specified number of I/O submission and completion queues (1 to
+// 65535 each), and returns the number allocated by the controller, which may differ. Controllers
+// only accept this command after a reset and before any I/O queues have been created, so it fails
+// with a command sequence error on a controller already initialised by the kernel driver.
+func (d *NVMeDevice) SetNumberOfQueues(submission, completion int) (*NVMeQueueCount, error) {
+	if submission < 1 || submission > 0xffff || completion < 1 || completion > 0xffff {
+		return nil, fmt.Errorf("invalid number of queues: %d submission, %d completion", submission, completion)
+	}
+
+	// Counts are zero-based
+	cdw11 := uint32(submission-1) | uint32(completion-1)<<16
+
+	dw0, err := d.SetFeature(NVME_FEAT_NUM_QUEUES, 0, cdw11, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeQueueCount(dw0), nil
+}
+
+// decodeQueueCount decodes the zero-based queue counts from completion Dword 0 of the Number of
+// Queues feature.
+func decodeQueueCount(dw0 uint32) *NVMeQueueCount {
+	return &NVMeQueueCount{
+		Submission: int(dw0&0xffff) + 1,
+		Completion: int(dw0>>16) + 1,
+	}
+}
+
+// CurrentPowerState returns the index of the controller's current power state, which may be used
+// to look up its descriptor in the slice returned by NVMeController.PowerStates.
+func (d *NVMeDevice) CurrentPowerState() (int, error) {
+	pm, err := d.PowerManagement()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(pm.PowerState), nil
+}
+
+// APST returns whether autonomous power state transitions are enabled, and the controller's
+// transition table.
+func (d *NVMeDevice) APST() (*NVMeAPST, error) {
+	bufp := getBuffer(256)
+	defer putBuffer(bufp)
+	buf := *bufp
+
+	dw0, err := d.GetFeature(NVME_FEAT_APST, 0, 0, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeAPST(dw0, buf), nil
+}
+
+// decodeAPST decodes the APST feature from completion Dword 0 and the 256-byte transition table.
+func decodeAPST(dw0 uint32, buf []byte) *NVMeAPST {
+	apst := NVMeAPST{Enabled: dw0&0x01 != 0}
+
+	for i := range apst.Entries {
+		// Each entry is a 64-bit little-endian value, of which only the lower dword is defined
+		entry := utils.NativeEndian.Uint32(buf[i*8:])
+
+		apst.Entries[i] = NVMeAPSTEntry{
+			IdlePowerState: uint8((entry >> 3) & 0x1f),
+			IdleTime:       entry >> 8,
+		}
+	}
+
+	return &apst
+}
+
+// checkVWC returns ErrNoVolatileWriteCache if the controller does not report a volatile write
+// cache in its identify data (VWC bit 0).
+func (d *NVMeDevice) checkVWC() error {
+	controller, err := d.IdentifyController()
+	if err != nil {
+		return err
+	}
+
+	if controller.Vwc&0x01 == 0 {
+		return ErrNoVolatileWriteCache
+	}
+
+	return nil
+}
+
+// GetWriteCache reports whether the controller's volatile write cache is enabled.
+func (d *NVMeDevice) GetWriteCache() (bool, error) {
+	if err := d.checkVWC(); err != nil {
+		return false, err
+	}
+
+	dw0, err := d.GetFeature(NVME_FEAT_VWC, 0, 0, nil)
+	if err != nil {
+		return false, err
+	}
+
+	return dw0&0x01 != 0, nil
+}
+
+// SetWriteCache enables or disables the controller's volatile write cache. The setting is not
+// saved, and reverts to the controller default after a reset or power cycle.
+func (d *NVMeDevice) SetWriteCache(enabled bool) error {
+	if err := d.checkVWC(); err != nil {
+		return err
+	}
+
+	var wce uint32
+	if enabled {
+		wce = 1
+	}
+
+	_, err := d.SetFeature(NVME_FEAT_VWC, 0, wce, nil)
+
+	return err
+}
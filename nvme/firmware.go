@@ -0,0 +1,95 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+// NVMe firmware commit and activation.
+
+package nvme
+
+import (
+	"fmt"
+
+	"github.com/madper/smart/ioctl"
+)
+
+const (
+	NVME_ADMIN_FW_COMMIT = 0x10
+
+	// Firmware Commit actions (CA)
+	NVME_FW_COMMIT_REPLACE          = 0x0 // Replace the image in the slot, without activating it
+	NVME_FW_COMMIT_REPLACE_ACTIVATE = 0x1 // Replace the image, and activate it at the next reset
+	NVME_FW_COMMIT_ACTIVATE         = 0x2 // Activate the image in the slot at the next reset
+	NVME_FW_COMMIT_ACTIVATE_NOW     = 0x3 // Replace the image, and activate it without a reset
+)
+
+// FirmwareSlots returns the number of firmware slots supported by the controller, and whether
+// slot 1 is read-only.
+func (c *NVMeController) FirmwareSlots() (slots int, slot1ReadOnly bool) {
+	return int(c.Frmw>>1) & 0x07, c.Frmw&0x01 != 0
+}
+
+// FirmwareCommit issues a Firmware Commit command for the specified slot (1 to 7, or 0 to let the
+// controller choose) with one of the NVME_FW_COMMIT_* actions. The image must previously have been
+// downloaded, unless the action merely activates an existing image. Actions which activate the
+// image at the next reset may complete with an NVMeStatus error indicating the type of reset
+// required.
+func (d *NVMeDevice) FirmwareCommit(slot uint8, action uint8) error {
+	cmd := nvmePassthruCommand{
+		opcode: NVME_ADMIN_FW_COMMIT,
+		cdw10:  uint32(slot&0x07) | uint32(action&0x07)<<3,
+	}
+
+	_, err := d.adminCommand(&cmd)
+
+	return err
+}
+
+// Reset issues a controller reset, and returns once the kernel has re-initialised the controller.
+func (d *NVMeDevice) Reset() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return ioctl.Ioctl(uintptr(d.fd), NVME_IOCTL_RESET, 0)
+}
+
+// ActivateFirmware activates the firmware image in the specified slot: it commits the slot for
+// activation, resets the controller, and verifies from the Firmware Slot Information log that the
+// controller is now running firmware from that slot. Images which require an NVM subsystem reset
+// cannot be activated this way, and the NVMeStatus error of the commit is returned.
+func (d *NVMeDevice) ActivateFirmware(slot uint8) error {
+	controller, err := d.IdentifyController()
+	if err != nil {
+		return err
+	}
+
+	if slots, _ := controller.FirmwareSlots(); slot < 1 || int(slot) > slots {
+		return fmt.Errorf("invalid firmware slot %d (controller supports %d)", slot, slots)
+	}
+
+	if err := d.FirmwareCommit(slot, NVME_FW_COMMIT_ACTIVATE); err != nil {
+		// These statuses indicate a successful commit, pending the reset issued below
+		if !IsStatus(err, NVME_SCT_COMMAND_SPECIFIC, NVME_SC_FW_NEEDS_CONV_RESET) &&
+			!IsStatus(err, NVME_SCT_COMMAND_SPECIFIC, NVME_SC_FW_NEEDS_RESET) {
+			return err
+		}
+	}
+
+	if err := d.Reset(); err != nil {
+		return fmt.Errorf("controller reset: %v", err)
+	}
+
+	// Confirm that the controller came back up with the new firmware before checking its slot
+	if _, err := d.IdentifyController(); err != nil {
+		return err
+	}
+
+	fw, err := d.FirmwareSlotLog()
+	if err != nil {
+		return err
+	}
+
+	if active := fw.ActiveSlot(); active != int(slot) {
+		return fmt.Errorf("firmware activation failed: controller is running firmware from slot %d", active)
+	}
+
+	return nil
+}
@@ -0,0 +1,269 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+// NVMe log page definitions and readers.
+
+package nvme
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/madper/smart/utils"
+)
+
+const (
+	// Log page identifiers
+	NVME_LOG_ERROR               = 0x01
+	NVME_LOG_SMART               = 0x02
+	NVME_LOG_FW_SLOT             = 0x03
+	NVME_LOG_CHANGED_NAMESPACES  = 0x04
+	NVME_LOG_ENDURANCE_GROUP     = 0x09
+	NVME_LOG_PREDICTABLE_LATENCY = 0x0a // Predictable Latency Per NVM Set
+)
+
+// ErrLogNotSupported is returned when reading a log page which the controller does not support,
+// e.g. optional log pages such as the Device Self-test or Persistent Event Log.
+var ErrLogNotSupported = errors.New("log page not supported by controller")
+
+// NVMeErrorLogEntry is an entry of the Error Information log page (01h).
+type NVMeErrorLogEntry struct {
+	ErrorCount  uint64   // Error Count, unique identifier of the error
+	Sqid        uint16   // Submission Queue ID
+	Cmdid       uint16   // Command ID
+	Status      uint16   // Status Field (phase tag in bit 0)
+	ParamErrLoc uint16   // Parameter Error Location
+	LBA         uint64   // LBA
+	Nsid        uint32   // Namespace
+	VSInfo      uint8    // Vendor Specific Information Available
+	TrType      uint8    // Transport Type
+	Rsvd30      [2]byte  // ...
+	CmdSpecific uint64   // Command Specific Information
+	TrTypeSpec  uint16   // Transport Type Specific Information
+	Rsvd42      [22]byte // ...
+} // 64 bytes
+
+// NVMeFirmwareSlotLog is the Firmware Slot Information log page (03h).
+type NVMeFirmwareSlotLog struct {
+	Afi    uint8      // Active Firmware Info (active slot in bits 2:0, next slot in bits 6:4)
+	Rsvd1  [7]byte    // ...
+	Frs    [7][8]byte // Firmware Revision for Slots 1 to 7
+	Rsvd64 [448]byte  // ...
+} // 512 bytes
+
+// ActiveSlot returns the firmware slot (1 to 7) from which the running firmware was loaded.
+func (l *NVMeFirmwareSlotLog) ActiveSlot() int {
+	return int(l.Afi & 0x07)
+}
+
+// Revision returns the firmware revision stored in the specified slot (1 to 7), or an empty string
+// if the slot is empty or does not exist.
+func (l *NVMeFirmwareSlotLog) Revision(slot int) string {
+	if slot < 1 || slot > len(l.Frs) {
+		return ""
+	}
+
+	return strings.TrimRight(string(l.Frs[slot-1][:]), " \x00")
+}
+
+// NVMeEnduranceGroupLog is the Endurance Group Information log page (09h), which reports wear and
+// usage statistics for a single endurance group.
+type NVMeEnduranceGroupLog struct {
+	CritWarning       uint8     // Critical Warning
+	Rsvd1             [2]byte   // ...
+	AvailSpare        uint8     // Available Spare (percent)
+	SpareThresh       uint8     // Available Spare Threshold (percent)
+	PercentUsed       uint8     // Percentage Used (may exceed 100)
+	Rsvd6             [26]byte  // ...
+	EnduranceEstimate [16]byte  // Endurance Estimate (units of 1,000,000,000 bytes)
+	DataUnitsRead     [16]byte  // Data Units Read
+	DataUnitsWritten  [16]byte  // Data Units Written
+	MediaUnitsWritten [16]byte  // Media Units Written
+	HostReads         [16]byte  // Host Read Commands
+	HostWrites        [16]byte  // Host Write Commands
+	MediaErrors       [16]byte  // Media and Data Integrity Errors
+	NumErrLogEntries  [16]byte  // Number of Error Information Log Entries
+	Rsvd160           [352]byte // ...
+} // 512 bytes
+
+// Estimate returns the estimated total number of data bytes that may be written to the endurance
+// group over its lifetime, or zero if the controller does not report an estimate.
+func (l *NVMeEnduranceGroupLog) Estimate() *big.Int {
+	return new(big.Int).Mul(le128ToBigInt(l.EnduranceEstimate), big.NewInt(1000*1000*1000))
+}
+
+// NVMePredictableLatencyLog is the Predictable Latency Per NVM Set log page (0Ah), which reports
+// the deterministic / non-deterministic window configuration and estimates for a single NVM set.
+type NVMePredictableLatencyLog struct {
+	Status              uint8     // Status (current window)
+	Rsvd1               uint8     // ...
+	EventType           uint16    // Event Type
+	Rsvd4               [28]byte  // ...
+	DTWinReadsTypical   uint64    // DTWIN Reads Typical
+	DTWinWritesTypical  uint64    // DTWIN Writes Typical
+	DTWinTimeMax        uint64    // DTWIN Time Maximum (milliseconds)
+	NDWinTimeMinHigh    uint64    // NDWIN Time Minimum High (milliseconds)
+	NDWinTimeMinLow     uint64    // NDWIN Time Minimum Low (milliseconds)
+	Rsvd72              [56]byte  // ...
+	DTWinReadsEstimate  uint64    // DTWIN Reads Estimate
+	DTWinWritesEstimate uint64    // DTWIN Writes Estimate
+	DTWinTimeEstimate   uint64    // DTWIN Time Estimate (milliseconds)
+	Rsvd152             [360]byte // ...
+} // 512 bytes
+
+// Window returns a description of the predictable latency window the NVM set is currently in.
+func (l *NVMePredictableLatencyLog) Window() string {
+	switch l.Status & 0x07 {
+	case 0:
+		return "not configured"
+	case 1:
+		return "deterministic"
+	case 2:
+		return "non-deterministic"
+	default:
+		return fmt.Sprintf("reserved (%d)", l.Status&0x07)
+	}
+}
+
+// EnduranceGroupLog reads the Endurance Group Information log page for the specified endurance
+// group identifier.
+func (d *NVMeDevice) EnduranceGroupLog(endgid uint16) (*NVMeEnduranceGroupLog, error) {
+	var l NVMeEnduranceGroupLog
+
+	bufp := getBuffer(512)
+	defer putBuffer(bufp)
+	buf := *bufp
+
+	if err := d.getLogPage(NVME_LOG_ENDURANCE_GROUP, 0, endgid, 0, buf); err != nil {
+		return nil, err
+	}
+
+	if err := utils.DecodeInto(buf, &l); err != nil {
+		return nil, err
+	}
+
+	return &l, nil
+}
+
+// PredictableLatencyLog reads the Predictable Latency Per NVM Set log page for the specified NVM
+// set identifier.
+func (d *NVMeDevice) PredictableLatencyLog(nvmSetID uint16) (*NVMePredictableLatencyLog, error) {
+	var l NVMePredictableLatencyLog
+
+	bufp := getBuffer(512)
+	defer putBuffer(bufp)
+	buf := *bufp
+
+	if err := d.getLogPage(NVME_LOG_PREDICTABLE_LATENCY, 0, nvmSetID, 0, buf); err != nil {
+		return nil, err
+	}
+
+	if err := utils.DecodeInto(buf, &l); err != nil {
+		return nil, err
+	}
+
+	return &l, nil
+}
+
+// ChangedNamespaces reads the Changed Namespace List log page, returning the namespace IDs whose
+// identify namespace data has changed since the log page was last read. If more than 1024
+// namespaces have changed, the controller reports a single namespace ID of FFFFFFFFh.
+func (d *NVMeDevice) ChangedNamespaces() ([]uint32, error) {
+	var (
+		list [1024]uint32
+		ids  []uint32
+	)
+
+	bufp := getBuffer(4096)
+	defer putBuffer(bufp)
+	buf := *bufp
+
+	if err := d.readLogPage(NVME_LOG_CHANGED_NAMESPACES, &buf); err != nil {
+		return nil, err
+	}
+
+	if err := utils.DecodeInto(buf, &list); err != nil {
+		return nil, err
+	}
+
+	// List is terminated by the first zero entry
+	for _, nsid := range list {
+		if nsid == 0 {
+			break
+		}
+
+		ids = append(ids, nsid)
+	}
+
+	return ids, nil
+}
+
+// ErrorLog reads the Error Information log page, returning the entries holding an error, most
+// recent first. The controller retains up to ELPE + 1 entries.
+func (d *NVMeDevice) ErrorLog() ([]NVMeErrorLogEntry, error) {
+	controller, err := d.IdentifyController()
+	if err != nil {
+		return nil, err
+	}
+
+	return d.errorLog(controller)
+}
+
+func (d *NVMeDevice) errorLog(controller *NVMeController) ([]NVMeErrorLogEntry, error) {
+	entries := make([]NVMeErrorLogEntry, int(controller.Elpe)+1)
+
+	bufp := getBuffer(len(entries) * binary.Size(NVMeErrorLogEntry{}))
+	defer putBuffer(bufp)
+	buf := *bufp
+
+	if err := d.readLogPage(NVME_LOG_ERROR, &buf); err != nil {
+		return nil, err
+	}
+
+	return decodeErrorLog(buf, entries)
+}
+
+// decodeErrorLog decodes the Error Information log page into entries, returning those holding an
+// error.
+func decodeErrorLog(buf []byte, entries []NVMeErrorLogEntry) ([]NVMeErrorLogEntry, error) {
+	if err := utils.DecodeInto(buf, entries); err != nil {
+		return nil, err
+	}
+
+	// Entries with an error count of zero are unused
+	n := 0
+	for _, e := range entries {
+		if e.ErrorCount != 0 {
+			entries[n] = e
+			n++
+		}
+	}
+
+	return entries[:n], nil
+}
+
+// FirmwareSlotLog reads the Firmware Slot Information log page.
+func (d *NVMeDevice) FirmwareSlotLog() (*NVMeFirmwareSlotLog, error) {
+	bufp := getBuffer(512)
+	defer putBuffer(bufp)
+	buf := *bufp
+
+	if err := d.readLogPage(NVME_LOG_FW_SLOT, &buf); err != nil {
+		return nil, err
+	}
+
+	return decodeFirmwareSlotLog(buf)
+}
+
+func decodeFirmwareSlotLog(buf []byte) (*NVMeFirmwareSlotLog, error) {
+	var l NVMeFirmwareSlotLog
+
+	if err := utils.DecodeInto(buf, &l); err != nil {
+		return nil, err
+	}
+
+	return &l, nil
+}
@@ -0,0 +1,96 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+// Identification of the individual controllers of a multi-controller NVM subsystem.
+
+package nvme
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const SYSFS_NVME_DIR = "/sys/class/nvme"
+
+// sysfsNVMeDir is the sysfs directory searched for NVMe controllers, which tests may override.
+var sysfsNVMeDir = SYSFS_NVME_DIR
+
+// SubsystemControllers returns the device nodes of the controllers attached to the host which
+// belong to the same NVM subsystem as this device (including its own), keyed by controller ID.
+// Controllers are matched by their subsystem NQN, as reported in sysfs.
+func (d *NVMeDevice) SubsystemControllers() (map[uint16]string, error) {
+	controller, err := d.IdentifyController()
+	if err != nil {
+		return nil, err
+	}
+
+	nqn := controller.SubsystemNQN()
+	if nqn == "" {
+		return nil, errors.New("controller does not report a subsystem NQN")
+	}
+
+	return subsystemControllers(nqn)
+}
+
+// subsystemControllers returns the device nodes of the controllers in sysfs whose subsystem NQN is
+// nqn, keyed by controller ID.
+func subsystemControllers(nqn string) (map[uint16]string, error) {
+	dirs, err := filepath.Glob(filepath.Join(sysfsNVMeDir, "nvme*"))
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make(map[uint16]string)
+
+	for _, dir := range dirs {
+		if b, err := ioutil.ReadFile(filepath.Join(dir, "subsysnqn")); err != nil || strings.TrimSpace(string(b)) != nqn {
+			continue
+		}
+
+		b, err := ioutil.ReadFile(filepath.Join(dir, "cntlid"))
+		if err != nil {
+			continue
+		}
+
+		cntid, err := strconv.ParseUint(strings.TrimSpace(string(b)), 0, 16)
+		if err != nil {
+			continue
+		}
+
+		paths[uint16(cntid)] = filepath.Join("/dev", filepath.Base(dir))
+	}
+
+	return paths, nil
+}
+
+// IdentifyControllerID returns the identify controller data structure of the controller with the
+// specified ID in the same NVM subsystem as this device, e.g. one of the IDs returned by
+// ControllerList. The identify controller data structure always describes the controller which
+// processes the command, regardless of the CNTID field, so the command is sent via the device node
+// of the specified controller, which must be attached to the host (see SubsystemControllers).
+func (d *NVMeDevice) IdentifyControllerID(cntid uint16) (*NVMeController, error) {
+	paths, err := d.SubsystemControllers()
+	if err != nil {
+		return nil, err
+	}
+
+	path, ok := paths[cntid]
+	if !ok {
+		return nil, fmt.Errorf("controller %d of the NVM subsystem is not attached to this host", cntid)
+	}
+
+	other := NewNVMeDevice(path)
+	other.ReadOnly = true
+
+	if err := other.Open(); err != nil {
+		return nil, err
+	}
+
+	defer other.Close()
+
+	return other.IdentifyController()
+}
@@ -0,0 +1,354 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+// NVMe namespace management.
+
+package nvme
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	"github.com/madper/smart/utils"
+)
+
+const (
+	NVME_ADMIN_NS_MGMT   = 0x0d
+	NVME_ADMIN_NS_ATTACH = 0x15
+
+	// Namespace Management select (SEL) values
+	NVME_NS_MGMT_CREATE = 0x00
+	NVME_NS_MGMT_DELETE = 0x01
+
+	// Namespace Attachment select (SEL) values
+	NVME_NS_ATTACH_CONTROLLERS = 0x00
+	NVME_NS_DETACH_CONTROLLERS = 0x01
+
+	// Optional Admin Command Support (OACS) bits
+	NVME_OACS_NS_MGMT = 1 << 3
+
+	// Namespace identifier types (NIDT)
+	NVME_NIDT_EUI64 = 0x01
+	NVME_NIDT_NGUID = 0x02
+	NVME_NIDT_UUID  = 0x03
+	NVME_NIDT_CSI   = 0x04 // Command Set Identifier
+)
+
+var ErrNamespaceManagementNotSupported = errors.New("controller does not support namespace management")
+
+// NVMeNamespaceDescriptor is a namespace identification descriptor.
+type NVMeNamespaceDescriptor struct {
+	Type uint8  // Namespace Identifier Type (NIDT)
+	ID   []byte // Namespace Identifier (NID)
+}
+
+// NamespaceCreateParams specifies the attributes of a namespace to be created. Sizes are expressed
+// in logical blocks of the selected LBA format.
+type NamespaceCreateParams struct {
+	Size           uint64 // Namespace Size (NSZE)
+	Capacity       uint64 // Namespace Capacity (NCAP)
+	LBAFormat      uint8  // Formatted LBA Size (FLBAS)
+	DataProtection uint8  // End-to-end Data Protection Type Settings (DPS)
+	Shared         bool   // Namespace may be attached to multiple controllers (NMIC bit 0)
+}
+
+// CreateNamespace creates a namespace with the specified parameters, attaches it to this
+// controller, and returns its namespace ID. The kernel will not create a block device for the new
+// namespace until the controller is rescanned.
+func (d *NVMeDevice) CreateNamespace(params NamespaceCreateParams) (uint32, error) {
+	controller, err := d.IdentifyController()
+	if err != nil {
+		return 0, err
+	}
+
+	if controller.Oacs&NVME_OACS_NS_MGMT == 0 {
+		return 0, ErrNamespaceManagementNotSupported
+	}
+
+	ns := NVMeNamespace{
+		Nsze:  params.Size,
+		Ncap:  params.Capacity,
+		Flbas: params.LBAFormat,
+		Dps:   params.DataProtection,
+	}
+
+	if params.Shared {
+		ns.Nmic = 0x01
+	}
+
+	bufp := getBuffer(4096)
+	defer putBuffer(bufp)
+	buf := *bufp
+
+	wbuf := bytes.NewBuffer(buf[:0])
+	binary.Write(wbuf, utils.NativeEndian, &ns)
+
+	cmd := nvmePassthruCommand{
+		opcode:   NVME_ADMIN_NS_MGMT,
+		addr:     uint64(uintptr(unsafe.Pointer(&buf[0]))),
+		data_len: uint32(len(buf)),
+		cdw10:    NVME_NS_MGMT_CREATE,
+	}
+
+	// Completion queue entry Dword 0 holds the ID of the created namespace
+	nsid, err := d.adminCommand(&cmd)
+	runtime.KeepAlive(buf)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if err := d.attachNamespace(nsid, NVME_NS_ATTACH_CONTROLLERS, []uint16{controller.Cntlid}); err != nil {
+		return nsid, err
+	}
+
+	return nsid, nil
+}
+
+// DeleteNamespace deletes the specified namespace, implicitly detaching it from all controllers.
+// The namespace ID FFFFFFFFh deletes all namespaces.
+func (d *NVMeDevice) DeleteNamespace(nsid uint32) error {
+	controller, err := d.IdentifyController()
+	if err != nil {
+		return err
+	}
+
+	if controller.Oacs&NVME_OACS_NS_MGMT == 0 {
+		return ErrNamespaceManagementNotSupported
+	}
+
+	cmd := nvmePassthruCommand{
+		opcode: NVME_ADMIN_NS_MGMT,
+		nsid:   nsid,
+		cdw10:  NVME_NS_MGMT_DELETE,
+	}
+
+	_, err = d.adminCommand(&cmd)
+
+	return err
+}
+
+// AttachNamespace attaches the namespace to the specified controllers, which must belong to the
+// same NVM subsystem as this controller.
+func (d *NVMeDevice) AttachNamespace(nsid uint32, ctrlIDs []uint16) error {
+	if err := d.validateControllers(ctrlIDs); err != nil {
+		return err
+	}
+
+	return d.attachNamespace(nsid, NVME_NS_ATTACH_CONTROLLERS, ctrlIDs)
+}
+
+// DetachNamespace detaches the namespace from the specified controllers, which must belong to the
+// same NVM subsystem as this controller.
+func (d *NVMeDevice) DetachNamespace(nsid uint32, ctrlIDs []uint16) error {
+	if err := d.validateControllers(ctrlIDs); err != nil {
+		return err
+	}
+
+	return d.attachNamespace(nsid, NVME_NS_DETACH_CONTROLLERS, ctrlIDs)
+}
+
+// validateControllers checks that ctrlIDs is a valid controller list for a Namespace Attachment
+// command, and that each controller ID belongs to the NVM subsystem.
+func (d *NVMeDevice) validateControllers(ctrlIDs []uint16) error {
+	if len(ctrlIDs) == 0 || len(ctrlIDs) > 2047 {
+		return fmt.Errorf("invalid number of controller IDs: %d", len(ctrlIDs))
+	}
+
+	controllers, err := d.ControllerList()
+	if err != nil {
+		return err
+	}
+
+	known := make(map[uint16]bool, len(controllers))
+	for _, id := range controllers {
+		known[id] = true
+	}
+
+	for _, id := range ctrlIDs {
+		if !known[id] {
+			return fmt.Errorf("controller ID %#04x is not part of the NVM subsystem", id)
+		}
+	}
+
+	return nil
+}
+
+// ActiveNamespaces returns the IDs of all active namespaces attached to the controller, in
+// increasing order.
+func (d *NVMeDevice) ActiveNamespaces() ([]uint32, error) {
+	var ids []uint32
+
+	bufp := getBuffer(4096)
+	defer putBuffer(bufp)
+	buf := *bufp
+
+	// Each list holds the first 1024 active namespace IDs greater than the specified NSID, so
+	// continue from the last ID returned until a short list is received.
+	for nsid := uint32(0); ; {
+		if err := d.identify(NVME_IDENTIFY_CNS_ACTIVE_NS_LIST, nsid, 0, buf); err != nil {
+			return nil, err
+		}
+
+		n := 0
+		for ; n < 1024; n++ {
+			id := utils.NativeEndian.Uint32(buf[n*4:])
+			if id == 0 {
+				break
+			}
+
+			ids = append(ids, id)
+		}
+
+		if n < 1024 || ids[len(ids)-1] >= 0xfffffffe {
+			break
+		}
+
+		nsid = ids[len(ids)-1]
+	}
+
+	return ids, nil
+}
+
+// NamespaceDescriptors returns the namespace identification descriptors of the specified
+// namespace, e.g. its EUI-64, NGUID and UUID.
+func (d *NVMeDevice) NamespaceDescriptors(nsid uint32) ([]NVMeNamespaceDescriptor, error) {
+	bufp := getBuffer(4096)
+	defer putBuffer(bufp)
+	buf := *bufp
+
+	if err := d.identify(NVME_IDENTIFY_CNS_NS_DESC_LIST, nsid, 0, buf); err != nil {
+		return nil, err
+	}
+
+	return decodeNamespaceDescriptors(buf), nil
+}
+
+// decodeNamespaceDescriptors decodes a namespace identification descriptor list, which is
+// terminated by a descriptor with a zero length.
+func decodeNamespaceDescriptors(buf []byte) []NVMeNamespaceDescriptor {
+	var descs []NVMeNamespaceDescriptor
+
+	for off := 0; off+4 <= len(buf); {
+		nidt, nidl := buf[off], int(buf[off+1])
+		if nidt == 0 || nidl == 0 || off+4+nidl > len(buf) {
+			break
+		}
+
+		id := make([]byte, nidl)
+		copy(id, buf[off+4:])
+
+		descs = append(descs, NVMeNamespaceDescriptor{Type: nidt, ID: id})
+		off += 4 + nidl
+	}
+
+	return descs
+}
+
+// ControllerList returns the IDs of all controllers in the NVM subsystem, in increasing order.
+func (d *NVMeDevice) ControllerList() ([]uint16, error) {
+	var ids []uint16
+
+	bufp := getBuffer(4096)
+	defer putBuffer(bufp)
+	buf := *bufp
+
+	// Each controller list holds at most 2047 IDs, so continue from the last ID returned until a
+	// short list is received.
+	for cntid := uint16(0); ; {
+		if err := d.identify(NVME_IDENTIFY_CNS_SUBSYS_CONTROLLERS, 0, cntid, buf); err != nil {
+			return nil, err
+		}
+
+		list := decodeControllerList(buf)
+		ids = append(ids, list...)
+
+		if len(list) < 2047 || list[len(list)-1] == 0xffff {
+			break
+		}
+
+		cntid = list[len(list)-1] + 1
+	}
+
+	return ids, nil
+}
+
+// decodeControllerList decodes a controller list data structure, which consists of the number of
+// identifiers followed by up to 2047 controller IDs in increasing order.
+func decodeControllerList(buf []byte) []uint16 {
+	n := int(utils.NativeEndian.Uint16(buf))
+	if n > 2047 {
+		n = 2047
+	}
+
+	ids := make([]uint16, n)
+	for i := range ids {
+		ids[i] = utils.NativeEndian.Uint16(buf[2+i*2:])
+	}
+
+	return ids
+}
+
+// attachNamespace attaches the namespace to, or detaches it from, the specified controllers.
+func (d *NVMeDevice) attachNamespace(nsid uint32, sel uint8, controllers []uint16) error {
+	bufp := getBuffer(4096)
+	defer putBuffer(bufp)
+	buf := *bufp
+
+	// Controller list: number of identifiers, followed by the identifiers themselves
+	utils.NativeEndian.PutUint16(buf, uint16(len(controllers)))
+	for i, id := range controllers {
+		utils.NativeEndian.PutUint16(buf[2+i*2:], id)
+	}
+
+	cmd := nvmePassthruCommand{
+		opcode:   NVME_ADMIN_NS_ATTACH,
+		nsid:     nsid,
+		addr:     uint64(uintptr(unsafe.Pointer(&buf[0]))),
+		data_len: uint32(len(buf)),
+		cdw10:    uint32(sel),
+	}
+
+	_, err := d.adminCommand(&cmd)
+	runtime.KeepAlive(buf)
+
+	return err
+}
+
+// TotalUsedCapacity returns the utilization and total size in bytes of all active namespaces of
+// the controller, summing the Namespace Utilization (NUSE) and Namespace Size (NSZE) of each, scaled
+// by the logical block size of its LBA format.
+func (d *NVMeDevice) TotalUsedCapacity() (used, total uint64, err error) {
+	nsids, err := d.ActiveNamespaces()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, nsid := range nsids {
+		nsUsed, nsTotal, err := d.NamespaceCapacity(nsid)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		used += nsUsed
+		total += nsTotal
+	}
+
+	return used, total, nil
+}
+
+// NamespaceCapacity returns the utilization and total size in bytes of the specified namespace,
+// i.e. its Namespace Utilization (NUSE) and Namespace Size (NSZE) scaled by the logical block size
+// of its LBA format.
+func (d *NVMeDevice) NamespaceCapacity(nsid uint32) (used, total uint64, err error) {
+	ns, err := d.IdentifyNamespace(nsid)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return ns.Nuse * uint64(ns.LBASize()), ns.Nsze * uint64(ns.LBASize()), nil
+}
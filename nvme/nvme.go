@@ -8,12 +8,18 @@ package nvme
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"math"
 	"math/big"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 	"unsafe"
 
-	"golang.org/x/sys/unix"
-
 	"github.com/madper/smart/drivedb"
 	"github.com/madper/smart/ioctl"
 	"github.com/madper/smart/utils"
@@ -22,10 +28,30 @@ import (
 const (
 	NVME_ADMIN_GET_LOG_PAGE = 0x02
 	NVME_ADMIN_IDENTIFY     = 0x06
+
+	// Identify Controller or Namespace Structure (CNS) values
+	NVME_IDENTIFY_CNS_NAMESPACE          = 0x00
+	NVME_IDENTIFY_CNS_CONTROLLER         = 0x01
+	NVME_IDENTIFY_CNS_ACTIVE_NS_LIST     = 0x02 // Active namespace ID list
+	NVME_IDENTIFY_CNS_NS_DESC_LIST       = 0x03 // Namespace identification descriptor list
+	NVME_IDENTIFY_CNS_SUBSYS_CONTROLLERS = 0x13 // Controller list of the NVM subsystem
 )
 
 var (
-	NVME_IOCTL_ADMIN_CMD = ioctl.Iowr('N', 0x41, unsafe.Sizeof(nvmePassthruCommand{}))
+	NVME_IOCTL_ADMIN_CMD   = ioctl.Iowr('N', 0x41, unsafe.Sizeof(nvmePassthruCommand{}))
+	NVME_IOCTL_RESET       = ioctl.Io('N', 0x44)
+	NVME_IOCTL_ADMIN64_CMD = ioctl.Iowr('N', 0x47, nvmePassthruCommand64Size)
+)
+
+// Size of struct nvme_passthru_cmd64, which has a 32-bit reserved field followed by a 64-bit
+// result in place of the 32-bit result of struct nvme_passthru_cmd
+const nvmePassthruCommand64Size = 80
+
+// States of the admin64 field of NVMeDevice
+const (
+	admin64Unknown = iota
+	admin64Supported
+	admin64Unsupported
 )
 
 // Defined in <linux/nvme_ioctl.h>
@@ -50,8 +76,31 @@ type nvmePassthruCommand struct {
 	result       uint32
 } // 72 bytes
 
-type nvmeIdentPowerState struct {
-	MaxPower        uint16 // Centiwatts
+// pack returns the command packed in the layout of struct nvme_passthru_cmd, suitable for passing
+// to the admin passthru ioctl.
+func (c *nvmePassthruCommand) pack() []byte {
+	b := new(bytes.Buffer)
+	binary.Write(b, utils.NativeEndian, c)
+	return b.Bytes()
+}
+
+// pack64 returns the command packed in the layout of struct nvme_passthru_cmd64, suitable for
+// passing to the 64-bit result admin passthru ioctl.
+func (c *nvmePassthruCommand) pack64() []byte {
+	b := make([]byte, nvmePassthruCommand64Size)
+	copy(b, c.pack()[:unsafe.Offsetof(c.result)])
+	return b
+}
+
+// unpackResult copies the result field, which the kernel sets to Dword 0 of the completion queue
+// entry, from a packed command back into c.
+func (c *nvmePassthruCommand) unpackResult(b []byte) {
+	c.result = utils.NativeEndian.Uint32(b[unsafe.Offsetof(c.result):])
+}
+
+// NVMePowerState is a power state descriptor of the identify controller data structure.
+type NVMePowerState struct {
+	MaxPower        uint16 // Centiwatts (or units of 0.0001 W, if bit 0 of Flags is set)
 	Rsvd2           uint8
 	Flags           uint8
 	EntryLat        uint32 // Microseconds
@@ -66,104 +115,748 @@ type nvmeIdentPowerState struct {
 	ActivePower     uint16
 	ActiveWorkScale uint8
 	Rsvd23          [9]byte
+} // 32 bytes
+
+// MaxPowerWatts returns the maximum power consumed in this power state, in watts.
+func (ps *NVMePowerState) MaxPowerWatts() float64 {
+	// Max Power Scale (MXPS) selects units of 0.0001 W instead of 0.01 W
+	if ps.Flags&0x01 != 0 {
+		return float64(ps.MaxPower) / 10000
+	}
+
+	return float64(ps.MaxPower) / 100
+}
+
+// NonOperational reports whether the controller cannot process I/O commands in this power state.
+func (ps *NVMePowerState) NonOperational() bool {
+	return ps.Flags&0x02 != 0
 }
 
-type nvmeIdentController struct {
-	VendorID     uint16                  // PCI Vendor ID
-	Ssvid        uint16                  // PCI Subsystem Vendor ID
-	SerialNumber [20]byte                // Serial Number
-	ModelNumber  [40]byte                // Model Number
-	Firmware     [8]byte                 // Firmware Revision
-	Rab          uint8                   // Recommended Arbitration Burst
-	IEEE         [3]byte                 // IEEE OUI Identifier
-	Cmic         uint8                   // Controller Multi-Path I/O and Namespace Sharing Capabilities
-	Mdts         uint8                   // Maximum Data Transfer Size
-	Cntlid       uint16                  // Controller ID
-	Ver          uint32                  // Version
-	Rtd3r        uint32                  // RTD3 Resume Latency
-	Rtd3e        uint32                  // RTD3 Entry Latency
-	Oaes         uint32                  // Optional Asynchronous Events Supported
-	Rsvd96       [160]byte               // ...
-	Oacs         uint16                  // Optional Admin Command Support
-	Acl          uint8                   // Abort Command Limit
-	Aerl         uint8                   // Asynchronous Event Request Limit
-	Frmw         uint8                   // Firmware Updates
-	Lpa          uint8                   // Log Page Attributes
-	Elpe         uint8                   // Error Log Page Entries
-	Npss         uint8                   // Number of Power States Support
-	Avscc        uint8                   // Admin Vendor Specific Command Configuration
-	Apsta        uint8                   // Autonomous Power State Transition Attributes
-	Wctemp       uint16                  // Warning Composite Temperature Threshold
-	Cctemp       uint16                  // Critical Composite Temperature Threshold
-	Mtfa         uint16                  // Maximum Time for Firmware Activation
-	Hmpre        uint32                  // Host Memory Buffer Preferred Size
-	Hmmin        uint32                  // Host Memory Buffer Minimum Size
-	Tnvmcap      [16]byte                // Total NVM Capacity
-	Unvmcap      [16]byte                // Unallocated NVM Capacity
-	Rpmbs        uint32                  // Replay Protected Memory Block Support
-	Rsvd316      [196]byte               // ...
-	Sqes         uint8                   // Submission Queue Entry Size
-	Cqes         uint8                   // Completion Queue Entry Size
-	Rsvd514      [2]byte                 // (defined in NVMe 1.3 spec)
-	Nn           uint32                  // Number of Namespaces
-	Oncs         uint16                  // Optional NVM Command Support
-	Fuses        uint16                  // Fused Operation Support
-	Fna          uint8                   // Format NVM Attributes
-	Vwc          uint8                   // Volatile Write Cache
-	Awun         uint16                  // Atomic Write Unit Normal
-	Awupf        uint16                  // Atomic Write Unit Power Fail
-	Nvscc        uint8                   // NVM Vendor Specific Command Configuration
-	Rsvd531      uint8                   // ...
-	Acwu         uint16                  // Atomic Compare & Write Unit
-	Rsvd534      [2]byte                 // ...
-	Sgls         uint32                  // SGL Support
-	Rsvd540      [1508]byte              // ...
-	Psd          [32]nvmeIdentPowerState // Power State Descriptors
-	Vs           [1024]byte              // Vendor Specific
+// NVMeVersion is the NVMe specification version supported by a controller, as reported in the VER
+// field of the identify controller data structure.
+type NVMeVersion uint32
+
+// Major returns the major version number.
+func (v NVMeVersion) Major() int {
+	return int(v >> 16)
+}
+
+// Minor returns the minor version number.
+func (v NVMeVersion) Minor() int {
+	return int((v >> 8) & 0xff)
+}
+
+// Tertiary returns the tertiary version number (only defined for NVMe 1.3 and later).
+func (v NVMeVersion) Tertiary() int {
+	return int(v & 0xff)
+}
+
+// String returns the version in "major.minor.tertiary" notation, e.g. "1.4.0". Controllers
+// compliant with NVMe 1.0 / 1.1 may not report a version, in which case "unknown" is returned.
+func (v NVMeVersion) String() string {
+	if v == 0 {
+		return "unknown"
+	}
+
+	return fmt.Sprintf("%d.%d.%d", v.Major(), v.Minor(), v.Tertiary())
+}
+
+// NVMeController is the identify controller data structure returned by an NVMe controller.
+type NVMeController struct {
+	VendorID     uint16             // PCI Vendor ID
+	Ssvid        uint16             // PCI Subsystem Vendor ID
+	SerialNumber [20]byte           // Serial Number
+	ModelNumber  [40]byte           // Model Number
+	Firmware     [8]byte            // Firmware Revision
+	Rab          uint8              // Recommended Arbitration Burst
+	IEEE         [3]byte            // IEEE OUI Identifier
+	Cmic         uint8              // Controller Multi-Path I/O and Namespace Sharing Capabilities
+	Mdts         uint8              // Maximum Data Transfer Size
+	Cntlid       uint16             // Controller ID
+	Ver          NVMeVersion        // Version
+	Rtd3r        uint32             // RTD3 Resume Latency
+	Rtd3e        uint32             // RTD3 Entry Latency
+	Oaes         uint32             // Optional Asynchronous Events Supported
+	Ctratt       uint32             // Controller Attributes
+	Rrls         uint16             // Read Recovery Levels Supported
+	Rsvd102      [9]byte            // ...
+	Cntrltype    NVMeControllerType // Controller Type (NVMe 1.4)
+	Fguid        [16]byte           // FRU Globally Unique Identifier
+	Crdt         [3]uint16          // Command Retry Delay Times (units of 100 ms)
+	Rsvd134      [122]byte          // ...
+	Oacs         uint16             // Optional Admin Command Support
+	Acl          uint8              // Abort Command Limit
+	Aerl         uint8              // Asynchronous Event Request Limit
+	Frmw         uint8              // Firmware Updates
+	Lpa          uint8              // Log Page Attributes
+	Elpe         uint8              // Error Log Page Entries
+	Npss         uint8              // Number of Power States Support
+	Avscc        uint8              // Admin Vendor Specific Command Configuration
+	Apsta        uint8              // Autonomous Power State Transition Attributes
+	Wctemp       uint16             // Warning Composite Temperature Threshold
+	Cctemp       uint16             // Critical Composite Temperature Threshold
+	Mtfa         uint16             // Maximum Time for Firmware Activation
+	Hmpre        uint32             // Host Memory Buffer Preferred Size
+	Hmmin        uint32             // Host Memory Buffer Minimum Size
+	Tnvmcap      [16]byte           // Total NVM Capacity
+	Unvmcap      [16]byte           // Unallocated NVM Capacity
+	Rpmbs        NVMeRPMBSupport    // Replay Protected Memory Block Support
+	Edstt        uint16             // Extended Device Self-test Time (minutes)
+	Dsto         uint8              // Device Self-test Options
+	Fwug         uint8              // Firmware Update Granularity
+	Kas          uint16             // Keep Alive Support
+	Hctma        uint16             // Host Controlled Thermal Management Attributes
+	Mntmt        uint16             // Minimum Thermal Management Temperature
+	Mxtmt        uint16             // Maximum Thermal Management Temperature
+	Sanicap      uint32             // Sanitize Capabilities
+	Hmminds      uint32             // Host Memory Buffer Minimum Descriptor Entry Size
+	Hmmaxd       uint16             // Host Memory Maximum Descriptors Entries
+	Nsetidmax    uint16             // NVM Set Identifier Maximum
+	Endgidmax    uint16             // Endurance Group Identifier Maximum
+	Anatt        uint8              // ANA Transition Time (seconds)
+	Anacap       uint8              // Asymmetric Namespace Access Capabilities
+	Anagrpmax    uint32             // ANA Group Identifier Maximum
+	Nanagrpid    uint32             // Number of ANA Group Identifiers
+	Pels         uint32             // Persistent Event Log Size (units of 64 KiB)
+	Rsvd356      [156]byte          // ...
+	Sqes         uint8              // Submission Queue Entry Size
+	Cqes         uint8              // Completion Queue Entry Size
+	Rsvd514      [2]byte            // (defined in NVMe 1.3 spec)
+	Nn           uint32             // Number of Namespaces
+	Oncs         uint16             // Optional NVM Command Support
+	Fuses        uint16             // Fused Operation Support
+	Fna          uint8              // Format NVM Attributes
+	Vwc          uint8              // Volatile Write Cache
+	Awun         uint16             // Atomic Write Unit Normal
+	Awupf        uint16             // Atomic Write Unit Power Fail
+	Nvscc        uint8              // NVM Vendor Specific Command Configuration
+	Rsvd531      uint8              // ...
+	Acwu         uint16             // Atomic Compare & Write Unit
+	Rsvd534      [2]byte            // ...
+	Sgls         NVMeSGLSupport     // SGL Support
+	Mnan         uint32             // Maximum Number of Allowed Namespaces
+	Rsvd544      [224]byte          // ...
+	Subnqn       [256]byte          // NVM Subsystem NVMe Qualified Name (NVMe 1.2.1)
+	Rsvd1024     [1024]byte         // ...
+	Psd          [32]NVMePowerState // Power State Descriptors
+	Vs           [1024]byte         // Vendor Specific
 } // 4096 bytes
 
+// NVMeControllerType is the type of an NVMe controller, as reported in the CNTRLTYPE field of the
+// identify controller data structure.
+type NVMeControllerType uint8
+
+const (
+	NVME_CTRL_TYPE_NOT_REPORTED NVMeControllerType = iota
+	NVME_CTRL_TYPE_IO                              // I/O controller
+	NVME_CTRL_TYPE_DISCOVERY                       // Discovery controller (NVMe over Fabrics)
+	NVME_CTRL_TYPE_ADMIN                           // Administrative controller
+)
+
+func (t NVMeControllerType) String() string {
+	switch t {
+	case NVME_CTRL_TYPE_NOT_REPORTED:
+		return "not reported"
+	case NVME_CTRL_TYPE_IO:
+		return "I/O controller"
+	case NVME_CTRL_TYPE_DISCOVERY:
+		return "discovery controller"
+	case NVME_CTRL_TYPE_ADMIN:
+		return "administrative controller"
+	default:
+		return fmt.Sprintf("reserved (%d)", uint8(t))
+	}
+}
+
+// ControllerType returns the type of the controller. Controllers compliant with revisions prior to
+// NVMe 1.4 do not report their type, and are I/O controllers unless they are fabrics discovery
+// controllers.
+func (c *NVMeController) ControllerType() NVMeControllerType {
+	return c.Cntrltype
+}
+
+// FRUGUID returns the FRU Globally Unique Identifier of the field replaceable unit containing the
+// controller as a hex string, or an empty string if the controller does not report one.
+func (c *NVMeController) FRUGUID() string {
+	if c.Fguid == [16]byte{} {
+		return ""
+	}
+
+	return fmt.Sprintf("%x", c.Fguid)
+}
+
+// StandardAdminVendorFormat reports whether all vendor specific admin commands use the standard
+// vendor specific command format, with the data transfer length in CDW10 (AVSCC bit 0). If not,
+// the format of vendor specific admin commands is vendor specific.
+func (c *NVMeController) StandardAdminVendorFormat() bool {
+	return c.Avscc&0x01 != 0
+}
+
+// StandardNVMVendorFormat reports whether all vendor specific NVM commands use the standard vendor
+// specific command format (NVSCC bit 0).
+func (c *NVMeController) StandardNVMVendorFormat() bool {
+	return c.Nvscc&0x01 != 0
+}
+
+// SubsystemNQN returns the NVM Subsystem NVMe Qualified Name, or an empty string if the controller
+// does not report one (prior to NVMe 1.2.1).
+func (c *NVMeController) SubsystemNQN() string {
+	return strings.TrimRight(string(c.Subnqn[:]), " \x00")
+}
+
+// String returns a one-line summary of the controller identity for logging, as space-separated
+// key=value pairs, e.g. `model="Samsung SSD 960 PRO 512GB" serial="S3EWNX0J123456"
+// firmware="2B6QCXP7" version=1.2.0 capacity=512110190592`. The capacity is the total NVM
+// capacity in bytes, or zero if the controller does not report it.
+func (c *NVMeController) String() string {
+	return fmt.Sprintf("model=%q serial=%q firmware=%q version=%s capacity=%s",
+		strings.Trim(string(c.ModelNumber[:]), " \x00"),
+		strings.Trim(string(c.SerialNumber[:]), " \x00"),
+		strings.Trim(string(c.Firmware[:]), " \x00"),
+		c.Ver, le128ToBigInt(c.Tnvmcap))
+}
+
+// validate performs basic sanity checks of the identify controller data.
+func (c *NVMeController) validate() error {
+	if len(bytes.Trim(c.ModelNumber[:], " \x00")) == 0 {
+		return errors.New("empty model number")
+	}
+
+	if len(bytes.Trim(c.SerialNumber[:], " \x00")) == 0 {
+		return errors.New("empty serial number")
+	}
+
+	// Controllers compliant with NVMe 1.0 / 1.1 may not report a version at all
+	if c.Ver != 0 && (c.Ver.Major() < 1 || c.Ver.Major() > 2) {
+		return fmt.Errorf("implausible NVMe version %s", c.Ver)
+	}
+
+	return nil
+}
+
+// Version returns the major, minor and tertiary NVMe specification version numbers supported by the
+// controller.
+func (c *NVMeController) Version() (major, minor, tertiary int) {
+	return c.Ver.Major(), c.Ver.Minor(), c.Ver.Tertiary()
+}
+
+// PowerStates returns the power state descriptors of the power states supported by the
+// controller, indexed by power state. An NPSS beyond the 32 power state descriptors is clamped.
+func (c *NVMeController) PowerStates() []NVMePowerState {
+	n := int(c.Npss) + 1
+	if n > len(c.Psd) {
+		n = len(c.Psd)
+	}
+
+	return c.Psd[:n]
+}
+
+// AbortCommandLimit returns the maximum number of concurrently outstanding Abort commands
+// supported by the controller (ACL).
+func (c *NVMeController) AbortCommandLimit() int {
+	return int(c.Acl) + 1
+}
+
+// AsyncEventRequestLimit returns the maximum number of concurrently outstanding Asynchronous Event
+// Request commands supported by the controller (AERL). Any further requests fail with an
+// Asynchronous Event Request Limit Exceeded status.
+func (c *NVMeController) AsyncEventRequestLimit() int {
+	return int(c.Aerl) + 1
+}
+
+// NVMeAsyncEvents describes the optional asynchronous events supported by a controller, as
+// reported in the Optional Asynchronous Events Supported (OAES) field. Controllers always support
+// the mandatory SMART / health critical warning events.
+type NVMeAsyncEvents struct {
+	NamespaceAttribute   bool // Namespace Attribute Notices
+	FirmwareActivation   bool // Firmware Activation Notices
+	ANAChange            bool // Asymmetric Namespace Access Change Notices
+	PredictableLatency   bool // Predictable Latency Event Aggregate Log Change Notices
+	LBAStatus            bool // LBA Status Information Alert Notices
+	EnduranceGroupEvent  bool // Endurance Group Event Aggregate Log Page Change Notices
+	ZoneDescriptorChange bool // Zone Descriptor Changed Notices
+	DiscoveryLogChange   bool // Discovery Log Page Change Notices (NVMe over Fabrics)
+}
+
+// AsyncEvents returns the optional asynchronous events supported by the controller.
+func (c *NVMeController) AsyncEvents() NVMeAsyncEvents {
+	return NVMeAsyncEvents{
+		NamespaceAttribute:   c.Oaes&(1<<8) != 0,
+		FirmwareActivation:   c.Oaes&(1<<9) != 0,
+		ANAChange:            c.Oaes&(1<<11) != 0,
+		PredictableLatency:   c.Oaes&(1<<12) != 0,
+		LBAStatus:            c.Oaes&(1<<13) != 0,
+		EnduranceGroupEvent:  c.Oaes&(1<<14) != 0,
+		ZoneDescriptorChange: c.Oaes&(1<<27) != 0,
+		DiscoveryLogChange:   c.Oaes&(1<<31) != 0,
+	}
+}
+
+// MaxNamespaces returns the maximum value of a valid namespace ID, i.e. the number of namespaces
+// the controller supports (NN). See also Mnan, the maximum number of namespaces which may exist
+// at the same time, if reported.
+func (c *NVMeController) MaxNamespaces() uint32 {
+	return c.Nn
+}
+
+// AtomicWriteUnitNormal returns the size in logical blocks of a write which the controller
+// guarantees to perform atomically with respect to other read and write commands (AWUN). It
+// applies to all namespaces, unless overridden by NVMeNamespace.AtomicWriteUnitNormal.
+func (c *NVMeController) AtomicWriteUnitNormal() int {
+	return int(c.Awun) + 1
+}
+
+// AtomicWriteUnitPowerFail returns the size in logical blocks of a write which the controller
+// guarantees to perform atomically across a power failure or other error condition (AWUPF). It
+// applies to all namespaces, unless overridden by NVMeNamespace.AtomicWriteUnitPowerFail.
+func (c *NVMeController) AtomicWriteUnitPowerFail() int {
+	return int(c.Awupf) + 1
+}
+
+// AtomicCompareWriteUnit returns the size in logical blocks of a Compare and Write fused
+// operation which the controller guarantees to perform atomically (ACWU), or zero if Compare and
+// Write is not supported.
+func (c *NVMeController) AtomicCompareWriteUnit() int {
+	if c.Fuses&0x01 == 0 {
+		return 0
+	}
+
+	return int(c.Acwu) + 1
+}
+
+// HostMemoryBufferPreferred returns the preferred size in bytes of the Host Memory Buffer which the
+// host allocates for the controller's use, or zero if the controller does not use one (HMPRE).
+func (c *NVMeController) HostMemoryBufferPreferred() uint64 {
+	return uint64(c.Hmpre) * 4096
+}
+
+// HostMemoryBufferMinimum returns the minimum size in bytes of the Host Memory Buffer required for
+// the controller to operate, with degraded performance, or zero if there is no minimum (HMMIN).
+func (c *NVMeController) HostMemoryBufferMinimum() uint64 {
+	return uint64(c.Hmmin) * 4096
+}
+
+// NVMeSGLSupport is the SGL Support (SGLS) field of the identify controller data structure, which
+// describes whether and how Scatter Gather Lists may be used to describe command data buffers.
+type NVMeSGLSupport uint32
+
+// Supported reports whether SGLs are supported for the NVM command set.
+func (s NVMeSGLSupport) Supported() bool {
+	return s&0x03 != 0
+}
+
+// DwordAligned reports whether SGL data blocks are required to be dword aligned, and their
+// lengths a multiple of dwords.
+func (s NVMeSGLSupport) DwordAligned() bool {
+	return s&0x03 == 0x02
+}
+
+// KeyedDataBlock reports whether the Keyed SGL Data Block descriptor is supported.
+func (s NVMeSGLSupport) KeyedDataBlock() bool {
+	return s&(1<<2) != 0
+}
+
+// BitBucket reports whether the SGL Bit Bucket descriptor is supported.
+func (s NVMeSGLSupport) BitBucket() bool {
+	return s&(1<<16) != 0
+}
+
+// ByteAlignedMetadata reports whether a byte aligned contiguous physical buffer of metadata is
+// supported, as opposed to a dword aligned one.
+func (s NVMeSGLSupport) ByteAlignedMetadata() bool {
+	return s&(1<<17) != 0
+}
+
+// OversizedData reports whether SGLs describing more data than the command transfers are
+// supported.
+func (s NVMeSGLSupport) OversizedData() bool {
+	return s&(1<<18) != 0
+}
+
+// MetadataSGL reports whether the metadata pointer may point to an SGL segment containing an SGL
+// descriptor, rather than a contiguous buffer.
+func (s NVMeSGLSupport) MetadataSGL() bool {
+	return s&(1<<19) != 0
+}
+
+// AddressOffset reports whether the address field of SGL data block descriptors may specify an
+// offset, rather than an absolute address.
+func (s NVMeSGLSupport) AddressOffset() bool {
+	return s&(1<<20) != 0
+}
+
+// TransportDataBlock reports whether the Transport SGL Data Block descriptor is supported.
+func (s NVMeSGLSupport) TransportDataBlock() bool {
+	return s&(1<<21) != 0
+}
+
+func (s NVMeSGLSupport) String() string {
+	switch {
+	case !s.Supported():
+		return "not supported"
+	case s.DwordAligned():
+		return "supported (dword aligned)"
+	default:
+		return "supported"
+	}
+}
+
+// NVMeRPMBSupport is the Replay Protected Memory Block Support (RPMBS) field of the identify
+// controller data structure.
+type NVMeRPMBSupport uint32
+
+// RPMB authentication methods
+const (
+	NVME_RPMB_AUTH_HMAC_SHA256 = 0x0
+)
+
+// Units returns the number of RPMB targets supported by the controller, or zero if RPMB is not
+// supported.
+func (r NVMeRPMBSupport) Units() int {
+	return int(r & 0x07)
+}
+
+// Supported reports whether the controller supports at least one RPMB target.
+func (r NVMeRPMBSupport) Supported() bool {
+	return r.Units() > 0
+}
+
+// AuthMethod returns the authentication method used to access the RPMB, e.g.
+// NVME_RPMB_AUTH_HMAC_SHA256.
+func (r NVMeRPMBSupport) AuthMethod() uint8 {
+	return uint8(r>>3) & 0x07
+}
+
+// TotalSize returns the size of each RPMB target in bytes.
+func (r NVMeRPMBSupport) TotalSize() uint64 {
+	if !r.Supported() {
+		return 0
+	}
+
+	// Zero-based value in units of 128 KiB
+	return (uint64(r>>16&0xff) + 1) * 128 * 1024
+}
+
+// AccessSize returns the maximum size in bytes which may be read or written per RPMB command.
+func (r NVMeRPMBSupport) AccessSize() uint64 {
+	if !r.Supported() {
+		return 0
+	}
+
+	// Zero-based value in units of 512 bytes
+	return (uint64(r>>24) + 1) * 512
+}
+
+func (r NVMeRPMBSupport) String() string {
+	if !r.Supported() {
+		return "not supported"
+	}
+
+	method := "unknown authentication"
+	if r.AuthMethod() == NVME_RPMB_AUTH_HMAC_SHA256 {
+		method = "HMAC SHA-256"
+	}
+
+	return fmt.Sprintf("%d unit(s) of %s, %s", r.Units(), utils.FormatBytes(r.TotalSize()), method)
+}
+
 type nvmeLBAF struct {
-	Ms uint16
-	Ds uint8
-	Rp uint8
-}
-
-type nvmeIdentNamespace struct {
-	Nsze    uint64
-	Ncap    uint64
-	Nuse    uint64
-	Nsfeat  uint8
-	Nlbaf   uint8
-	Flbas   uint8
-	Mc      uint8
-	Dpc     uint8
-	Dps     uint8
-	Nmic    uint8
-	Rescap  uint8
-	Fpi     uint8
-	Rsvd33  uint8
-	Nawun   uint16
-	Nawupf  uint16
-	Nacwu   uint16
-	Nabsn   uint16
-	Nabo    uint16
-	Nabspf  uint16
-	Rsvd46  [2]byte
-	Nvmcap  [16]byte
-	Rsvd64  [40]byte
-	Nguid   [16]byte
-	EUI64   [8]byte
-	Lbaf    [16]nvmeLBAF
-	Rsvd192 [192]byte
-	Vs      [3712]byte
+	Ms uint16 // Metadata Size (bytes)
+	Ds uint8  // LBA Data Size (log2 bytes)
+	Rp uint8  // Relative Performance
+}
+
+// String returns a description of the LBA format, e.g. "512B + 8B metadata (best perf)".
+func (f nvmeLBAF) String() string {
+	if f.Ds == 0 {
+		return "unsupported"
+	}
+
+	s := fmt.Sprintf("%dB", uint64(1)<<f.Ds)
+	if f.Ms > 0 {
+		s += fmt.Sprintf(" + %dB metadata", f.Ms)
+	}
+
+	perf := [...]string{"best", "better", "good", "degraded"}
+
+	return s + fmt.Sprintf(" (%s perf)", perf[f.Rp&0x03])
+}
+
+// NVMeNamespace is the identify namespace data structure returned by an NVMe controller.
+type NVMeNamespace struct {
+	Nsze     uint64
+	Ncap     uint64
+	Nuse     uint64
+	Nsfeat   uint8
+	Nlbaf    uint8
+	Flbas    uint8
+	Mc       uint8
+	Dpc      uint8
+	Dps      uint8
+	Nmic     uint8
+	Rescap   uint8
+	Fpi      uint8
+	Rsvd33   uint8
+	Nawun    uint16
+	Nawupf   uint16
+	Nacwu    uint16
+	Nabsn    uint16
+	Nabo     uint16
+	Nabspf   uint16
+	Noiob    uint16 // Namespace Optimal I/O Boundary (logical blocks)
+	Nvmcap   [16]byte
+	Npwg     uint16 // Namespace Preferred Write Granularity (0's based, logical blocks)
+	Npwa     uint16 // Namespace Preferred Write Alignment (0's based, logical blocks)
+	Npdg     uint16 // Namespace Preferred Deallocate Granularity (0's based, logical blocks)
+	Npda     uint16 // Namespace Preferred Deallocate Alignment (0's based, logical blocks)
+	Nows     uint16 // Namespace Optimal Write Size (0's based, logical blocks)
+	Rsvd74   [18]byte
+	Anagrpid uint32 // ANA Group Identifier
+	Rsvd96   [3]byte
+	Nsattr   uint8  // Namespace Attributes
+	Nvmsetid uint16 // NVM Set Identifier
+	Endgid   uint16 // Endurance Group Identifier
+	Nguid    [16]byte
+	EUI64    [8]byte
+	Lbaf     [64]nvmeLBAF // LBA formats (formats 16-63 since NVMe 2.0)
+	Vs       [3712]byte
 } // 4096 bytes
 
-type nvmeSMARTLog struct {
+// LBAFormatIndex returns the index of the LBA format the namespace is currently formatted with.
+// Controllers supporting more than 16 LBA formats (NVMe 2.0) report the two most significant bits
+// of the index in FLBAS bits 6:5, which are otherwise reserved.
+func (ns *NVMeNamespace) LBAFormatIndex() int {
+	return int(ns.Flbas&0x60)>>1 | int(ns.Flbas&0x0f)
+}
+
+// LBASize returns the logical block size of the namespace in bytes.
+func (ns *NVMeNamespace) LBASize() int {
+	return 1 << ns.Lbaf[ns.LBAFormatIndex()].Ds
+}
+
+// MetadataSize returns the number of metadata bytes per logical block of the LBA format the
+// namespace is currently formatted with.
+func (ns *NVMeNamespace) MetadataSize() int {
+	return int(ns.Lbaf[ns.LBAFormatIndex()].Ms)
+}
+
+// ExtendedLBA reports whether metadata is currently transferred at the end of each logical block
+// as part of an extended data LBA (FLBAS bit 4), rather than in a separate buffer. If so, data
+// buffers must hold LBASize() + MetadataSize() bytes per logical block.
+func (ns *NVMeNamespace) ExtendedLBA() bool {
+	return ns.Flbas&0x10 != 0
+}
+
+// MetadataCapabilities reports whether the namespace supports transferring metadata as part of an
+// extended data LBA, and in a separate buffer (MC bits 0 and 1).
+func (ns *NVMeNamespace) MetadataCapabilities() (extended, separate bool) {
+	return ns.Mc&0x01 != 0, ns.Mc&0x02 != 0
+}
+
+// ProtectionInfo decodes the end-to-end data protection settings of the namespace (DPS). If
+// protection information is enabled, piType is the protection information type (1, 2 or 3), and
+// mdStart reports whether it is transferred as the first eight bytes of the metadata, rather than
+// the last eight.
+func (ns *NVMeNamespace) ProtectionInfo() (enabled bool, piType int, mdStart bool) {
+	piType = int(ns.Dps & 0x07)
+
+	return piType != 0, piType, ns.Dps&0x08 != 0
+}
+
+// ProtectionInfoSupported reports whether the namespace supports the specified protection
+// information type (1, 2 or 3), according to its end-to-end data protection capabilities (DPC).
+func (ns *NVMeNamespace) ProtectionInfoSupported(piType int) bool {
+	if piType < 1 || piType > 3 {
+		return false
+	}
+
+	return ns.Dpc&(1<<uint(piType-1)) != 0
+}
+
+// namespaceAtomics reports whether the namespace defines its own atomic write unit sizes (NSFEAT
+// bit 1), which then take precedence over those of the controller.
+func (ns *NVMeNamespace) namespaceAtomics() bool {
+	return ns.Nsfeat&0x02 != 0
+}
+
+// AtomicWriteUnitNormal returns the atomic write unit (normal) in logical blocks which applies to
+// the namespace: its own NAWUN if it defines one, or the AWUN of the controller c otherwise.
+func (ns *NVMeNamespace) AtomicWriteUnitNormal(c *NVMeController) int {
+	if ns.namespaceAtomics() {
+		return int(ns.Nawun) + 1
+	}
+
+	return c.AtomicWriteUnitNormal()
+}
+
+// AtomicWriteUnitPowerFail returns the atomic write unit (power fail) in logical blocks which
+// applies to the namespace: its own NAWUPF if it defines one, or the AWUPF of the controller c
+// otherwise. This is the size of write which is guaranteed not to be torn by a power failure.
+func (ns *NVMeNamespace) AtomicWriteUnitPowerFail(c *NVMeController) int {
+	if ns.namespaceAtomics() {
+		return int(ns.Nawupf) + 1
+	}
+
+	return c.AtomicWriteUnitPowerFail()
+}
+
+// OptimalWriteAlignment returns the alignment in bytes to which writes should be aligned for
+// optimal performance. This is the preferred write alignment if the namespace reports the
+// optimal performance fields (NSFEAT bit 4), else the atomic write unit for power fail if the
+// namespace reports its own atomic boundaries (NSFEAT bit 1), else the logical block size.
+func (ns *NVMeNamespace) OptimalWriteAlignment() int {
+	switch {
+	case ns.Nsfeat&0x10 != 0:
+		return (int(ns.Npwa) + 1) * ns.LBASize()
+	case ns.Nsfeat&0x02 != 0:
+		return (int(ns.Nawupf) + 1) * ns.LBASize()
+	default:
+		return ns.LBASize()
+	}
+}
+
+// ReadOnly reports whether the namespace is write protected (NSATTR bit 0), e.g. as a result of
+// the Namespace Write Protection Config feature. See also NVMeSMARTData.ReadOnly, which reports
+// whether the media of the whole controller has been placed in read only mode.
+func (ns *NVMeNamespace) ReadOnly() bool {
+	return ns.Nsattr&0x01 != 0
+}
+
+// Shared reports whether the namespace may be attached to two or more controllers in the NVM
+// subsystem concurrently (NMIC bit 0).
+func (ns *NVMeNamespace) Shared() bool {
+	return ns.Nmic&0x01 != 0
+}
+
+// NVMeReservationCapabilities describes the reservation types supported by a namespace, as
+// reported in the Reservation Capabilities (RESCAP) field.
+type NVMeReservationCapabilities struct {
+	PersistThroughPowerLoss bool // Persist Through Power Loss
+	WriteExclusive          bool // Write Exclusive
+	ExclusiveAccess         bool // Exclusive Access
+	WriteExclusiveRegOnly   bool // Write Exclusive - Registrants Only
+	ExclusiveAccessRegOnly  bool // Exclusive Access - Registrants Only
+	WriteExclusiveAllReg    bool // Write Exclusive - All Registrants
+	ExclusiveAccessAllReg   bool // Exclusive Access - All Registrants
+	IgnoreExistingKey       bool // Ignore Existing Key behaviour (NVMe 1.3 and later)
+}
+
+// Supported reports whether the namespace supports reservations at all.
+func (r NVMeReservationCapabilities) Supported() bool {
+	return r.WriteExclusive || r.ExclusiveAccess || r.WriteExclusiveRegOnly ||
+		r.ExclusiveAccessRegOnly || r.WriteExclusiveAllReg || r.ExclusiveAccessAllReg
+}
+
+// ReservationCapabilities returns the reservation types supported by the namespace.
+func (ns *NVMeNamespace) ReservationCapabilities() NVMeReservationCapabilities {
+	return NVMeReservationCapabilities{
+		PersistThroughPowerLoss: ns.Rescap&(1<<0) != 0,
+		WriteExclusive:          ns.Rescap&(1<<1) != 0,
+		ExclusiveAccess:         ns.Rescap&(1<<2) != 0,
+		WriteExclusiveRegOnly:   ns.Rescap&(1<<3) != 0,
+		ExclusiveAccessRegOnly:  ns.Rescap&(1<<4) != 0,
+		WriteExclusiveAllReg:    ns.Rescap&(1<<5) != 0,
+		ExclusiveAccessAllReg:   ns.Rescap&(1<<6) != 0,
+		IgnoreExistingKey:       ns.Rescap&(1<<7) != 0,
+	}
+}
+
+// LBAFormatString returns a description of the LBA format the namespace is currently formatted
+// with, e.g. "512B + 8B metadata (best perf)".
+func (ns *NVMeNamespace) LBAFormatString() string {
+	return ns.Lbaf[ns.LBAFormatIndex()].String()
+}
+
+// LBAFormat describes one of the LBA formats supported by a namespace.
+type LBAFormat struct {
+	Index        int  // Index of the format, as selected by FLBAS or the Format NVM command
+	DataSize     int  // Logical block size (bytes)
+	MetadataSize int  // Metadata size per logical block (bytes)
+	Performance  int  // Relative performance, from 0 (best) to 3 (degraded)
+	InUse        bool // Whether the namespace is currently formatted with this format
+}
+
+func (f LBAFormat) String() string {
+	lbaf := nvmeLBAF{Ms: uint16(f.MetadataSize), Ds: uint8(utils.Log2b(uint(f.DataSize))), Rp: uint8(f.Performance)}
+
+	return fmt.Sprintf("%d: %s", f.Index, lbaf)
+}
+
+// SupportedLBAFormats returns the LBA formats supported by the namespace, ordered by relative
+// performance (best first), and by index among formats of equal performance.
+func (ns *NVMeNamespace) SupportedLBAFormats() []LBAFormat {
+	var formats []LBAFormat
+
+	for i := 0; i <= int(ns.Nlbaf) && i < len(ns.Lbaf); i++ {
+		f := ns.Lbaf[i]
+
+		// A zero LBA data size denotes an unsupported format
+		if f.Ds == 0 {
+			continue
+		}
+
+		formats = append(formats, LBAFormat{
+			Index:        i,
+			DataSize:     1 << f.Ds,
+			MetadataSize: int(f.Ms),
+			Performance:  int(f.Rp & 0x03),
+			InUse:        i == ns.LBAFormatIndex(),
+		})
+	}
+
+	sort.SliceStable(formats, func(i, j int) bool {
+		return formats[i].Performance < formats[j].Performance
+	})
+
+	return formats
+}
+
+// SupportedLBAFormats returns the LBA formats supported by the controller, ordered by relative
+// performance. Controllers supporting namespace management report the capabilities common to all
+// namespaces, including the LBA formats, for namespace ID FFFFFFFFh. Otherwise, or if that fails,
+// the LBA formats of namespace 1 are returned, which need not match those of other namespaces.
+func (d *NVMeDevice) SupportedLBAFormats() ([]LBAFormat, error) {
+	controller, err := d.IdentifyController()
+	if err != nil {
+		return nil, err
+	}
+
+	var ns *NVMeNamespace
+	if controller.Oacs&NVME_OACS_NS_MGMT != 0 {
+		ns, err = d.IdentifyNamespace(0xffffffff)
+	}
+
+	if ns == nil {
+		if ns, err = d.IdentifyNamespace(1); err != nil {
+			return nil, err
+		}
+	}
+
+	return ns.SupportedLBAFormats(), nil
+}
+
+// WWN returns the globally unique identifier of the namespace in the "eui." notation used by the
+// Linux kernel, preferring the NGUID over the EUI-64. An empty string is returned if the namespace
+// reports neither.
+func (ns *NVMeNamespace) WWN() string {
+	if ns.Nguid != [16]byte{} {
+		return fmt.Sprintf("eui.%x", ns.Nguid)
+	}
+
+	if ns.EUI64 != [8]byte{} {
+		return fmt.Sprintf("eui.%x", ns.EUI64)
+	}
+
+	return ""
+}
+
+// NVMeSMARTData is the SMART / Health Information log page (02h).
+type NVMeSMARTData struct {
 	CritWarning      uint8
 	Temperature      [2]uint8
 	AvailSpare       uint8
 	SpareThresh      uint8
-	PercentUsed      uint8
+	PercentUsed      uint8 // May exceed 100, see PercentageUsed()
 	Rsvd6            [26]byte
 	DataUnitsRead    [16]byte
 	DataUnitsWritten [16]byte
@@ -178,60 +871,264 @@ type nvmeSMARTLog struct {
 	WarningTempTime  uint32
 	CritCompTime     uint32
 	TempSensor       [8]uint16
-	Rsvd216          [296]byte
+	ThmTemp1TransCnt uint32 // Thermal Management Temperature 1 Transition Count (NVMe 1.3)
+	ThmTemp2TransCnt uint32 // Thermal Management Temperature 2 Transition Count (NVMe 1.3)
+	ThmTemp1Time     uint32 // Total Time For Thermal Management Temperature 1 (seconds)
+	ThmTemp2Time     uint32 // Total Time For Thermal Management Temperature 2 (seconds)
+	Rsvd232          [280]byte
 } // 512 bytes
 
+// dataUnitBytes is the size of a data unit in the SMART / Health Information log page. The spec
+// defines a data unit as 1000 units of 512 bytes, regardless of the namespace LBA size.
+var dataUnitBytes = big.NewInt(1000 * 512)
+
+// BytesRead returns the number of bytes read from the controller by the host.
+func (sl *NVMeSMARTData) BytesRead() *big.Int {
+	return new(big.Int).Mul(le128ToBigInt(sl.DataUnitsRead), dataUnitBytes)
+}
+
+// BytesWritten returns the number of bytes written to the controller by the host.
+func (sl *NVMeSMARTData) BytesWritten() *big.Int {
+	return new(big.Int).Mul(le128ToBigInt(sl.DataUnitsWritten), dataUnitBytes)
+}
+
+// HostReadCommands returns the number of read commands completed by the controller. Together
+// with BytesRead, it gives the average read size.
+func (sl *NVMeSMARTData) HostReadCommands() uint64 {
+	return le128Uint64(sl.HostReads)
+}
+
+// HostWriteCommands returns the number of write commands completed by the controller.
+func (sl *NVMeSMARTData) HostWriteCommands() uint64 {
+	return le128Uint64(sl.HostWrites)
+}
+
+// ReadOnly reports whether the media has been placed in read only mode, as indicated by bit 3 of
+// the critical warning field.
+func (sl *NVMeSMARTData) ReadOnly() bool {
+	return sl.CritWarning&0x08 != 0
+}
+
+// PercentageUsed returns the vendor-specific estimate of the percentage of the rated endurance of
+// the NVM subsystem which has been used. Values above 100 are valid, and indicate that the device
+// has exceeded its rated endurance. The value saturates at 255.
+func (sl *NVMeSMARTData) PercentageUsed() int {
+	return int(sl.PercentUsed)
+}
+
+// BeyondRatedEndurance reports whether the device has exceeded its rated endurance.
+func (sl *NVMeSMARTData) BeyondRatedEndurance() bool {
+	return sl.PercentUsed > 100
+}
+
+// EstimatedRemainingDays extrapolates the number of days until the device reaches its rated
+// endurance (100 percent used), from the wear accrued between a prior sample and this one, taken
+// elapsed apart. Since the percentage used is only reported in whole percents, the samples are
+// often too close together to observe any change, in which case the average wear rate over the
+// lifetime of the device (from the power on hours) is used instead. Zero is returned if the
+// device has already reached its rated endurance, and +Inf if no wear rate can be determined.
+func (sl *NVMeSMARTData) EstimatedRemainingDays(prior *NVMeSMARTData, elapsed time.Duration) float64 {
+	used := float64(sl.PercentUsed)
+	if used >= 100 {
+		return 0
+	}
+
+	var perDay float64
+
+	if prior != nil && elapsed > 0 && sl.PercentUsed > prior.PercentUsed {
+		perDay = float64(sl.PercentUsed-prior.PercentUsed) / (elapsed.Hours() / 24)
+	} else if hours := le128ToBigInt(sl.PowerOnHours); sl.PercentUsed > 0 && hours.Sign() > 0 {
+		h, _ := new(big.Float).SetInt(hours).Float64()
+		perDay = used / (h / 24)
+	}
+
+	if perDay <= 0 {
+		return math.Inf(1)
+	}
+
+	return (100 - used) / perDay
+}
+
+// WarningTemperatureTime returns the cumulative time the controller has spent with its composite
+// temperature at or above the warning composite temperature threshold (WCTEMP), but below the
+// critical threshold.
+func (sl *NVMeSMARTData) WarningTemperatureTime() time.Duration {
+	return time.Duration(sl.WarningTempTime) * time.Minute
+}
+
+// CriticalTemperatureTime returns the cumulative time the controller has spent with its composite
+// temperature at or above the critical composite temperature threshold (CCTEMP).
+func (sl *NVMeSMARTData) CriticalTemperatureTime() time.Duration {
+	return time.Duration(sl.CritCompTime) * time.Minute
+}
+
+// PowerOnDuration returns the cumulative time the controller has been powered on, which the spec
+// reports in hours (Power On Hours). Time spent in non-operational power states may be excluded.
+func (sl *NVMeSMARTData) PowerOnDuration() time.Duration {
+	return le128Duration(sl.PowerOnHours, time.Hour)
+}
+
+// ControllerBusyDuration returns the cumulative time the controller has been busy with I/O
+// commands, which the spec reports in minutes (Controller Busy Time).
+func (sl *NVMeSMARTData) ControllerBusyDuration() time.Duration {
+	return le128Duration(sl.CtrlBusyTime, time.Minute)
+}
+
+// ThermalThrottle1Count returns the number of times the controller has entered the lighter
+// thermal throttling state, i.e. transitioned to lower power states or performed vendor specific
+// thermal management actions because the composite temperature exceeded the host controlled
+// Thermal Management Temperature 1 (TMT1). The count saturates at 0xffffffff.
+func (sl *NVMeSMARTData) ThermalThrottle1Count() uint32 {
+	return sl.ThmTemp1TransCnt
+}
+
+// ThermalThrottle2Count returns the number of times the controller has entered the heavier
+// thermal throttling state, because the composite temperature exceeded the host controlled
+// Thermal Management Temperature 2 (TMT2). The count saturates at 0xffffffff.
+func (sl *NVMeSMARTData) ThermalThrottle2Count() uint32 {
+	return sl.ThmTemp2TransCnt
+}
+
+// ThermalThrottle1Time returns the cumulative time the controller has spent in the thermal
+// throttling state entered at Thermal Management Temperature 1.
+func (sl *NVMeSMARTData) ThermalThrottle1Time() time.Duration {
+	return time.Duration(sl.ThmTemp1Time) * time.Second
+}
+
+// ThermalThrottle2Time returns the cumulative time the controller has spent in the thermal
+// throttling state entered at Thermal Management Temperature 2.
+func (sl *NVMeSMARTData) ThermalThrottle2Time() time.Duration {
+	return time.Duration(sl.ThmTemp2Time) * time.Second
+}
+
+// CompositeTemperature returns the composite temperature of the controller and its namespaces.
+func (sl *NVMeSMARTData) CompositeTemperature() utils.Temperature {
+	return utils.Temperature((uint16(sl.Temperature[1]) << 8) | uint16(sl.Temperature[0]))
+}
+
+// SensorTemperatures returns the readings of the implemented temperature sensors, in sensor
+// order. Sensors reporting zero are not implemented, and are omitted.
+func (sl *NVMeSMARTData) SensorTemperatures() []utils.Temperature {
+	var temps []utils.Temperature
+
+	for _, t := range sl.TempSensor {
+		if t != 0 {
+			temps = append(temps, utils.Temperature(t))
+		}
+	}
+
+	return temps
+}
+
+// CompositeTemperatureMismatch reports whether the composite temperature differs by more than
+// tolerance degrees from the readings of all implemented temperature sensors, which suggests that
+// the firmware misreports the composite temperature. The composite temperature is derived from
+// the sensor readings, so it would be expected to lie within or close to their range. False is
+// returned if the controller implements no sensors other than the composite.
+func (sl *NVMeSMARTData) CompositeTemperatureMismatch(tolerance float64) bool {
+	temps := sl.SensorTemperatures()
+	if len(temps) == 0 {
+		return false
+	}
+
+	composite := sl.CompositeTemperature().Kelvin()
+
+	for _, t := range temps {
+		if math.Abs(composite-t.Kelvin()) <= tolerance {
+			return false
+		}
+	}
+
+	return true
+}
+
+// NVMeDevice is a handle to an NVMe controller or namespace device node. Its methods may be called
+// concurrently from multiple goroutines: admin commands are serialised by an internal mutex, and
+// each command uses its own data buffer. Operations consisting of several commands (e.g.
+// PersistentEventLog or ActivateFirmware) are not atomic, however, since commands of other
+// goroutines may be interleaved with theirs. An NVMeDevice must not be copied after first use.
 type NVMeDevice struct {
 	Name string
-	fd   int
+
+	// NonBlock opens the device node with O_NONBLOCK, so that the open itself does not hang on a
+	// faulty device. It has no effect on admin commands, which always block until completion.
+	NonBlock bool
+
+	// ReadOnly opens the device node with O_RDONLY instead of O_RDWR, so that only read access to
+	// it is required. The kernel then only permits admin passthrough commands which do not modify
+	// the controller (e.g. Identify and Get Log Page), unless the caller has CAP_SYS_ADMIN; the
+	// others require the FMODE_WRITE of a writable file descriptor.
+	ReadOnly bool
+
+	mu      sync.Mutex // Serialises ioctls, and guards fd and admin64
+	fd      int
+	admin64 int // Whether the kernel supports NVME_IOCTL_ADMIN64_CMD, once probed
 }
 
 func NewNVMeDevice(name string) *NVMeDevice {
-	return &NVMeDevice{name, -1}
+	return &NVMeDevice{Name: name, fd: -1}
 }
 
-func (d *NVMeDevice) Open() (err error) {
-	d.fd, err = unix.Open(d.Name, unix.O_RDWR, 0600)
-	return err
-}
+// OpenNVMeDevice opens the named device and verifies that it is an NVMe controller with Verify,
+// which catches device paths of other types (e.g. SATA disks) passed by mistake.
+func OpenNVMeDevice(name string) (*NVMeDevice, error) {
+	d := NewNVMeDevice(name)
 
-func (d *NVMeDevice) Close() error {
-	return unix.Close(d.fd)
-}
+	if err := d.Open(); err != nil {
+		return nil, err
+	}
 
-// WIP - need to split out functionality further.
-func (d *NVMeDevice) PrintSMART(db *drivedb.DriveDb) error {
-	fmt.Println("OK")
+	if err := d.Verify(); err != nil {
+		d.Close()
+		return nil, err
+	}
 
-	buf := make([]byte, 4096)
+	return d, nil
+}
 
-	cmd := nvmePassthruCommand{
-		opcode:   NVME_ADMIN_IDENTIFY,
-		nsid:     0, // Namespace 0, since we are identifying the controller
-		addr:     uint64(uintptr(unsafe.Pointer(&buf[0]))),
-		data_len: uint32(len(buf)),
-		cdw10:    1, // Identify controller
+// Verify issues an identify controller command, and returns an error if the device does not
+// respond to it, or the identify data does not look like that of an NVMe controller.
+func (d *NVMeDevice) Verify() error {
+	controller, err := d.IdentifyController()
+	if err != nil {
+		return fmt.Errorf("%s: not an NVMe controller: identify controller failed: %v", d.Name, err)
 	}
 
-	if err := ioctl.Ioctl(uintptr(d.fd), NVME_IOCTL_ADMIN_CMD, uintptr(unsafe.Pointer(&cmd))); err != nil {
-		return err
+	if err := controller.validate(); err != nil {
+		return fmt.Errorf("%s: not an NVMe controller: %v", d.Name, err)
 	}
 
-	fmt.Printf("NVMe call: opcode=%#02x, size=%#04x, nsid=%#08x, cdw10=%#08x\n",
-		cmd.opcode, cmd.data_len, cmd.nsid, cmd.cdw10)
+	return nil
+}
 
-	var controller nvmeIdentController
+// WIP - need to split out functionality further.
+func (d *NVMeDevice) PrintSMART(db *drivedb.DriveDb) error {
+	fmt.Println("OK")
 
-	binary.Read(bytes.NewBuffer(buf[:]), utils.NativeEndian, &controller)
+	controller, err := d.IdentifyController()
+	if err != nil {
+		return err
+	}
 
 	fmt.Println()
 	fmt.Printf("Vendor ID: %#04x\n", controller.VendorID)
 	fmt.Printf("Model number: %s\n", controller.ModelNumber)
 	fmt.Printf("Serial number: %s\n", controller.SerialNumber)
 	fmt.Printf("Firmware version: %s\n", controller.Firmware)
+	fmt.Printf("NVMe version: %s\n", controller.Ver)
+	fmt.Printf("Subsystem NQN: %s\n", controller.SubsystemNQN())
+	fmt.Printf("Controller type: %s\n", controller.ControllerType())
 	fmt.Printf("IEEE OUI identifier: 0x%02x%02x%02x\n",
 		controller.IEEE[2], controller.IEEE[1], controller.IEEE[0])
 	fmt.Printf("Max. data transfer size: %d pages\n", 1<<controller.Mdts)
+	fmt.Printf("Max. namespaces: %d\n", controller.MaxNamespaces())
+	fmt.Printf("SGL support: %s\n", controller.Sgls)
+	fmt.Printf("RPMB support: %s\n", controller.Rpmbs)
+
+	if hmb := controller.HostMemoryBufferPreferred(); hmb > 0 {
+		fmt.Printf("Host memory buffer: %s preferred, %s minimum\n",
+			utils.FormatBytes(hmb), utils.FormatBytes(controller.HostMemoryBufferMinimum()))
+	}
 
 	for _, ps := range controller.Psd {
 		if ps.MaxPower > 0 {
@@ -239,57 +1136,30 @@ func (d *NVMeDevice) PrintSMART(db *drivedb.DriveDb) error {
 		}
 	}
 
-	buf2 := make([]byte, 4096)
-
-	cmd = nvmePassthruCommand{
-		opcode:   NVME_ADMIN_IDENTIFY,
-		nsid:     1, // Namespace 1
-		addr:     uint64(uintptr(unsafe.Pointer(&buf2[0]))),
-		data_len: uint32(len(buf2)),
-		cdw10:    0,
-	}
-
-	if err := ioctl.Ioctl(uintptr(d.fd), NVME_IOCTL_ADMIN_CMD, uintptr(unsafe.Pointer(&cmd))); err != nil {
+	ns, err := d.IdentifyNamespace(1)
+	if err != nil {
 		return err
 	}
 
-	fmt.Printf("NVMe call: opcode=%#02x, size=%#04x, nsid=%#08x, cdw10=%#08x\n",
-		cmd.opcode, cmd.data_len, cmd.nsid, cmd.cdw10)
-
-	var ns nvmeIdentNamespace
-
-	binary.Read(bytes.NewBuffer(buf2[:]), utils.NativeEndian, &ns)
-
 	fmt.Printf("Namespace 1 size: %d sectors\n", ns.Nsze)
 	fmt.Printf("Namespace 1 utilisation: %d sectors\n", ns.Nuse)
+	fmt.Printf("Namespace 1 LBA format: %s\n", ns.LBAFormatString())
 
-	buf3 := make([]byte, 512)
-
-	// Read SMART log
-	if err := d.readLogPage(0x02, &buf3); err != nil {
+	sl, err := d.ReadSMART()
+	if err != nil {
 		return err
 	}
 
-	var sl nvmeSMARTLog
-
-	binary.Read(bytes.NewBuffer(buf3[:]), utils.NativeEndian, &sl)
-
-	// TODO: Implement bytes to "KMGTP" function
-	unitsRead := le128ToBigInt(sl.DataUnitsRead)
-	unitsWritten := le128ToBigInt(sl.DataUnitsWritten)
-	unit := big.NewInt(512 * 1000)
-
 	fmt.Println("\nSMART data follows:")
 	fmt.Printf("Critical warning: %#02x\n", sl.CritWarning)
-	fmt.Printf("Temperature: %d Celsius\n",
-		((uint16(sl.Temperature[1])<<8)|uint16(sl.Temperature[0]))-273) // Kelvin to degrees Celsius
+	fmt.Printf("Temperature: %s\n", sl.CompositeTemperature())
 	fmt.Printf("Avail. spare: %d%%\n", sl.AvailSpare)
 	fmt.Printf("Avail. spare threshold: %d%%\n", sl.SpareThresh)
-	fmt.Printf("Percentage used: %d%%\n", sl.PercentUsed)
+	fmt.Printf("Percentage used: %d%%\n", sl.PercentageUsed())
 	fmt.Printf("Data units read: %d [%s]\n",
-		unitsRead, utils.FormatBigBytes(new(big.Int).Mul(unitsRead, unit)))
+		le128ToBigInt(sl.DataUnitsRead), utils.FormatBigBytes(sl.BytesRead()))
 	fmt.Printf("Data units written: %d [%s]\n",
-		unitsWritten, utils.FormatBigBytes(new(big.Int).Mul(unitsWritten, unit)))
+		le128ToBigInt(sl.DataUnitsWritten), utils.FormatBigBytes(sl.BytesWritten()))
 	fmt.Printf("Host read commands: %d\n", le128ToBigInt(sl.HostReads))
 	fmt.Printf("Host write commands: %d\n", le128ToBigInt(sl.HostWrites))
 	fmt.Printf("Controller busy time: %d\n", le128ToBigInt(sl.CtrlBusyTime))
@@ -298,26 +1168,343 @@ func (d *NVMeDevice) PrintSMART(db *drivedb.DriveDb) error {
 	fmt.Printf("Unsafe shutdowns: %d\n", le128ToBigInt(sl.UnsafeShutdowns))
 	fmt.Printf("Media & data integrity errors: %d\n", le128ToBigInt(sl.MediaErrors))
 	fmt.Printf("Error information log entries: %d\n", le128ToBigInt(sl.NumErrLogEntries))
+	fmt.Printf("Warning temperature time: %s\n", sl.WarningTemperatureTime())
+	fmt.Printf("Critical temperature time: %s\n", sl.CriticalTemperatureTime())
+	fmt.Printf("Thermal management T1 transitions: %d, total time: %s\n",
+		sl.ThermalThrottle1Count(), sl.ThermalThrottle1Time())
+	fmt.Printf("Thermal management T2 transitions: %d, total time: %s\n",
+		sl.ThermalThrottle2Count(), sl.ThermalThrottle2Time())
 
 	return nil
 }
 
+// NamespaceReadOnly reports whether the specified namespace is read only, either because it is
+// write protected, or because the controller has placed the media in read only mode.
+func (d *NVMeDevice) NamespaceReadOnly(nsid uint32) (bool, error) {
+	ns, err := d.IdentifyNamespace(nsid)
+	if err != nil {
+		return false, err
+	}
+
+	if ns.ReadOnly() {
+		return true, nil
+	}
+
+	sl, err := d.ReadSMART()
+	if err != nil {
+		return false, err
+	}
+
+	return sl.ReadOnly(), nil
+}
+
+// ReadSMART reads the SMART / Health Information log page for the controller.
+func (d *NVMeDevice) ReadSMART() (*NVMeSMARTData, error) {
+	var sl NVMeSMARTData
+
+	bufp := getBuffer(512)
+	defer putBuffer(bufp)
+	buf := *bufp
+
+	if err := d.readLogPage(NVME_LOG_SMART, &buf); err != nil {
+		return nil, err
+	}
+
+	if err := utils.DecodeInto(buf, &sl); err != nil {
+		return nil, err
+	}
+
+	return &sl, nil
+}
+
+// Temperature returns the composite temperature reported in the SMART / Health Information log.
+// Only the first dword of the log page, which holds the composite temperature, is transferred and
+// decoded, which makes this considerably cheaper than ReadSMART for thermal monitoring loops.
+func (d *NVMeDevice) Temperature() (utils.Temperature, error) {
+	bufp := getBuffer(512)
+	defer putBuffer(bufp)
+	buf := *bufp
+
+	if err := d.getLogPage(NVME_LOG_SMART, 0, 0, 0, buf[:4]); err != nil {
+		return 0, err
+	}
+
+	return utils.Temperature(utils.NativeEndian.Uint16(buf[1:])), nil
+}
+
+// IdentifyController sends an IDENTIFY command to the controller and returns the decoded identify
+// controller data structure.
+func (d *NVMeDevice) IdentifyController() (*NVMeController, error) {
+	bufp := getBuffer(4096)
+	defer putBuffer(bufp)
+	buf := *bufp
+
+	// Namespace 0, since we are identifying the controller
+	if err := d.identify(NVME_IDENTIFY_CNS_CONTROLLER, 0, 0, buf); err != nil {
+		return nil, err
+	}
+
+	return ParseNVMeIdentController(buf)
+}
+
+// Length of the identify controller data structure up to the end of the admin command set
+// attributes, which covers the identity and capability fields defined by NVMe 1.0
+const nvmeIdentControllerMinLen = 512
+
+// ParseNVMeIdentController decodes an identify controller data structure, e.g. from a captured
+// dump or a vendor tool. Buffers shorter than the full 4096 bytes are accepted as long as they
+// include the admin command set attributes, with the missing trailing bytes treated as zero.
+// Longer buffers, as produced by some vendor tools which append vendor-specific data, are accepted
+// too; only the 4096 bytes defined by the spec are decoded, and the remainder is ignored.
+func ParseNVMeIdentController(buf []byte) (*NVMeController, error) {
+	var controller NVMeController
+
+	size := int(unsafe.Sizeof(controller))
+
+	if len(buf) < nvmeIdentControllerMinLen {
+		return nil, fmt.Errorf("invalid identify controller size %d, expected at least %d bytes",
+			len(buf), nvmeIdentControllerMinLen)
+	}
+
+	if len(buf) < size {
+		padded := make([]byte, size)
+		copy(padded, buf)
+		buf = padded
+	}
+
+	if err := utils.DecodeInto(buf[:size], &controller); err != nil {
+		return nil, err
+	}
+
+	return &controller, nil
+}
+
+// RawIdentifyController sends an IDENTIFY command to the controller and returns the undecoded
+// 4096-byte identify controller data structure, e.g. for inspection with utils.HexDump when a
+// field decodes unexpectedly.
+func (d *NVMeDevice) RawIdentifyController() ([]byte, error) {
+	return d.Identify(NVME_IDENTIFY_CNS_CONTROLLER, 0, 0)
+}
+
+// IdentifyNamespace sends an IDENTIFY command for the specified namespace ID and returns the
+// decoded identify namespace data structure.
+func (d *NVMeDevice) IdentifyNamespace(nsid uint32) (*NVMeNamespace, error) {
+	bufp := getBuffer(4096)
+	defer putBuffer(bufp)
+	buf := *bufp
+
+	if err := d.identify(NVME_IDENTIFY_CNS_NAMESPACE, nsid, 0, buf); err != nil {
+		return nil, err
+	}
+
+	return ParseNVMeIdentNamespace(buf)
+}
+
+// Length of the identify namespace data structure up to the end of the LBA format descriptors,
+// which covers all fields defined by NVMe 1.0
+const nvmeIdentNamespaceMinLen = 192
+
+// ParseNVMeIdentNamespace decodes an identify namespace data structure, e.g. from a captured dump.
+// Buffers shorter than the full 4096 bytes are accepted as long as they include the LBA format
+// descriptors; the missing trailing bytes are treated as zero, i.e. as fields not reported by the
+// controller. Fields added by later spec revisions decode as zero for controllers compliant with
+// earlier revisions, since those bytes were reserved.
+func ParseNVMeIdentNamespace(buf []byte) (*NVMeNamespace, error) {
+	var ns NVMeNamespace
+
+	size := int(unsafe.Sizeof(ns))
+
+	if len(buf) < nvmeIdentNamespaceMinLen || len(buf) > size {
+		return nil, fmt.Errorf("invalid identify namespace size %d, expected %d to %d bytes",
+			len(buf), nvmeIdentNamespaceMinLen, size)
+	}
+
+	if len(buf) < size {
+		padded := make([]byte, size)
+		copy(padded, buf)
+		buf = padded
+	}
+
+	if err := utils.DecodeInto(buf, &ns); err != nil {
+		return nil, err
+	}
+
+	// Up to 64 LBA formats are allowed (NVMe 2.0)
+	if idx := ns.LBAFormatIndex(); ns.Nlbaf >= uint8(len(ns.Lbaf)) || idx > int(ns.Nlbaf) {
+		return nil, fmt.Errorf("invalid LBA format %d of %d in identify namespace", idx, int(ns.Nlbaf)+1)
+	}
+
+	return &ns, nil
+}
+
+// Identify sends an IDENTIFY admin command with the specified CNS value, namespace ID and
+// controller ID, and returns the undecoded 4096-byte data structure. Which of nsid and cntid are
+// used depends on the CNS value; unused identifiers should be zero. The controller ID is sent in
+// bits 31:16 of CDW10, and selects the controller for CNS values which take one, e.g. the secondary
+// controller and controller list data structures. The identify controller data structure (CNS 01h)
+// always describes the controller processing the command; see IdentifyControllerID to identify
+// other controllers of a multi-controller subsystem. The typed wrappers, e.g. IdentifyController,
+// should be preferred where available.
+func (d *NVMeDevice) Identify(cns uint8, nsid uint32, cntid uint16) ([]byte, error) {
+	bufp := getBuffer(4096)
+	defer putBuffer(bufp)
+	buf := *bufp
+
+	if err := d.identify(cns, nsid, cntid, buf); err != nil {
+		return nil, err
+	}
+
+	// Copy out of the pooled buffer, since the caller retains the slice
+	raw := make([]byte, len(buf))
+	copy(raw, buf)
+
+	return raw, nil
+}
+
+// identify is the primitive underlying Identify and its typed wrappers, which writes the returned
+// data structure into buf.
+func (d *NVMeDevice) identify(cns uint8, nsid uint32, cntid uint16, buf []byte) error {
+	cmd := nvmePassthruCommand{
+		opcode:   NVME_ADMIN_IDENTIFY,
+		nsid:     nsid,
+		addr:     uint64(uintptr(unsafe.Pointer(&buf[0]))),
+		data_len: uint32(len(buf)),
+		cdw10:    uint32(cns) | uint32(cntid)<<16,
+	}
+
+	_, err := d.adminCommand(&cmd)
+
+	// The kernel only sees buf via the address stored in cmd, so keep it alive until the ioctl
+	// has returned
+	runtime.KeepAlive(buf)
+
+	return err
+}
+
 func (d *NVMeDevice) readLogPage(logID uint8, buf *[]byte) error {
-	bufLen := len(*buf)
+	if len(*buf) > 0x4000 {
+		return fmt.Errorf("Invalid buffer size")
+	}
 
-	if (bufLen < 4) || (bufLen > 0x4000) || (bufLen%4 != 0) {
+	return d.getLogPage(logID, 0, 0, 0, *buf)
+}
+
+// getLogPage reads len(buf) bytes of the specified log page, starting at the specified byte
+// offset. The log specific field (LSP) is passed in CDW10 bits 11:8, and the log specific
+// identifier (LSI), e.g. an endurance group or NVM set identifier, in CDW11 bits 31:16.
+// ErrLogNotSupported is returned if the controller does not support the log page. Some controllers
+// reject unsupported optional log pages with Invalid Field in Command rather than Invalid Log
+// Page, which is therefore also reported as ErrLogNotSupported for anything beyond the mandatory
+// Error Information, SMART / Health Information and Firmware Slot Information log pages.
+func (d *NVMeDevice) getLogPage(logID uint8, lsp uint8, lsi uint16, offset uint64, buf []byte) error {
+	bufLen := len(buf)
+
+	if (bufLen < 4) || (bufLen%4 != 0) {
 		return fmt.Errorf("Invalid buffer size")
 	}
 
+	// Number of dwords (zero-based) is split into lower (NUMDL) and upper (NUMDU) words
+	numd := uint32(bufLen)/4 - 1
+
 	cmd := nvmePassthruCommand{
 		opcode:   NVME_ADMIN_GET_LOG_PAGE,
 		nsid:     0xffffffff, // FIXME
-		addr:     uint64(uintptr(unsafe.Pointer(&(*buf)[0]))),
+		addr:     uint64(uintptr(unsafe.Pointer(&buf[0]))),
 		data_len: uint32(bufLen),
-		cdw10:    uint32(logID) | (((uint32(bufLen) / 4) - 1) << 16),
+		cdw10:    uint32(logID) | uint32(lsp&0x0f)<<8 | (numd&0xffff)<<16,
+		cdw11:    numd>>16 | uint32(lsi)<<16,
+		cdw12:    uint32(offset),
+		cdw13:    uint32(offset >> 32),
+	}
+
+	_, err := d.adminCommand(&cmd)
+	runtime.KeepAlive(buf)
+
+	if IsStatus(err, NVME_SCT_COMMAND_SPECIFIC, NVME_SC_INVALID_LOG_PAGE) ||
+		(logID > NVME_LOG_FW_SLOT && IsStatus(err, NVME_SCT_GENERIC, NVME_SC_INVALID_FIELD)) {
+		return ErrLogNotSupported
+	}
+
+	return err
+}
+
+// adminCommand submits an admin command via the admin passthru ioctl and returns Dword 0 of the
+// completion queue entry, which holds the command-specific result (e.g. a feature value). If the
+// controller completed the command with an error, the kernel returns its status as the result of
+// the ioctl, which is returned as an NVMeStatus error.
+func (d *NVMeDevice) adminCommand(cmd *nvmePassthruCommand) (uint32, error) {
+	result, err := d.adminCommand64(cmd)
+	return uint32(result), err
+}
+
+// adminCommand64 is like adminCommand, but returns the 64-bit result of commands which return
+// Dwords 0 and 1 of the completion queue entry. The NVME_IOCTL_ADMIN64_CMD ioctl is used if the
+// kernel supports it, which is probed with the first command; older kernels only return Dword 0,
+// via the legacy NVME_IOCTL_ADMIN_CMD ioctl.
+func (d *NVMeDevice) adminCommand64(cmd *nvmePassthruCommand) (uint64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var (
+		status uintptr
+		result uint64
+		err    error
+	)
+
+	if d.admin64 != admin64Unsupported {
+		buf := cmd.pack64()
+
+		status, err = ioctl.IoctlResult(uintptr(d.fd), NVME_IOCTL_ADMIN64_CMD, uintptr(unsafe.Pointer(&buf[0])))
+		result = utils.NativeEndian.Uint64(buf[nvmePassthruCommand64Size-8:])
+
+		// Kernels without support for the ioctl reject it as unknown
+		if err == syscall.ENOTTY && d.admin64 == admin64Unknown {
+			d.admin64 = admin64Unsupported
+		} else if err == nil {
+			d.admin64 = admin64Supported
+		}
+	}
+
+	if d.admin64 == admin64Unsupported {
+		buf := cmd.pack()
+
+		status, err = ioctl.IoctlResult(uintptr(d.fd), NVME_IOCTL_ADMIN_CMD, uintptr(unsafe.Pointer(&buf[0])))
+		cmd.unpackResult(buf)
+		result = uint64(cmd.result)
+	}
+
+	cmd.result = uint32(result)
+
+	if err != nil {
+		return result, err
+	}
+
+	if status != 0 {
+		return result, NVMeStatus(status)
+	}
+
+	return result, nil
+}
+
+// le128Uint64 converts a little-endian 128-bit counter to a uint64, saturating at the maximum
+// uint64 value rather than truncating.
+func le128Uint64(buf [16]byte) uint64 {
+	if utils.NativeEndian.Uint64(buf[8:]) != 0 {
+		return math.MaxUint64
+	}
+
+	return utils.NativeEndian.Uint64(buf[:8])
+}
+
+// le128Duration converts a little-endian 128-bit count of the specified unit to a time.Duration,
+// saturating at the maximum duration (approx. 292 years) rather than overflowing.
+func le128Duration(buf [16]byte, unit time.Duration) time.Duration {
+	n := le128ToBigInt(buf)
+	if max := big.NewInt(int64(math.MaxInt64 / unit)); n.Cmp(max) > 0 {
+		return math.MaxInt64
 	}
 
-	return ioctl.Ioctl(uintptr(d.fd), NVME_IOCTL_ADMIN_CMD, uintptr(unsafe.Pointer(&cmd)))
+	return time.Duration(n.Int64()) * unit
 }
 
 // le128ToBigInt takes a little-endian 16-byte slice and returns a *big.Int representing it.
@@ -0,0 +1,56 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+//go:build linux
+
+package nvme
+
+import (
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+// Open opens the device node. The device must be closed with Close when no longer needed. As a
+// safety net against leaking file descriptors, a device which becomes unreachable while still open
+// is closed by a finalizer, but when that happens is up to the garbage collector.
+func (d *NVMeDevice) Open() (err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	flags := unix.O_RDWR
+	if d.ReadOnly {
+		flags = unix.O_RDONLY
+	}
+
+	if d.NonBlock {
+		flags |= unix.O_NONBLOCK
+	}
+
+	if d.fd, err = unix.Open(d.Name, flags, 0600); err != nil {
+		d.fd = -1
+		return err
+	}
+
+	// Replace rather than add, since setting a second finalizer panics
+	runtime.SetFinalizer(d, nil)
+	runtime.SetFinalizer(d, func(d *NVMeDevice) { d.Close() })
+
+	return nil
+}
+
+// Close closes the device node. Closing a device which is not open has no effect.
+func (d *NVMeDevice) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.fd < 0 {
+		return nil
+	}
+
+	err := unix.Close(d.fd)
+	d.fd = -1
+	runtime.SetFinalizer(d, nil)
+
+	return err
+}
@@ -4,10 +4,19 @@
 package nvme
 
 import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 	"unsafe"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/madper/smart/utils"
 )
 
 func TestNVMe(t *testing.T) {
@@ -15,9 +24,888 @@ func TestNVMe(t *testing.T) {
 
 	// Test that various structs are the size they should be
 	assert.Equal(uintptr(72), unsafe.Sizeof(nvmePassthruCommand{}))
-	assert.Equal(uintptr(4096), unsafe.Sizeof(nvmeIdentController{}))
-	assert.Equal(uintptr(4096), unsafe.Sizeof(nvmeIdentNamespace{}))
-	assert.Equal(uintptr(512), unsafe.Sizeof(nvmeSMARTLog{}))
+	assert.Equal(uintptr(4096), unsafe.Sizeof(NVMeController{}))
+	assert.Equal(uintptr(4096), unsafe.Sizeof(NVMeNamespace{}))
+	assert.Equal(uintptr(512), unsafe.Sizeof(NVMeSMARTData{}))
+	assert.Equal(uintptr(32), unsafe.Sizeof(NVMePowerState{}))
+	assert.Equal(uintptr(512), unsafe.Sizeof(NVMeEnduranceGroupLog{}))
+	assert.Equal(uintptr(512), unsafe.Sizeof(NVMePredictableLatencyLog{}))
+	assert.Equal(512, binary.Size(nvmePELHeader{}))
+	assert.Equal(24, binary.Size(nvmePELEventHeader{}))
+	assert.Equal(28, binary.Size(SelfTestResult{}))
+	assert.Equal(64, binary.Size(NVMeErrorLogEntry{}))
+	assert.Equal(uintptr(512), unsafe.Sizeof(NVMeFirmwareSlotLog{}))
+
+	// Field offsets within the identify controller data structure
+	assert.Equal(uintptr(111), unsafe.Offsetof(NVMeController{}.Cntrltype))
+	assert.Equal(uintptr(112), unsafe.Offsetof(NVMeController{}.Fguid))
+	assert.Equal(uintptr(256), unsafe.Offsetof(NVMeController{}.Oacs))
+	assert.Equal(uintptr(272), unsafe.Offsetof(NVMeController{}.Hmpre))
+	assert.Equal(uintptr(328), unsafe.Offsetof(NVMeController{}.Sanicap))
+	assert.Equal(uintptr(352), unsafe.Offsetof(NVMeController{}.Pels))
+	assert.Equal(uintptr(536), unsafe.Offsetof(NVMeController{}.Sgls))
+	assert.Equal(uintptr(768), unsafe.Offsetof(NVMeController{}.Subnqn))
 
 	// More tests to follow...
 }
+
+func TestPassthruCommandPack(t *testing.T) {
+	assert := assert.New(t)
+
+	cmd := nvmePassthruCommand{
+		opcode:     NVME_ADMIN_IDENTIFY,
+		nsid:       0x11223344,
+		addr:       0x0102030405060708,
+		data_len:   4096,
+		cdw10:      NVME_IDENTIFY_CNS_CONTROLLER,
+		cdw15:      0xaabbccdd,
+		timeout_ms: 1000,
+	}
+
+	// Field offsets of struct nvme_passthru_cmd in <linux/nvme_ioctl.h>
+	b := cmd.pack()
+	assert.Len(b, 72)
+	assert.Equal(byte(NVME_ADMIN_IDENTIFY), b[0])
+	assert.Equal(uint32(0x11223344), utils.NativeEndian.Uint32(b[4:]))
+	assert.Equal(uint64(0x0102030405060708), utils.NativeEndian.Uint64(b[24:]))
+	assert.Equal(uint32(4096), utils.NativeEndian.Uint32(b[36:]))
+	assert.Equal(uint32(NVME_IDENTIFY_CNS_CONTROLLER), utils.NativeEndian.Uint32(b[40:]))
+	assert.Equal(uint32(0xaabbccdd), utils.NativeEndian.Uint32(b[60:]))
+	assert.Equal(uint32(1000), utils.NativeEndian.Uint32(b[64:]))
+
+	// Result as set by the kernel
+	utils.NativeEndian.PutUint32(b[68:], 0xdeadbeef)
+	cmd.unpackResult(b)
+	assert.Equal(uint32(0xdeadbeef), cmd.result)
+
+	// struct nvme_passthru_cmd64 has a reserved field at offset 68, and the result at offset 72
+	b = cmd.pack64()
+	assert.Len(b, 80)
+	assert.Equal(uint32(1000), utils.NativeEndian.Uint32(b[64:]))
+	assert.Zero(utils.NativeEndian.Uint32(b[68:]))
+	assert.Zero(utils.NativeEndian.Uint64(b[72:]))
+}
+
+func TestNVMeVersion(t *testing.T) {
+	assert := assert.New(t)
+
+	c := NVMeController{Ver: 0x00010400}
+	major, minor, tertiary := c.Version()
+	assert.Equal([]int{1, 4, 0}, []int{major, minor, tertiary})
+	assert.Equal("1.4.0", c.Ver.String())
+	assert.Equal("1.3.1", NVMeVersion(0x00010301).String())
+	assert.Equal("unknown", NVMeVersion(0).String())
+
+	copy(c.Subnqn[:], "nqn.2014.08.org.nvmexpress:uuid:f81d4fae-7dec-11d0-a765-00a0c91e6bf6")
+	assert.Equal("nqn.2014.08.org.nvmexpress:uuid:f81d4fae-7dec-11d0-a765-00a0c91e6bf6", c.SubsystemNQN())
+
+	assert.False(c.StandardAdminVendorFormat())
+	c.Avscc, c.Nvscc = 0x01, 0x01
+	assert.True(c.StandardAdminVendorFormat())
+	assert.True(c.StandardNVMVendorFormat())
+
+	assert.Equal("", c.FRUGUID())
+	c.Fguid[0], c.Fguid[15] = 0x12, 0x34
+	assert.Equal("12000000000000000000000000000034", c.FRUGUID())
+	c.Cntrltype = NVME_CTRL_TYPE_DISCOVERY
+	assert.Equal("discovery controller", c.ControllerType().String())
+}
+
+func TestLBAFormatString(t *testing.T) {
+	assert := assert.New(t)
+
+	ns := NVMeNamespace{Flbas: 0x01}
+	ns.Lbaf[0] = nvmeLBAF{Ds: 9, Rp: 2}
+	ns.Lbaf[1] = nvmeLBAF{Ms: 8, Ds: 9}
+	assert.Equal("512B + 8B metadata (best perf)", ns.LBAFormatString())
+
+	ns.Flbas = 0x10 // metadata transferred at end of data LBA
+	assert.Equal("512B (good perf)", ns.LBAFormatString())
+
+	ns.Flbas = 0x02
+	assert.Equal("unsupported", ns.LBAFormatString())
+}
+
+func TestSupportedLBAFormats(t *testing.T) {
+	assert := assert.New(t)
+
+	ns := NVMeNamespace{Nlbaf: 3, Flbas: 0x01}
+	ns.Lbaf[0] = nvmeLBAF{Ds: 9, Rp: 2}
+	ns.Lbaf[1] = nvmeLBAF{Ms: 8, Ds: 9, Rp: 1}
+	ns.Lbaf[2] = nvmeLBAF{Ds: 12}
+	ns.Lbaf[4] = nvmeLBAF{Ds: 12} // Beyond NLBAF
+
+	formats := ns.SupportedLBAFormats()
+	assert.Equal([]LBAFormat{
+		{Index: 2, DataSize: 4096, Performance: 0},
+		{Index: 1, DataSize: 512, MetadataSize: 8, Performance: 1, InUse: true},
+		{Index: 0, DataSize: 512, Performance: 2},
+	}, formats)
+	assert.Equal("1: 512B + 8B metadata (better perf)", formats[1].String())
+
+	// Formats beyond the first 16 (NVMe 2.0)
+	ns = NVMeNamespace{Nlbaf: 20, Flbas: 0x24}
+	ns.Lbaf[0] = nvmeLBAF{Ds: 9, Rp: 2}
+	ns.Lbaf[20] = nvmeLBAF{Ds: 12}
+	assert.Equal([]LBAFormat{
+		{Index: 20, DataSize: 4096, Performance: 0, InUse: true},
+		{Index: 0, DataSize: 512, Performance: 2},
+	}, ns.SupportedLBAFormats())
+}
+
+// First 192 bytes of an identify namespace data structure captured from an NVMe 1.0 controller,
+// whose response ends with the LBA format descriptors
+var nvmeIdentNamespace10 = []byte{
+	0xb0, 0x6d, 0x38, 0x3a, 0x00, 0x00, 0x00, 0x00, 0xb0, 0x6d, 0x38, 0x3a, 0x00, 0x00, 0x00, 0x00,
+	0x30, 0x12, 0x04, 0x1a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x25, 0x38, 0x8b, 0x71, 0xb0, 0xe7, 0xd1,
+	0x00, 0x00, 0x09, 0x00, 0x00, 0x00, 0x0c, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+}
+
+func TestParseNVMeIdentController(t *testing.T) {
+	assert := assert.New(t)
+
+	buf := make([]byte, 8192)
+	copy(buf[24:], "Samsung SSD 960 PRO 512GB")
+	utils.NativeEndian.PutUint32(buf[80:], 0x00010200)
+	copy(buf[4096:], "vendor-specific extension")
+
+	// Oversized buffer, of which only the first 4096 bytes are decoded
+	c, err := ParseNVMeIdentController(buf)
+	assert.NoError(err)
+	assert.Equal("1.2.0", c.Ver.String())
+	assert.Zero(c.Psd[31].MaxPower)
+
+	c, err = ParseNVMeIdentController(buf[:512])
+	assert.NoError(err)
+	assert.Equal(byte('S'), c.ModelNumber[0])
+
+	_, err = ParseNVMeIdentController(buf[:256])
+	assert.Error(err)
+}
+
+func TestParseNVMeIdentNamespace(t *testing.T) {
+	assert := assert.New(t)
+
+	ns, err := ParseNVMeIdentNamespace(nvmeIdentNamespace10)
+	assert.NoError(err)
+	assert.Equal(uint64(976776624), ns.Nsze)
+	assert.Equal(uint64(436474416), ns.Nuse)
+	assert.Equal(512, ns.LBASize())
+	assert.Equal("eui.0025388b71b0e7d1", ns.WWN())
+	assert.Zero(ns.Endgid)
+
+	// Full 4096-byte structure
+	ns, err = ParseNVMeIdentNamespace(append(nvmeIdentNamespace10, make([]byte, 4096-192)...))
+	assert.NoError(err)
+	assert.Equal("512B (best perf)", ns.LBAFormatString())
+
+	_, err = ParseNVMeIdentNamespace(nvmeIdentNamespace10[:128])
+	assert.Error(err)
+	_, err = ParseNVMeIdentNamespace(make([]byte, 8192))
+	assert.Error(err)
+
+	// Formatted LBA size beyond the number of LBA formats
+	bad := append([]byte(nil), nvmeIdentNamespace10...)
+	bad[26] = 0x03
+	_, err = ParseNVMeIdentNamespace(bad)
+	assert.Error(err)
+
+	// NVMe 2.0 namespace with 64 LBA formats, formatted with one of the first 16
+	ns2 := make([]byte, 4096)
+	copy(ns2, nvmeIdentNamespace10)
+	ns2[25] = 63 // NLBAF (0's based)
+	ns2[26] = 0x10
+	ns, err = ParseNVMeIdentNamespace(ns2)
+	assert.NoError(err)
+	assert.Equal(0, ns.LBAFormatIndex())
+	assert.Equal(512, ns.LBASize())
+
+	// FLBAS bits 6:5 hold the upper bits of the format index, here selecting format 20 (4 KiB +
+	// 8B metadata, at bytes 208-211)
+	ns2[26] = 0x24
+	utils.NativeEndian.PutUint16(ns2[208:], 8)
+	ns2[210] = 12
+	ns, err = ParseNVMeIdentNamespace(ns2)
+	assert.NoError(err)
+	assert.Equal(20, ns.LBAFormatIndex())
+	assert.Equal(4096, ns.LBASize())
+	assert.Equal(8, ns.MetadataSize())
+
+	// The format index must not exceed the number of formats
+	ns2[25] = 19
+	_, err = ParseNVMeIdentNamespace(ns2)
+	assert.Error(err)
+}
+
+func TestExtendedLBA(t *testing.T) {
+	assert := assert.New(t)
+
+	ns := NVMeNamespace{Flbas: 0x11}
+	ns.Lbaf[1] = nvmeLBAF{Ms: 8, Ds: 9}
+	assert.Equal(1, ns.LBAFormatIndex())
+	assert.True(ns.ExtendedLBA())
+	assert.Equal(512, ns.LBASize())
+	assert.Equal(8, ns.MetadataSize())
+
+	ns.Flbas = 0x01
+	assert.False(ns.ExtendedLBA())
+}
+
+func TestProtectionInfo(t *testing.T) {
+	assert := assert.New(t)
+
+	ns := NVMeNamespace{Dps: 0x09, Dpc: 0x1d}
+	enabled, piType, mdStart := ns.ProtectionInfo()
+	assert.True(enabled)
+	assert.Equal(1, piType)
+	assert.True(mdStart)
+	assert.True(ns.ProtectionInfoSupported(1))
+	assert.False(ns.ProtectionInfoSupported(2))
+	assert.True(ns.ProtectionInfoSupported(3))
+	assert.False(ns.ProtectionInfoSupported(4))
+
+	ns.Dps = 0
+	enabled, _, _ = ns.ProtectionInfo()
+	assert.False(enabled)
+}
+
+func TestReservationCapabilities(t *testing.T) {
+	assert := assert.New(t)
+
+	ns := NVMeNamespace{}
+	assert.False(ns.Shared())
+	assert.False(ns.ReservationCapabilities().Supported())
+
+	ns = NVMeNamespace{Nmic: 0x01, Rescap: 0x07}
+	rc := ns.ReservationCapabilities()
+	assert.True(ns.Shared())
+	assert.True(rc.Supported())
+	assert.True(rc.PersistThroughPowerLoss)
+	assert.True(rc.ExclusiveAccess)
+	assert.False(rc.WriteExclusiveAllReg)
+}
+
+func TestAtomicWriteUnit(t *testing.T) {
+	assert := assert.New(t)
+
+	c := NVMeController{Awun: 0xff, Awupf: 0, Acwu: 0}
+	assert.Equal(256, c.AtomicWriteUnitNormal())
+	assert.Equal(1, c.AtomicWriteUnitPowerFail())
+	assert.Equal(0, c.AtomicCompareWriteUnit())
+
+	// Controller values apply unless the namespace defines its own
+	ns := NVMeNamespace{Nawun: 7, Nawupf: 7}
+	assert.Equal(256, ns.AtomicWriteUnitNormal(&c))
+	assert.Equal(1, ns.AtomicWriteUnitPowerFail(&c))
+
+	ns.Nsfeat = 0x02
+	assert.Equal(8, ns.AtomicWriteUnitNormal(&c))
+	assert.Equal(8, ns.AtomicWriteUnitPowerFail(&c))
+}
+
+func TestOptimalWriteAlignment(t *testing.T) {
+	assert := assert.New(t)
+
+	ns := NVMeNamespace{Nawupf: 7, Npwa: 15}
+	ns.Lbaf[0] = nvmeLBAF{Ds: 12}
+	assert.Equal(4096, ns.LBASize())
+	assert.Equal(4096, ns.OptimalWriteAlignment())
+
+	ns.Nsfeat = 0x02
+	assert.Equal(8*4096, ns.OptimalWriteAlignment())
+
+	ns.Nsfeat = 0x12
+	assert.Equal(16*4096, ns.OptimalWriteAlignment())
+}
+
+func TestPowerStates(t *testing.T) {
+	assert := assert.New(t)
+
+	c := NVMeController{Npss: 2}
+	c.Psd[0] = NVMePowerState{MaxPower: 610}
+	c.Psd[2] = NVMePowerState{MaxPower: 40, Flags: 0x03}
+
+	ps := c.PowerStates()
+	assert.Len(ps, 3)
+	assert.InDelta(6.1, ps[0].MaxPowerWatts(), 0.0001)
+	assert.False(ps[0].NonOperational())
+	assert.InDelta(0.004, ps[2].MaxPowerWatts(), 0.0001)
+	assert.True(ps[2].NonOperational())
+
+	// Invalid NPSS, e.g. from an unvalidated dump
+	c.Npss = 255
+	c.Psd[31] = NVMePowerState{MaxPower: 250}
+	ps = c.PowerStates()
+	assert.Len(ps, 32)
+	assert.Equal(uint16(250), ps[31].MaxPower)
+}
+
+func TestCommandLimits(t *testing.T) {
+	assert := assert.New(t)
+
+	c := NVMeController{Acl: 3, Aerl: 3}
+	assert.Equal(4, c.AbortCommandLimit())
+	assert.Equal(4, c.AsyncEventRequestLimit())
+}
+
+func TestAsyncEvents(t *testing.T) {
+	assert := assert.New(t)
+
+	c := NVMeController{Oaes: 0x00000300}
+	ev := c.AsyncEvents()
+	assert.True(ev.NamespaceAttribute)
+	assert.True(ev.FirmwareActivation)
+	assert.False(ev.ANAChange)
+	assert.False(ev.DiscoveryLogChange)
+}
+
+func TestSGLSupport(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.False(NVMeSGLSupport(0).Supported())
+	assert.Equal("not supported", NVMeSGLSupport(0).String())
+
+	s := NVMeSGLSupport(0x00090002)
+	assert.True(s.Supported())
+	assert.True(s.DwordAligned())
+	assert.True(s.BitBucket())
+	assert.True(s.MetadataSGL())
+	assert.False(s.KeyedDataBlock())
+	assert.Equal("supported (dword aligned)", s.String())
+}
+
+func TestRPMBSupport(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.False(NVMeRPMBSupport(0).Supported())
+	assert.Equal(uint64(0), NVMeRPMBSupport(0).TotalSize())
+	assert.Equal("not supported", NVMeRPMBSupport(0).String())
+
+	// One unit of 512 KiB, HMAC SHA-256, 4 KiB access size
+	r := NVMeRPMBSupport(0x07030001)
+	assert.Equal(1, r.Units())
+	assert.Equal(uint8(NVME_RPMB_AUTH_HMAC_SHA256), r.AuthMethod())
+	assert.Equal(uint64(512*1024), r.TotalSize())
+	assert.Equal(uint64(4096), r.AccessSize())
+}
+
+func TestDiffSMART(t *testing.T) {
+	assert := assert.New(t)
+
+	var old, new NVMeSMARTData
+
+	old.Temperature = [2]uint8{0x3a, 0x01} // 314 K
+	new.Temperature = [2]uint8{0x38, 0x01} // 312 K
+	old.PercentUsed, new.PercentUsed = 3, 4
+	new.DataUnitsWritten[0] = 10
+	new.MediaErrors[0] = 2
+
+	// Wrapped 64-bit counter
+	utils.NativeEndian.PutUint64(old.HostWrites[:], 0xfffffffffffffffe)
+	new.HostWrites[0] = 3
+
+	d := DiffSMART(&old, &new)
+	assert.Equal(uint64(5120000), d.BytesWritten)
+	assert.Equal(uint64(2), d.MediaErrors)
+	assert.Equal(uint64(5), d.HostWrites)
+	assert.InDelta(-2, d.TemperatureChange, 0.001)
+	assert.Equal(1, d.PercentUsedIncrease)
+}
+
+func TestNVMeStatus(t *testing.T) {
+	assert := assert.New(t)
+
+	// Invalid Log Page, Do Not Retry
+	s := NVMeStatus(0x4109)
+	assert.Equal(uint8(1), s.SCT())
+	assert.Equal(uint8(0x09), s.SC())
+	assert.True(s.DNR())
+	assert.Equal(uint16(0x109), s.Code())
+	assert.True(s.Matches(NVME_SCT_COMMAND_SPECIFIC, NVME_SC_INVALID_LOG_PAGE))
+	assert.Equal("NVMe status: Invalid Log Page (SCT 0x1, SC 0x09)", s.Error())
+
+	assert.True(IsStatus(NVMeStatus(0x0082), NVME_SCT_GENERIC, NVME_SC_NS_NOT_READY))
+	assert.False(IsStatus(NVMeStatus(0x0182), NVME_SCT_GENERIC, NVME_SC_NS_NOT_READY))
+
+	// Vendor specific statuses are not described
+	assert.Equal("NVMe status: SCT 0x7, SC 0x01", NVMeStatus(0x0701).Error())
+}
+
+func TestSMARTDataBytes(t *testing.T) {
+	assert := assert.New(t)
+
+	sl := NVMeSMARTData{
+		DataUnitsRead:    [16]byte{0x02},
+		DataUnitsWritten: [16]byte{0x00, 0x01},
+	}
+	assert.Equal(int64(1024000), sl.BytesRead().Int64())
+	assert.Equal(int64(256*512000), sl.BytesWritten().Int64())
+}
+
+func TestHostCommands(t *testing.T) {
+	assert := assert.New(t)
+
+	sl := NVMeSMARTData{
+		HostReads:  [16]byte{0x10, 0x27},
+		HostWrites: [16]byte{0x01},
+	}
+	assert.Equal(uint64(10000), sl.HostReadCommands())
+	assert.Equal(uint64(1), sl.HostWriteCommands())
+
+	// Counters beyond 64 bits saturate
+	sl.HostWrites[8] = 1
+	assert.Equal(uint64(math.MaxUint64), sl.HostWriteCommands())
+}
+
+func TestPercentageUsed(t *testing.T) {
+	assert := assert.New(t)
+
+	sl := NVMeSMARTData{PercentUsed: 100}
+	assert.Equal(100, sl.PercentageUsed())
+	assert.False(sl.BeyondRatedEndurance())
+
+	sl.PercentUsed = 254
+	assert.Equal(254, sl.PercentageUsed())
+	assert.True(sl.BeyondRatedEndurance())
+}
+
+func TestTemperatureTime(t *testing.T) {
+	assert := assert.New(t)
+
+	sl := NVMeSMARTData{WarningTempTime: 90, CritCompTime: 2}
+	assert.Equal(90*time.Minute, sl.WarningTemperatureTime())
+	assert.Equal(2*time.Minute, sl.CriticalTemperatureTime())
+}
+
+func TestThermalThrottle(t *testing.T) {
+	assert := assert.New(t)
+
+	var sl NVMeSMARTData
+
+	// Thermal management fields follow the temperature sensors at offset 216
+	buf := make([]byte, 512)
+	utils.NativeEndian.PutUint32(buf[216:], 3)
+	utils.NativeEndian.PutUint32(buf[220:], 1)
+	utils.NativeEndian.PutUint32(buf[224:], 600)
+	utils.NativeEndian.PutUint32(buf[228:], 45)
+	assert.NoError(utils.DecodeInto(buf, &sl))
+	assert.Equal(uint32(3), sl.ThermalThrottle1Count())
+	assert.Equal(uint32(1), sl.ThermalThrottle2Count())
+	assert.Equal(10*time.Minute, sl.ThermalThrottle1Time())
+	assert.Equal(45*time.Second, sl.ThermalThrottle2Time())
+}
+
+func TestSMARTDurations(t *testing.T) {
+	assert := assert.New(t)
+
+	sl := NVMeSMARTData{PowerOnHours: [16]byte{0x10, 0x27}, CtrlBusyTime: [16]byte{90}}
+	assert.Equal(10000*time.Hour, sl.PowerOnDuration())
+	assert.Equal(90*time.Minute, sl.ControllerBusyDuration())
+
+	sl.PowerOnHours[15] = 1
+	assert.Equal(time.Duration(math.MaxInt64), sl.PowerOnDuration())
+}
+
+func TestCompositeTemperatureMismatch(t *testing.T) {
+	assert := assert.New(t)
+
+	// No sensors implemented
+	sl := NVMeSMARTData{Temperature: [2]uint8{0x38, 0x01}} // 312 Kelvin
+	assert.Empty(sl.SensorTemperatures())
+	assert.False(sl.CompositeTemperatureMismatch(20))
+
+	sl.TempSensor[0] = 305
+	sl.TempSensor[2] = 340
+	assert.Equal([]utils.Temperature{305, 340}, sl.SensorTemperatures())
+	assert.False(sl.CompositeTemperatureMismatch(20))
+
+	// Bogus composite temperature far from all sensors
+	sl.Temperature = [2]uint8{0xff, 0xff}
+	assert.True(sl.CompositeTemperatureMismatch(20))
+}
+
+func TestEstimatedRemainingDays(t *testing.T) {
+	assert := assert.New(t)
+
+	prior := NVMeSMARTData{PercentUsed: 10}
+	sl := NVMeSMARTData{PercentUsed: 12}
+	assert.InDelta(880, sl.EstimatedRemainingDays(&prior, 20*24*time.Hour), 0.001)
+
+	// No change between samples, fall back to the lifetime average
+	sl.PowerOnHours[0] = 240 // 10 days
+	assert.InDelta(73.333, sl.EstimatedRemainingDays(&sl, time.Hour), 0.001)
+
+	assert.True(math.IsInf((&NVMeSMARTData{}).EstimatedRemainingDays(nil, 0), 1))
+
+	sl.PercentUsed = 100
+	assert.Zero(sl.EstimatedRemainingDays(&prior, time.Hour))
+}
+
+func TestDecodeAPST(t *testing.T) {
+	assert := assert.New(t)
+
+	buf := make([]byte, 256)
+	// PS0 -> PS3 after 100ms, PS1 -> PS4 after 2000ms
+	buf[0], buf[1] = 3<<3, 100
+	binary.LittleEndian.PutUint32(buf[8:], 4<<3|2000<<8)
+
+	apst := decodeAPST(1, buf)
+	assert.True(apst.Enabled)
+	assert.Equal(NVMeAPSTEntry{IdlePowerState: 3, IdleTime: 100}, apst.Entries[0])
+	assert.Equal(NVMeAPSTEntry{IdlePowerState: 4, IdleTime: 2000}, apst.Entries[1])
+	assert.Equal(NVMeAPSTEntry{}, apst.Entries[2])
+}
+
+func TestDecodeQueueCount(t *testing.T) {
+	assert := assert.New(t)
+
+	// Zero-based counts
+	q := decodeQueueCount(0x001f003f)
+	assert.Equal(64, q.Submission)
+	assert.Equal(32, q.Completion)
+}
+
+func TestDecodeControllerList(t *testing.T) {
+	assert := assert.New(t)
+
+	buf := make([]byte, 4096)
+	binary.LittleEndian.PutUint16(buf[0:], 2)
+	binary.LittleEndian.PutUint16(buf[2:], 0x0001)
+	binary.LittleEndian.PutUint16(buf[4:], 0x0041)
+
+	assert.Equal([]uint16{0x0001, 0x0041}, decodeControllerList(buf))
+	assert.Empty(decodeControllerList(make([]byte, 4096)))
+}
+
+func TestDecodePersistentEvents(t *testing.T) {
+	assert := assert.New(t)
+
+	var data bytes.Buffer
+
+	// Thermal excursion event, with two bytes of vendor specific information
+	binary.Write(&data, binary.LittleEndian, nvmePELEventHeader{
+		Type:         NVME_PEL_THERMAL_EXCURS,
+		HeaderLength: 21,
+		ControllerID: 1,
+		Timestamp:    1500000000123,
+		VSILength:    2,
+		Length:       4,
+	})
+	data.Write([]byte{0xaa, 0xbb, 5, 70})
+
+	// Event type without a typed decoder
+	binary.Write(&data, binary.LittleEndian, nvmePELEventHeader{
+		Type:         NVME_PEL_SANITIZE_START,
+		HeaderLength: 21,
+		Length:       1,
+	})
+	data.Write([]byte{0x01})
+
+	events := decodePersistentEvents(data.Bytes(), 2)
+	assert.Len(events, 2)
+
+	assert.Equal("Thermal Excursion", events[0].TypeName())
+	assert.Equal(uint16(1), events[0].ControllerID)
+	assert.Equal(int64(1500000000123), events[0].Timestamp.UnixNano()/1e6)
+	assert.Equal(&PersistentEventThermal{OverTemperature: 5, Threshold: 70}, events[0].Thermal)
+
+	assert.Equal("Sanitize Start", events[1].TypeName())
+	assert.Equal([]byte{0x01}, events[1].Data)
+	assert.True(events[1].Timestamp.IsZero())
+
+	// Truncated event data
+	assert.Len(decodePersistentEvents(data.Bytes()[:26], 2), 0)
+}
+
+func TestCheckPELLength(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError(checkPELLength(128*1024, 2))
+	assert.Error(checkPELLength(128*1024+1, 2))
+
+	// Without a reported PELS, and for implausibly large ones, the fixed cap applies
+	assert.NoError(checkPELLength(pelMaxLength, 0))
+	assert.Error(checkPELLength(1<<63, 0))
+	assert.Error(checkPELLength(pelMaxLength+1, 0xffffffff))
+}
+
+func TestDecodeSelfTestLog(t *testing.T) {
+	assert := assert.New(t)
+
+	buf := make([]byte, 564)
+	buf[0], buf[1] = NVME_SELF_TEST_EXTENDED, 42
+
+	for i := 0; i < selfTestResults; i++ {
+		buf[4+i*28] = NVME_SELF_TEST_RESULT_UNUSED
+	}
+
+	// Most recent result: short self-test, failed segment 3
+	buf[4], buf[5] = NVME_SELF_TEST_SHORT<<4|NVME_SELF_TEST_RESULT_FAILED, 3
+	utils.NativeEndian.PutUint64(buf[8:], 1234)
+
+	l, err := decodeSelfTestLog(buf)
+	assert.NoError(err)
+	assert.Equal(uint8(NVME_SELF_TEST_EXTENDED), l.CurrentOperation)
+	assert.Equal(uint8(42), l.CurrentCompletion)
+	assert.Len(l.Results, 1)
+	assert.False(l.Results[0].Passed())
+	assert.Equal(uint8(NVME_SELF_TEST_SHORT), l.Results[0].Code())
+	assert.Equal("Short self-test completed with failed segment 3 at 1234 power on hours", l.Results[0].String())
+
+	// Truncated log pages are rejected rather than partially decoded
+	_, err = decodeSelfTestLog(buf[:100])
+	assert.Error(err)
+}
+
+func TestDecodeANALog(t *testing.T) {
+	assert := assert.New(t)
+
+	data := make([]byte, anaHeaderLen+2*anaDescriptorLen+3*4)
+	utils.NativeEndian.PutUint16(data[8:], 2)
+
+	// Group 1, optimized, namespaces 1 and 2
+	utils.NativeEndian.PutUint32(data[16:], 1)
+	utils.NativeEndian.PutUint32(data[20:], 2)
+	data[32] = NVME_ANA_OPTIMIZED
+	utils.NativeEndian.PutUint32(data[48:], 1)
+	utils.NativeEndian.PutUint32(data[52:], 2)
+
+	// Group 2, inaccessible, namespace 3
+	utils.NativeEndian.PutUint32(data[56:], 2)
+	utils.NativeEndian.PutUint32(data[60:], 1)
+	data[72] = NVME_ANA_INACCESSIBLE
+	utils.NativeEndian.PutUint32(data[88:], 3)
+
+	groups := decodeANALog(data)
+	assert.Len(groups, 2)
+	assert.Equal([]uint32{1, 2}, groups[0].Namespaces)
+	assert.Equal("optimized", groups[0].State.String())
+	assert.Equal(uint32(2), groups[1].ID)
+	assert.Equal([]uint32{3}, groups[1].Namespaces)
+	assert.Equal("inaccessible", groups[1].State.String())
+}
+
+func TestDecodeNamespaceDescriptors(t *testing.T) {
+	assert := assert.New(t)
+
+	buf := make([]byte, 4096)
+	copy(buf, []byte{NVME_NIDT_EUI64, 8, 0, 0, 0x00, 0x25, 0x38, 0x8b, 0x71, 0xb0, 0xe7, 0xd1})
+	copy(buf[12:], []byte{NVME_NIDT_CSI, 1, 0, 0, 0x00})
+
+	descs := decodeNamespaceDescriptors(buf)
+	assert.Len(descs, 2)
+	assert.Equal(NVMeNamespaceDescriptor{NVME_NIDT_EUI64, []byte{0x00, 0x25, 0x38, 0x8b, 0x71, 0xb0, 0xe7, 0xd1}}, descs[0])
+	assert.Equal(NVMeNamespaceDescriptor{NVME_NIDT_CSI, []byte{0x00}}, descs[1])
+}
+
+func TestControllerValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	// All-zero identify data, e.g. from a device which ignored the command
+	var c NVMeController
+	assert.Error(c.validate())
+
+	copy(c.ModelNumber[:], "Samsung SSD 960 PRO 512GB")
+	copy(c.SerialNumber[:], "S3EWNX0J123456")
+	assert.NoError(c.validate())
+
+	c.Ver = 0x00010300
+	assert.NoError(c.validate())
+	assert.Equal(`model="Samsung SSD 960 PRO 512GB" serial="S3EWNX0J123456" firmware="" version=1.3.0 capacity=0`,
+		c.String())
+
+	c.Ver = 0xffff0000
+	assert.Error(c.validate())
+}
+
+func TestFirmwareSlots(t *testing.T) {
+	assert := assert.New(t)
+
+	c := NVMeController{Frmw: 0x07}
+	slots, ro := c.FirmwareSlots()
+	assert.Equal(3, slots)
+	assert.True(ro)
+}
+
+func TestSubsystemControllers(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	defer func(d string) { sysfsNVMeDir = d }(sysfsNVMeDir)
+	sysfsNVMeDir = dir
+
+	const nqn = "nqn.2014-08.org.nvmexpress:uuid:0f3c9e6a-1d2b-4c5e-8f7a-9b8c7d6e5f4a"
+
+	for name, attrs := range map[string][2]string{
+		"nvme0": {nqn + "\n", "1\n"},
+		"nvme1": {nqn + "\n", "0x2\n"}, // cntlid may be reported in hex
+		"nvme2": {"nqn.2014-08.org.nvmexpress:other\n", "3\n"},
+		"nvme3": {nqn + "\n", ""}, // No cntlid attribute
+		"nvme4": {nqn + "\n", "bogus\n"},
+	} {
+		assert.NoError(os.Mkdir(filepath.Join(dir, name), 0700))
+		assert.NoError(ioutil.WriteFile(filepath.Join(dir, name, "subsysnqn"), []byte(attrs[0]), 0600))
+		if attrs[1] != "" {
+			assert.NoError(ioutil.WriteFile(filepath.Join(dir, name, "cntlid"), []byte(attrs[1]), 0600))
+		}
+	}
+
+	paths, err := subsystemControllers(nqn)
+	assert.NoError(err)
+	assert.Equal(map[uint16]string{1: "/dev/nvme0", 2: "/dev/nvme1"}, paths)
+}
+
+func TestDecodeErrorLog(t *testing.T) {
+	assert := assert.New(t)
+
+	// Three entries, of which the second is unused
+	buf := make([]byte, 3*64)
+	utils.NativeEndian.PutUint64(buf[0:], 7)
+	utils.NativeEndian.PutUint16(buf[8:], 1)
+	utils.NativeEndian.PutUint16(buf[10:], 0x1234)
+	utils.NativeEndian.PutUint16(buf[12:], 0x4004)
+	utils.NativeEndian.PutUint64(buf[16:], 0x1000)
+	utils.NativeEndian.PutUint32(buf[24:], 1)
+	utils.NativeEndian.PutUint64(buf[128:], 5)
+
+	entries, err := decodeErrorLog(buf, make([]NVMeErrorLogEntry, 3))
+	assert.NoError(err)
+	assert.Len(entries, 2)
+	assert.Equal(uint64(7), entries[0].ErrorCount)
+	assert.Equal(uint16(1), entries[0].Sqid)
+	assert.Equal(uint16(0x1234), entries[0].Cmdid)
+	assert.Equal(uint16(0x4004), entries[0].Status)
+	assert.Equal(uint64(0x1000), entries[0].LBA)
+	assert.Equal(uint32(1), entries[0].Nsid)
+	assert.Equal(uint64(5), entries[1].ErrorCount)
+
+	_, err = decodeErrorLog(buf[:100], make([]NVMeErrorLogEntry, 3))
+	assert.Error(err)
+}
+
+func TestDecodeFirmwareSlotLog(t *testing.T) {
+	assert := assert.New(t)
+
+	buf := make([]byte, 512)
+	buf[0] = 0x12 // Running from slot 2, slot 1 activated at next reset
+	copy(buf[8:], "1.0.0   ")
+	copy(buf[16:], "1.1.0\x00\x00\x00")
+
+	l, err := decodeFirmwareSlotLog(buf)
+	assert.NoError(err)
+	assert.Equal(2, l.ActiveSlot())
+	assert.Equal("1.0.0", l.Revision(1))
+	assert.Equal("1.1.0", l.Revision(2))
+	assert.Equal("", l.Revision(3))
+	assert.Equal("", l.Revision(0))
+	assert.Equal("", l.Revision(8))
+
+	_, err = decodeFirmwareSlotLog(buf[:64])
+	assert.Error(err)
+}
+
+func TestSanitizeOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	opts := SanitizeOptions{
+		Action:            NVME_SANACT_OVERWRITE,
+		AllowUnrestricted: true,
+		OverwritePasses:   16,
+		OverwritePattern:  0xdeadbeef,
+		InvertPattern:     true,
+		NoDeallocate:      true,
+	}
+
+	// 16 passes are encoded as zero
+	assert.Equal(uint32(0x30b), opts.cdw10())
+	assert.NoError(opts.validate(NVME_SANICAP_OVERWRITE))
+	assert.Equal(ErrSanitizeNotSupported, opts.validate(NVME_SANICAP_BLOCK_ERASE))
+	assert.Error(opts.validate(NVME_SANICAP_OVERWRITE | NVME_SANICAP_NDI))
+
+	opts.OverwritePasses = 0
+	assert.Error(opts.validate(NVME_SANICAP_OVERWRITE))
+
+	// The pattern is only valid for overwrite
+	opts = SanitizeOptions{Action: NVME_SANACT_BLOCK_ERASE, OverwritePattern: 0xff}
+	assert.Error(opts.validate(NVME_SANICAP_BLOCK_ERASE))
+}
+
+func TestBufferPool(t *testing.T) {
+	assert := assert.New(t)
+
+	bufp := getBuffer(4096)
+	assert.Len(*bufp, 4096)
+	assert.Zero(uintptr(unsafe.Pointer(&(*bufp)[0])) % bufferAlign)
+
+	(*bufp)[0] = 0xff
+	putBuffer(bufp)
+
+	// Recycled buffers must be zeroed
+	bufp = getBuffer(4096)
+	assert.Equal(make([]byte, 4096), *bufp)
+	putBuffer(bufp)
+
+	// Sizes without a pool are allocated directly
+	assert.Len(*getBuffer(1024), 1024)
+}
+
+// BenchmarkPollAlloc and BenchmarkPollPooled compare a SMART log poll cycle (buffer acquisition
+// followed by decoding) with freshly allocated and pooled buffers respectively.
+func BenchmarkPollAlloc(b *testing.B) {
+	var sl NVMeSMARTData
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		buf := alignedBuffer(512)
+		utils.DecodeInto(buf, &sl)
+	}
+}
+
+func BenchmarkPollPooled(b *testing.B) {
+	var sl NVMeSMARTData
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		bufp := getBuffer(512)
+		utils.DecodeInto(*bufp, &sl)
+		putBuffer(bufp)
+	}
+}
+
+// BenchmarkIdentifyController measures the identify controller command on the NVMe device named by
+// the NVME_BENCH_DEVICE environment variable, e.g. /dev/nvme0. It is skipped if it is not set.
+// The data transfer is always the full 4096 bytes, since the command has no transfer length, so
+// reading only part of the data structure would not make it any cheaper.
+func BenchmarkIdentifyController(b *testing.B) {
+	name := os.Getenv("NVME_BENCH_DEVICE")
+	if name == "" {
+		b.Skip("NVME_BENCH_DEVICE not set")
+	}
+
+	d := NewNVMeDevice(name)
+	if err := d.Open(); err != nil {
+		b.Fatal(err)
+	}
+
+	defer d.Close()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := d.IdentifyController(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
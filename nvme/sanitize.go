@@ -0,0 +1,128 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+// NVMe Sanitize command.
+
+package nvme
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	NVME_ADMIN_SANITIZE = 0x84
+
+	// Sanitize actions (SANACT)
+	NVME_SANACT_EXIT_FAILURE = 0x1 // Exit Failure Mode
+	NVME_SANACT_BLOCK_ERASE  = 0x2
+	NVME_SANACT_OVERWRITE    = 0x3
+	NVME_SANACT_CRYPTO_ERASE = 0x4
+
+	// Sanitize Capabilities (SANICAP) bits
+	NVME_SANICAP_CRYPTO_ERASE = 1 << 0
+	NVME_SANICAP_BLOCK_ERASE  = 1 << 1
+	NVME_SANICAP_OVERWRITE    = 1 << 2
+	NVME_SANICAP_NDI          = 1 << 29 // No-Deallocate Inhibited
+
+	// Maximum Overwrite Pass Count (OWPASS); a count of 16 is encoded as zero
+	sanitizeMaxPasses = 16
+)
+
+var ErrSanitizeNotSupported = errors.New("controller does not support the requested sanitize action")
+
+// SanitizeOptions specifies the parameters of a Sanitize command.
+type SanitizeOptions struct {
+	Action            uint8  // Sanitize Action (SANACT), one of the NVME_SANACT_* values
+	AllowUnrestricted bool   // Allow Unrestricted Sanitize Exit (AUSE)
+	OverwritePasses   uint8  // Overwrite Pass Count (1-16, overwrite action only)
+	OverwritePattern  uint32 // Overwrite Pattern (overwrite action only)
+	InvertPattern     bool   // Overwrite Invert Pattern Between Passes (OIPBP)
+	NoDeallocate      bool   // No Deallocate After Sanitize (NDAS)
+}
+
+// cdw10 returns Command Dword 10 of a Sanitize command with these options.
+func (o *SanitizeOptions) cdw10() uint32 {
+	cdw10 := uint32(o.Action & 0x07)
+
+	if o.AllowUnrestricted {
+		cdw10 |= 1 << 3
+	}
+
+	if o.Action == NVME_SANACT_OVERWRITE {
+		cdw10 |= uint32(o.OverwritePasses&0x0f) << 4
+
+		if o.InvertPattern {
+			cdw10 |= 1 << 8
+		}
+	}
+
+	if o.NoDeallocate {
+		cdw10 |= 1 << 9
+	}
+
+	return cdw10
+}
+
+// validate checks the options against the controller's Sanitize Capabilities (SANICAP).
+func (o *SanitizeOptions) validate(sanicap uint32) error {
+	switch o.Action {
+	case NVME_SANACT_EXIT_FAILURE:
+		if sanicap&(NVME_SANICAP_CRYPTO_ERASE|NVME_SANICAP_BLOCK_ERASE|NVME_SANICAP_OVERWRITE) == 0 {
+			return ErrSanitizeNotSupported
+		}
+	case NVME_SANACT_BLOCK_ERASE:
+		if sanicap&NVME_SANICAP_BLOCK_ERASE == 0 {
+			return ErrSanitizeNotSupported
+		}
+	case NVME_SANACT_CRYPTO_ERASE:
+		if sanicap&NVME_SANICAP_CRYPTO_ERASE == 0 {
+			return ErrSanitizeNotSupported
+		}
+	case NVME_SANACT_OVERWRITE:
+		if sanicap&NVME_SANICAP_OVERWRITE == 0 {
+			return ErrSanitizeNotSupported
+		}
+
+		if o.OverwritePasses == 0 || o.OverwritePasses > sanitizeMaxPasses {
+			return fmt.Errorf("invalid overwrite pass count: %d", o.OverwritePasses)
+		}
+	default:
+		return fmt.Errorf("invalid sanitize action: %#x", o.Action)
+	}
+
+	if o.Action != NVME_SANACT_OVERWRITE && (o.OverwritePattern != 0 || o.InvertPattern) {
+		return errors.New("overwrite pattern is only valid for the overwrite action")
+	}
+
+	if o.NoDeallocate && sanicap&NVME_SANICAP_NDI != 0 {
+		return errors.New("controller does not permit no-deallocate after sanitize")
+	}
+
+	return nil
+}
+
+// Sanitize starts a sanitize operation on the NVM subsystem, which alters all user data so that
+// it cannot be recovered. The options are validated against the controller's sanitize
+// capabilities first. The command returns once the operation has started; it continues in the
+// background, and is resumed after a reset or power cycle until it completes.
+func (d *NVMeDevice) Sanitize(opts SanitizeOptions) error {
+	controller, err := d.IdentifyController()
+	if err != nil {
+		return err
+	}
+
+	if err := opts.validate(controller.Sanicap); err != nil {
+		return err
+	}
+
+	cmd := nvmePassthruCommand{
+		opcode: NVME_ADMIN_SANITIZE,
+		cdw10:  opts.cdw10(),
+		cdw11:  opts.OverwritePattern,
+	}
+
+	_, err = d.adminCommand(&cmd)
+
+	return err
+}
@@ -0,0 +1,230 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+// NVMe device self-test.
+
+package nvme
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/madper/smart/utils"
+)
+
+const (
+	NVME_ADMIN_DEVICE_SELF_TEST = 0x14
+	NVME_LOG_SELF_TEST          = 0x06
+
+	// Optional Admin Command Support (OACS) bits
+	NVME_OACS_SELF_TEST = 1 << 4
+
+	// Self-test codes (STC)
+	NVME_SELF_TEST_SHORT    = 0x1
+	NVME_SELF_TEST_EXTENDED = 0x2
+	NVME_SELF_TEST_VENDOR   = 0xe
+	NVME_SELF_TEST_ABORT    = 0xf
+
+	// Self-test results
+	NVME_SELF_TEST_RESULT_PASSED     = 0x0 // Operation completed without error
+	NVME_SELF_TEST_RESULT_ABORTED    = 0x1 // Aborted by a Device Self-test command
+	NVME_SELF_TEST_RESULT_RESET      = 0x2 // Aborted by a Controller Level Reset
+	NVME_SELF_TEST_RESULT_NS_REMOVED = 0x3 // Aborted due to a removal of a namespace
+	NVME_SELF_TEST_RESULT_FORMAT     = 0x4 // Aborted due to the processing of a Format NVM command
+	NVME_SELF_TEST_RESULT_FATAL      = 0x5 // A fatal error or unknown test error occurred
+	NVME_SELF_TEST_RESULT_UNKNOWN    = 0x6 // Completed with a failed segment that is not known
+	NVME_SELF_TEST_RESULT_FAILED     = 0x7 // Completed with one or more failed segments
+	NVME_SELF_TEST_RESULT_ABORT_UNK  = 0x8 // Aborted for unknown reason
+	NVME_SELF_TEST_RESULT_SANITIZE   = 0x9 // Aborted due to a sanitize operation
+	NVME_SELF_TEST_RESULT_UNUSED     = 0xf // Entry not used (does not contain a test result)
+
+	// Number of result entries in the Device Self-test log page
+	selfTestResults = 20
+
+	// Interval at which WaitSelfTest polls the self-test log if none is specified
+	defaultSelfTestPoll = time.Second
+)
+
+var (
+	ErrSelfTestNotSupported = errors.New("controller does not support the device self-test command")
+	ErrNoSelfTestResult     = errors.New("self-test log does not contain a result")
+)
+
+// SelfTestResult is a self-test result data structure of the Device Self-test log page.
+type SelfTestResult struct {
+	Status         uint8   // Device Self-test Status (code in bits 7:4, result in bits 3:0)
+	Segment        uint8   // Segment Number of the first failed segment
+	ValidInfo      uint8   // Valid Diagnostic Information
+	Rsvd3          uint8   // ...
+	PowerOnHours   uint64  // Power On Hours when the operation completed or aborted
+	Nsid           uint32  // Namespace Identifier (valid if ValidInfo bit 0 is set)
+	FailingLBA     uint64  // Failing LBA (valid if ValidInfo bit 1 is set)
+	StatusCodeType uint8   // Status Code Type (valid if ValidInfo bit 2 is set)
+	StatusCode     uint8   // Status Code (valid if ValidInfo bit 3 is set)
+	VendorSpecific [2]byte // ...
+} // 28 bytes
+
+// Code returns the self-test code of the operation, e.g. NVME_SELF_TEST_SHORT.
+func (r *SelfTestResult) Code() uint8 {
+	return r.Status >> 4
+}
+
+// Result returns the result of the operation, one of the NVME_SELF_TEST_RESULT_* values.
+func (r *SelfTestResult) Result() uint8 {
+	return r.Status & 0x0f
+}
+
+// Passed reports whether the operation completed without error.
+func (r *SelfTestResult) Passed() bool {
+	return r.Result() == NVME_SELF_TEST_RESULT_PASSED
+}
+
+func (r *SelfTestResult) String() string {
+	var code string
+
+	switch r.Code() {
+	case NVME_SELF_TEST_SHORT:
+		code = "Short"
+	case NVME_SELF_TEST_EXTENDED:
+		code = "Extended"
+	case NVME_SELF_TEST_VENDOR:
+		code = "Vendor specific"
+	default:
+		code = fmt.Sprintf("Unknown (%#x)", r.Code())
+	}
+
+	var result string
+
+	switch r.Result() {
+	case NVME_SELF_TEST_RESULT_PASSED:
+		result = "completed without error"
+	case NVME_SELF_TEST_RESULT_ABORTED:
+		result = "aborted by a Device Self-test command"
+	case NVME_SELF_TEST_RESULT_RESET:
+		result = "aborted by a controller reset"
+	case NVME_SELF_TEST_RESULT_NS_REMOVED:
+		result = "aborted due to namespace removal"
+	case NVME_SELF_TEST_RESULT_FORMAT:
+		result = "aborted due to a Format NVM command"
+	case NVME_SELF_TEST_RESULT_FATAL:
+		result = "fatal or unknown test error"
+	case NVME_SELF_TEST_RESULT_UNKNOWN:
+		result = "completed with an unknown failed segment"
+	case NVME_SELF_TEST_RESULT_FAILED:
+		result = fmt.Sprintf("completed with failed segment %d", r.Segment)
+	case NVME_SELF_TEST_RESULT_ABORT_UNK:
+		result = "aborted for unknown reason"
+	case NVME_SELF_TEST_RESULT_SANITIZE:
+		result = "aborted due to a sanitize operation"
+	default:
+		result = fmt.Sprintf("unknown result (%#x)", r.Result())
+	}
+
+	return fmt.Sprintf("%s self-test %s at %d power on hours", code, result, r.PowerOnHours)
+}
+
+// NVMeSelfTestLog is the Device Self-test log page (06h).
+type NVMeSelfTestLog struct {
+	CurrentOperation  uint8            // Self-test code of the operation in progress, or zero
+	CurrentCompletion uint8            // Completion of the operation in progress (percent)
+	Results           []SelfTestResult // Results of the most recent operations, newest first
+}
+
+// StartSelfTest starts a device self-test operation of the specified type (NVME_SELF_TEST_SHORT
+// or NVME_SELF_TEST_EXTENDED) on the specified namespace, or on the controller and all namespaces
+// if nsid is 0xffffffff. NVME_SELF_TEST_ABORT aborts the operation in progress. The command
+// returns once the operation has started; its progress and result are reported in the self-test
+// log.
+func (d *NVMeDevice) StartSelfTest(nsid uint32, code uint8) error {
+	controller, err := d.IdentifyController()
+	if err != nil {
+		return err
+	}
+
+	if controller.Oacs&NVME_OACS_SELF_TEST == 0 {
+		return ErrSelfTestNotSupported
+	}
+
+	cmd := nvmePassthruCommand{
+		opcode: NVME_ADMIN_DEVICE_SELF_TEST,
+		nsid:   nsid,
+		cdw10:  uint32(code & 0x0f),
+	}
+
+	_, err = d.adminCommand(&cmd)
+
+	return err
+}
+
+// SelfTestLog reads and decodes the Device Self-test log page. Unused result entries are omitted.
+func (d *NVMeDevice) SelfTestLog() (*NVMeSelfTestLog, error) {
+	bufp := getBuffer(4 + selfTestResults*binary.Size(SelfTestResult{}))
+	defer putBuffer(bufp)
+	buf := *bufp
+
+	if err := d.readLogPage(NVME_LOG_SELF_TEST, &buf); err != nil {
+		return nil, err
+	}
+
+	return decodeSelfTestLog(buf)
+}
+
+// decodeSelfTestLog decodes a Device Self-test log page.
+func decodeSelfTestLog(buf []byte) (*NVMeSelfTestLog, error) {
+	var results [selfTestResults]SelfTestResult
+
+	if len(buf) < 4 {
+		return nil, fmt.Errorf("short self-test log: got %d bytes", len(buf))
+	}
+
+	l := NVMeSelfTestLog{
+		CurrentOperation:  buf[0] & 0x0f,
+		CurrentCompletion: buf[1] & 0x7f,
+	}
+
+	if err := utils.DecodeInto(buf[4:], &results); err != nil {
+		return nil, err
+	}
+
+	for _, r := range results {
+		if r.Result() != NVME_SELF_TEST_RESULT_UNUSED {
+			l.Results = append(l.Results, r)
+		}
+	}
+
+	return &l, nil
+}
+
+// WaitSelfTest polls the self-test log at the specified interval until no self-test operation is
+// in progress, and returns the result of the most recent operation. A poll interval of zero or less
+// selects a default of one second. If ctx is done first, ctx.Err() is returned; the operation
+// itself continues in the background, unless aborted with StartSelfTest.
+func (d *NVMeDevice) WaitSelfTest(ctx context.Context, poll time.Duration) (*SelfTestResult, error) {
+	if poll <= 0 {
+		poll = defaultSelfTestPoll
+	}
+
+	for {
+		l, err := d.SelfTestLog()
+		if err != nil {
+			return nil, err
+		}
+
+		if l.CurrentOperation == 0 {
+			if len(l.Results) == 0 {
+				return nil, ErrNoSelfTestResult
+			}
+
+			return &l.Results[0], nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(poll):
+		}
+	}
+}
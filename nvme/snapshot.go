@@ -0,0 +1,57 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+// Collection of NVMe health information in a single pass.
+
+package nvme
+
+import (
+	"time"
+)
+
+// Snapshot is the health information of an NVMe controller collected at a single point in time.
+type Snapshot struct {
+	Time       time.Time            // Time at which the collection started
+	Controller *NVMeController      // Identify controller data
+	SMART      *NVMeSMARTData       // SMART / Health Information log
+	Errors     []NVMeErrorLogEntry  // Error Information log entries, most recent first
+	Firmware   *NVMeFirmwareSlotLog // Firmware Slot Information log
+}
+
+// HealthSnapshot gathers the identify controller data, SMART / Health Information log, Error
+// Information log and Firmware Slot Information log of the open device in one go.
+func (d *NVMeDevice) HealthSnapshot() (*Snapshot, error) {
+	var err error
+
+	s := Snapshot{Time: time.Now()}
+
+	if s.Controller, err = d.IdentifyController(); err != nil {
+		return nil, err
+	}
+
+	if s.SMART, err = d.ReadSMART(); err != nil {
+		return nil, err
+	}
+
+	if s.Errors, err = d.errorLog(s.Controller); err != nil {
+		return nil, err
+	}
+
+	if s.Firmware, err = d.FirmwareSlotLog(); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// ReadHealthSnapshot opens the named NVMe device, gathers its health snapshot and closes it again.
+func ReadHealthSnapshot(name string) (*Snapshot, error) {
+	d := NewNVMeDevice(name)
+	if err := d.Open(); err != nil {
+		return nil, err
+	}
+
+	defer d.Close()
+
+	return d.HealthSnapshot()
+}
@@ -0,0 +1,194 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+// NVMe command completion status.
+
+package nvme
+
+import (
+	"fmt"
+)
+
+const (
+	// Status code types
+	NVME_SCT_GENERIC          = 0x0
+	NVME_SCT_COMMAND_SPECIFIC = 0x1
+	NVME_SCT_MEDIA_ERROR      = 0x2 // Media and Data Integrity Errors
+	NVME_SCT_PATH             = 0x3 // Path Related Status
+	NVME_SCT_VENDOR           = 0x7
+
+	// Generic command status codes
+	NVME_SC_SUCCESS              = 0x00
+	NVME_SC_INVALID_OPCODE       = 0x01
+	NVME_SC_INVALID_FIELD        = 0x02
+	NVME_SC_CMDID_CONFLICT       = 0x03
+	NVME_SC_DATA_XFER_ERROR      = 0x04
+	NVME_SC_POWER_LOSS           = 0x05 // Commands Aborted due to Power Loss Notification
+	NVME_SC_INTERNAL             = 0x06
+	NVME_SC_ABORT_REQ            = 0x07 // Command Abort Requested
+	NVME_SC_ABORT_QUEUE          = 0x08 // Command Aborted due to SQ Deletion
+	NVME_SC_INVALID_NS           = 0x0b // Invalid Namespace or Format
+	NVME_SC_CMD_SEQ_ERROR        = 0x0c
+	NVME_SC_SANITIZE_FAILED      = 0x1c
+	NVME_SC_SANITIZE_IN_PROGRESS = 0x1d
+	NVME_SC_NS_WRITE_PROTECTED   = 0x20
+	NVME_SC_CMD_INTERRUPTED      = 0x21
+	NVME_SC_LBA_RANGE            = 0x80
+	NVME_SC_CAP_EXCEEDED         = 0x81
+	NVME_SC_NS_NOT_READY         = 0x82
+	NVME_SC_RESERVATION_CONFLICT = 0x83
+	NVME_SC_FORMAT_IN_PROGRESS   = 0x84
+
+	// Command specific status codes
+	NVME_SC_ABORT_LIMIT           = 0x03 // Abort Command Limit Exceeded
+	NVME_SC_ASYNC_LIMIT           = 0x05 // Asynchronous Event Request Limit Exceeded
+	NVME_SC_INVALID_FW_SLOT       = 0x06
+	NVME_SC_INVALID_FW_IMAGE      = 0x07
+	NVME_SC_INVALID_LOG_PAGE      = 0x09
+	NVME_SC_INVALID_FORMAT        = 0x0a
+	NVME_SC_FW_NEEDS_CONV_RESET   = 0x0b // Firmware Activation Requires Conventional Reset
+	NVME_SC_FEATURE_NOT_SAVEABLE  = 0x0d
+	NVME_SC_FEATURE_NOT_CHANGE    = 0x0e // Feature Not Changeable
+	NVME_SC_FEATURE_NOT_PER_NS    = 0x0f // Feature Not Namespace Specific
+	NVME_SC_FW_NEEDS_SUBSYS_RESET = 0x10 // Firmware Activation Requires NVM Subsystem Reset
+	NVME_SC_FW_NEEDS_RESET        = 0x11 // Firmware Activation Requires Controller Level Reset
+	NVME_SC_FW_NEEDS_MAX_TIME     = 0x12 // Firmware Activation Requires Maximum Time Violation
+	NVME_SC_FW_ACTIVATE_PROHIBIT  = 0x13 // Firmware Activation Prohibited
+	NVME_SC_NS_INSUFFICIENT_CAP   = 0x15 // Namespace Insufficient Capacity
+	NVME_SC_NS_ALREADY_ATTACHED   = 0x18
+	NVME_SC_NS_NOT_ATTACHED       = 0x1a
+	NVME_SC_SELF_TEST_IN_PROGRESS = 0x1d
+
+	// Media and data integrity error status codes
+	NVME_SC_WRITE_FAULT     = 0x80
+	NVME_SC_READ_ERROR      = 0x81 // Unrecovered Read Error
+	NVME_SC_GUARD_CHECK     = 0x82 // End-to-end Guard Check Error
+	NVME_SC_APPTAG_CHECK    = 0x83 // End-to-end Application Tag Check Error
+	NVME_SC_REFTAG_CHECK    = 0x84 // End-to-end Reference Tag Check Error
+	NVME_SC_COMPARE_FAILED  = 0x85
+	NVME_SC_ACCESS_DENIED   = 0x86
+	NVME_SC_UNWRITTEN_BLOCK = 0x87 // Deallocated or Unwritten Logical Block
+
+	// Path related status codes
+	NVME_SC_PATH_INTERNAL        = 0x00 // Internal Path Error
+	NVME_SC_ANA_PERSISTENT_LOSS  = 0x01 // Asymmetric Access Persistent Loss
+	NVME_SC_ANA_INACCESSIBLE     = 0x02 // Asymmetric Access Inaccessible
+	NVME_SC_ANA_TRANSITION       = 0x03 // Asymmetric Access Transition
+	NVME_SC_HOST_PATH_ERROR      = 0x70 // Host Pathing Error
+	NVME_SC_HOST_ABORTED_COMMAND = 0x71 // Command Aborted by Host
+)
+
+// nvmeStatusMessages maps the status code type and status code, as returned by NVMeStatus.Code,
+// to a description of the status.
+var nvmeStatusMessages = map[uint16]string{
+	NVME_SCT_GENERIC<<8 | NVME_SC_SUCCESS:              "Successful Completion",
+	NVME_SCT_GENERIC<<8 | NVME_SC_INVALID_OPCODE:       "Invalid Command Opcode",
+	NVME_SCT_GENERIC<<8 | NVME_SC_INVALID_FIELD:        "Invalid Field in Command",
+	NVME_SCT_GENERIC<<8 | NVME_SC_CMDID_CONFLICT:       "Command ID Conflict",
+	NVME_SCT_GENERIC<<8 | NVME_SC_DATA_XFER_ERROR:      "Data Transfer Error",
+	NVME_SCT_GENERIC<<8 | NVME_SC_POWER_LOSS:           "Commands Aborted due to Power Loss Notification",
+	NVME_SCT_GENERIC<<8 | NVME_SC_INTERNAL:             "Internal Error",
+	NVME_SCT_GENERIC<<8 | NVME_SC_ABORT_REQ:            "Command Abort Requested",
+	NVME_SCT_GENERIC<<8 | NVME_SC_ABORT_QUEUE:          "Command Aborted due to SQ Deletion",
+	NVME_SCT_GENERIC<<8 | NVME_SC_INVALID_NS:           "Invalid Namespace or Format",
+	NVME_SCT_GENERIC<<8 | NVME_SC_CMD_SEQ_ERROR:        "Command Sequence Error",
+	NVME_SCT_GENERIC<<8 | NVME_SC_SANITIZE_FAILED:      "Sanitize Failed",
+	NVME_SCT_GENERIC<<8 | NVME_SC_SANITIZE_IN_PROGRESS: "Sanitize In Progress",
+	NVME_SCT_GENERIC<<8 | NVME_SC_NS_WRITE_PROTECTED:   "Namespace is Write Protected",
+	NVME_SCT_GENERIC<<8 | NVME_SC_CMD_INTERRUPTED:      "Command Interrupted",
+	NVME_SCT_GENERIC<<8 | NVME_SC_LBA_RANGE:            "LBA Out of Range",
+	NVME_SCT_GENERIC<<8 | NVME_SC_CAP_EXCEEDED:         "Capacity Exceeded",
+	NVME_SCT_GENERIC<<8 | NVME_SC_NS_NOT_READY:         "Namespace Not Ready",
+	NVME_SCT_GENERIC<<8 | NVME_SC_RESERVATION_CONFLICT: "Reservation Conflict",
+	NVME_SCT_GENERIC<<8 | NVME_SC_FORMAT_IN_PROGRESS:   "Format In Progress",
+
+	NVME_SCT_COMMAND_SPECIFIC<<8 | NVME_SC_ABORT_LIMIT:           "Abort Command Limit Exceeded",
+	NVME_SCT_COMMAND_SPECIFIC<<8 | NVME_SC_ASYNC_LIMIT:           "Asynchronous Event Request Limit Exceeded",
+	NVME_SCT_COMMAND_SPECIFIC<<8 | NVME_SC_INVALID_FW_SLOT:       "Invalid Firmware Slot",
+	NVME_SCT_COMMAND_SPECIFIC<<8 | NVME_SC_INVALID_FW_IMAGE:      "Invalid Firmware Image",
+	NVME_SCT_COMMAND_SPECIFIC<<8 | NVME_SC_INVALID_LOG_PAGE:      "Invalid Log Page",
+	NVME_SCT_COMMAND_SPECIFIC<<8 | NVME_SC_INVALID_FORMAT:        "Invalid Format",
+	NVME_SCT_COMMAND_SPECIFIC<<8 | NVME_SC_FW_NEEDS_CONV_RESET:   "Firmware Activation Requires Conventional Reset",
+	NVME_SCT_COMMAND_SPECIFIC<<8 | NVME_SC_FEATURE_NOT_SAVEABLE:  "Feature Identifier Not Saveable",
+	NVME_SCT_COMMAND_SPECIFIC<<8 | NVME_SC_FEATURE_NOT_CHANGE:    "Feature Not Changeable",
+	NVME_SCT_COMMAND_SPECIFIC<<8 | NVME_SC_FEATURE_NOT_PER_NS:    "Feature Not Namespace Specific",
+	NVME_SCT_COMMAND_SPECIFIC<<8 | NVME_SC_FW_NEEDS_SUBSYS_RESET: "Firmware Activation Requires NVM Subsystem Reset",
+	NVME_SCT_COMMAND_SPECIFIC<<8 | NVME_SC_FW_NEEDS_RESET:        "Firmware Activation Requires Controller Level Reset",
+	NVME_SCT_COMMAND_SPECIFIC<<8 | NVME_SC_FW_NEEDS_MAX_TIME:     "Firmware Activation Requires Maximum Time Violation",
+	NVME_SCT_COMMAND_SPECIFIC<<8 | NVME_SC_FW_ACTIVATE_PROHIBIT:  "Firmware Activation Prohibited",
+	NVME_SCT_COMMAND_SPECIFIC<<8 | NVME_SC_NS_INSUFFICIENT_CAP:   "Namespace Insufficient Capacity",
+	NVME_SCT_COMMAND_SPECIFIC<<8 | NVME_SC_NS_ALREADY_ATTACHED:   "Namespace Already Attached",
+	NVME_SCT_COMMAND_SPECIFIC<<8 | NVME_SC_NS_NOT_ATTACHED:       "Namespace Not Attached",
+	NVME_SCT_COMMAND_SPECIFIC<<8 | NVME_SC_SELF_TEST_IN_PROGRESS: "Device Self-test In Progress",
+
+	NVME_SCT_MEDIA_ERROR<<8 | NVME_SC_WRITE_FAULT:     "Write Fault",
+	NVME_SCT_MEDIA_ERROR<<8 | NVME_SC_READ_ERROR:      "Unrecovered Read Error",
+	NVME_SCT_MEDIA_ERROR<<8 | NVME_SC_GUARD_CHECK:     "End-to-end Guard Check Error",
+	NVME_SCT_MEDIA_ERROR<<8 | NVME_SC_APPTAG_CHECK:    "End-to-end Application Tag Check Error",
+	NVME_SCT_MEDIA_ERROR<<8 | NVME_SC_REFTAG_CHECK:    "End-to-end Reference Tag Check Error",
+	NVME_SCT_MEDIA_ERROR<<8 | NVME_SC_COMPARE_FAILED:  "Compare Failure",
+	NVME_SCT_MEDIA_ERROR<<8 | NVME_SC_ACCESS_DENIED:   "Access Denied",
+	NVME_SCT_MEDIA_ERROR<<8 | NVME_SC_UNWRITTEN_BLOCK: "Deallocated or Unwritten Logical Block",
+
+	NVME_SCT_PATH<<8 | NVME_SC_PATH_INTERNAL:        "Internal Path Error",
+	NVME_SCT_PATH<<8 | NVME_SC_ANA_PERSISTENT_LOSS:  "Asymmetric Access Persistent Loss",
+	NVME_SCT_PATH<<8 | NVME_SC_ANA_INACCESSIBLE:     "Asymmetric Access Inaccessible",
+	NVME_SCT_PATH<<8 | NVME_SC_ANA_TRANSITION:       "Asymmetric Access Transition",
+	NVME_SCT_PATH<<8 | NVME_SC_HOST_PATH_ERROR:      "Host Pathing Error",
+	NVME_SCT_PATH<<8 | NVME_SC_HOST_ABORTED_COMMAND: "Command Aborted by Host",
+}
+
+// NVMeStatus is the status field of a completion queue entry, excluding the phase tag, for a
+// command that the controller completed with an error. Every admin command returns its failure
+// as an NVMeStatus, so that callers can check for specific conditions with Matches or IsStatus.
+type NVMeStatus uint16
+
+// SCT returns the Status Code Type.
+func (s NVMeStatus) SCT() uint8 {
+	return uint8(s>>8) & 0x07
+}
+
+// SC returns the Status Code.
+func (s NVMeStatus) SC() uint8 {
+	return uint8(s)
+}
+
+// Code returns the Status Code Type and Status Code combined, i.e. the status without the Do Not
+// Retry, More and Command Retry Delay bits.
+func (s NVMeStatus) Code() uint16 {
+	return uint16(s) & 0x07ff
+}
+
+// Matches reports whether the status has the specified Status Code Type and Status Code, e.g.
+// NVME_SCT_GENERIC and NVME_SC_NS_NOT_READY.
+func (s NVMeStatus) Matches(sct, sc uint8) bool {
+	return s.SCT() == sct && s.SC() == sc
+}
+
+// DNR reports whether the Do Not Retry bit is set, i.e. the command is expected to fail again if
+// resubmitted.
+func (s NVMeStatus) DNR() bool {
+	return s&0x4000 != 0
+}
+
+// Message returns a description of the status, or an empty string if it is not recognised (e.g.
+// a vendor specific status).
+func (s NVMeStatus) Message() string {
+	return nvmeStatusMessages[s.Code()]
+}
+
+func (s NVMeStatus) Error() string {
+	if msg := s.Message(); msg != "" {
+		return fmt.Sprintf("NVMe status: %s (SCT %#x, SC %#02x)", msg, s.SCT(), s.SC())
+	}
+
+	return fmt.Sprintf("NVMe status: SCT %#x, SC %#02x", s.SCT(), s.SC())
+}
+
+// IsStatus reports whether err is an NVMeStatus with the specified Status Code Type and Status
+// Code.
+func IsStatus(err error, sct, sc uint8) bool {
+	s, ok := err.(NVMeStatus)
+
+	return ok && s.Matches(sct, sc)
+}
@@ -0,0 +1,75 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+// Device interface adapter for SATA devices (via SCSI / ATA Translation).
+
+package smart
+
+import (
+	"fmt"
+
+	"github.com/madper/smart/ata"
+	"github.com/madper/smart/scsi"
+)
+
+// sataDevice adapts a scsi.SATDevice to the Device interface.
+type sataDevice struct {
+	*scsi.SATDevice
+}
+
+func (d *sataDevice) Type() DeviceType {
+	return DEVICE_TYPE_SATA
+}
+
+func (d *sataDevice) Identity() (Identity, error) {
+	ident, err := d.Identify()
+	if err != nil {
+		return Identity{}, err
+	}
+
+	return sataIdentity(&ident), nil
+}
+
+func (d *sataDevice) WWN() (string, error) {
+	ident, err := d.Identify()
+	if err != nil {
+		return "", err
+	}
+
+	return sataWWN(&ident)
+}
+
+func (d *sataDevice) RotationRate() (int, error) {
+	ident, err := d.Identify()
+	if err != nil {
+		return 0, err
+	}
+
+	return sataRotationRate(&ident), nil
+}
+
+func sataIdentity(ident *ata.IdentifyDeviceData) Identity {
+	return Identity{
+		Model:    string(ident.ModelNumber()),
+		Serial:   string(ident.SerialNumber()),
+		Firmware: string(ident.FirmwareRevision()),
+	}
+}
+
+func sataWWN(ident *ata.IdentifyDeviceData) (string, error) {
+	if !ident.WWNSupported() {
+		return "", ErrNotSupported
+	}
+
+	return fmt.Sprintf("0x%016x", ident.WWN64()), nil
+}
+
+// sataRotationRate returns the nominal media rotation rate from IDENTIFY DEVICE word 217. Values
+// 0002h to 0400h and FFFFh are reserved, and reported as not reported.
+func sataRotationRate(ident *ata.IdentifyDeviceData) int {
+	if r := int(ident.RotationRate); r == ROTATION_RATE_NON_ROTATING || (r > 0x0400 && r < 0xffff) {
+		return r
+	}
+
+	return ROTATION_RATE_NOT_REPORTED
+}
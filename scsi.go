@@ -0,0 +1,43 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+// Device interface adapter for SCSI / SAS devices.
+
+package smart
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/madper/smart/scsi"
+)
+
+// scsiDevice adapts a scsi.SCSIDevice to the Device interface.
+type scsiDevice struct {
+	*scsi.SCSIDevice
+}
+
+func (d *scsiDevice) Type() DeviceType {
+	return DEVICE_TYPE_SCSI
+}
+
+// Identity returns the vendor and product identification as the model, and the product revision
+// as the firmware revision. The serial number requires the Unit Serial Number VPD page, which is
+// not yet supported.
+func (d *scsiDevice) Identity() (Identity, error) {
+	inq, err := d.Inquiry()
+	if err != nil {
+		return Identity{}, err
+	}
+
+	return Identity{
+		Model: strings.TrimSpace(fmt.Sprintf("%s %s",
+			strings.TrimSpace(string(inq.VendorIdent[:])), string(inq.ProductIdent[:]))),
+		Firmware: strings.TrimSpace(string(inq.ProductRev[:])),
+	}, nil
+}
+
+// WWN is not yet supported for SCSI devices, since it requires the Device Identification VPD page.
+func (d *scsiDevice) WWN() (string, error) {
+	return "", ErrNotSupported
+}
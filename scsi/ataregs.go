@@ -0,0 +1,146 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+// ATA register return via SCSI / ATA Translation sense data.
+
+package scsi
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/madper/smart/ata"
+)
+
+const (
+	// Sense data response codes
+	senseFixedCurrent       = 0x70
+	senseFixedDeferred      = 0x71
+	senseDescriptorCurrent  = 0x72
+	senseDescriptorDeferred = 0x73
+
+	// ATA Status Return sense data descriptor type
+	senseDescATAReturn = 0x09
+
+	// ATA status register bits
+	ataStatusErr = 0x01
+)
+
+var errNoATARegisters = errors.New("ATA PASS-THROUGH did not return the ATA registers")
+
+// ataRegisters holds the ATA output registers returned in the sense data of an ATA PASS-THROUGH
+// command issued with CK_COND set.
+type ataRegisters struct {
+	Error  uint8
+	Count  uint8
+	LBA    uint32 // LBA bits 23:0
+	Device uint8
+	Status uint8
+}
+
+// decodeATAReturn decodes the ATA output registers from descriptor or fixed format sense data.
+// USB-SATA bridges commonly use the fixed format, whereas most HBAs use the ATA Status Return
+// descriptor.
+func decodeATAReturn(sense []byte) (ataRegisters, bool) {
+	var r ataRegisters
+
+	if len(sense) < 8 {
+		return r, false
+	}
+
+	switch sense[0] & 0x7f {
+	case senseDescriptorCurrent, senseDescriptorDeferred:
+		end := 8 + int(sense[7])
+		if end > len(sense) {
+			end = len(sense)
+		}
+
+		for off := 8; off+2 <= end; off += int(sense[off+1]) + 2 {
+			if sense[off] == senseDescATAReturn && off+14 <= end {
+				d := sense[off:]
+				r.Error = d[3]
+				r.Count = d[5]
+				r.LBA = uint32(d[7]) | uint32(d[9])<<8 | uint32(d[11])<<16
+				r.Device = d[12]
+				r.Status = d[13]
+
+				return r, true
+			}
+		}
+	case senseFixedCurrent, senseFixedDeferred:
+		if len(sense) < 12 {
+			return r, false
+		}
+
+		r.Error = sense[3]
+		r.Status = sense[4]
+		r.Device = sense[5]
+		r.Count = sense[6]
+		r.LBA = uint32(sense[9]) | uint32(sense[10])<<8 | uint32(sense[11])<<16
+
+		return r, true
+	}
+
+	return r, false
+}
+
+// ataCommandRegisters issues a non-data ATA PASS-THROUGH(16) command with CK_COND set, and returns
+// the ATA output registers. The SATL reports these by terminating the command with CHECK
+// CONDITION, which is therefore not treated as a failure unless the ATA status register indicates
+// an error.
+func (d *SATDevice) ataCommandRegisters(cdb CDB16) (ataRegisters, error) {
+	cdb[1] = 0x06 // ATA protocol (3 << 1, non-data)
+	cdb[2] = SAT_CK_COND
+
+	err := d.sendCDBDir(cdb[:], SG_DXFER_NONE, nil, DEFAULT_TIMEOUT)
+	if err == nil {
+		// Some SATLs ignore CK_COND
+		return ataRegisters{}, errNoATARegisters
+	}
+
+	e, ok := err.(sgioError)
+	if !ok || e.scsiStatus != SAM_STAT_CHECK_CONDITION {
+		return ataRegisters{}, err
+	}
+
+	r, ok := decodeATAReturn(e.senseBuf[:e.senseLen])
+	if !ok {
+		return r, err
+	}
+
+	if r.Status&ataStatusErr != 0 {
+		return r, fmt.Errorf("ATA command %#02x failed: status %#02x, error %#02x", cdb[14], r.Status, r.Error)
+	}
+
+	return r, nil
+}
+
+// SMARTStatus issues a SMART RETURN STATUS command and reports whether the device has detected a
+// threshold exceeded condition, i.e. predicts its own failure.
+func (d *SATDevice) SMARTStatus() (failing bool, err error) {
+	cdb := CDB16{SCSI_ATA_PASSTHRU_16}
+	cdb[4] = ata.SMART_RETURN_STATUS // feature LSB
+	cdb[10] = 0x4f                   // low lba_mid
+	cdb[12] = 0xc2                   // low lba_high
+	cdb[14] = ata.ATA_SMART          // command
+
+	r, err := d.ataCommandRegisters(cdb)
+	if err != nil {
+		return false, err
+	}
+
+	return smartStatus(r)
+}
+
+// smartStatus decodes the ATA output registers of a SMART RETURN STATUS command.
+func smartStatus(r ataRegisters) (failing bool, err error) {
+	// LBA mid / high are 4Fh / C2h if no threshold has been exceeded, and F4h / 2Ch otherwise
+	switch r.LBA >> 8 {
+	case 0xc24f:
+		return false, nil
+	case 0x2cf4:
+		return true, nil
+	default:
+		return false, fmt.Errorf("unexpected SMART RETURN STATUS registers: %#06x", r.LBA>>8)
+	}
+}
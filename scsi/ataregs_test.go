@@ -0,0 +1,85 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+package scsi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSMARTStatus(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		name    string
+		sense   []byte
+		ok      bool
+		failing bool
+		err     bool
+	}{
+		{
+			name: "descriptor format, passing",
+			sense: []byte{0x72, 0x01, 0x00, 0x1d, 0x00, 0x00, 0x00, 0x0e,
+				0x09, 0x0c, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x4f, 0x00, 0xc2, 0x00, 0x50},
+			ok: true,
+		},
+		{
+			name: "descriptor format, failing",
+			sense: []byte{0x72, 0x01, 0x00, 0x1d, 0x00, 0x00, 0x00, 0x0e,
+				0x09, 0x0c, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf4, 0x00, 0x2c, 0x00, 0x50},
+			ok:      true,
+			failing: true,
+		},
+		{
+			name: "descriptor format, preceded by another descriptor",
+			sense: []byte{0x72, 0x01, 0x00, 0x1d, 0x00, 0x00, 0x00, 0x1a,
+				0x00, 0x0a, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+				0x09, 0x0c, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x4f, 0x00, 0xc2, 0x00, 0x50},
+			ok: true,
+		},
+		{
+			name:  "descriptor format, no ATA Status Return descriptor",
+			sense: []byte{0x72, 0x05, 0x24, 0x00, 0x00, 0x00, 0x00, 0x00},
+		},
+		{
+			name: "fixed format, passing",
+			sense: []byte{0x70, 0x00, 0x01, 0x00, 0x50, 0x00, 0x00, 0x0a,
+				0x00, 0x00, 0x4f, 0xc2, 0x00, 0x1d, 0x00, 0x00, 0x00, 0x00},
+			ok: true,
+		},
+		{
+			name: "fixed format, failing",
+			sense: []byte{0x70, 0x00, 0x01, 0x00, 0x50, 0x00, 0x00, 0x0a,
+				0x00, 0x00, 0xf4, 0x2c, 0x00, 0x1d, 0x00, 0x00, 0x00, 0x00},
+			ok:      true,
+			failing: true,
+		},
+		{
+			name: "fixed format, unexpected registers",
+			sense: []byte{0x70, 0x00, 0x01, 0x00, 0x50, 0x00, 0x00, 0x0a,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x1d, 0x00, 0x00, 0x00, 0x00},
+			ok:  true,
+			err: true,
+		},
+		{
+			name:  "truncated",
+			sense: []byte{0x70, 0x00, 0x01, 0x00, 0x50, 0x00, 0x00, 0x0a},
+		},
+	}
+
+	for _, tt := range tests {
+		r, ok := decodeATAReturn(tt.sense)
+		assert.Equal(tt.ok, ok, tt.name)
+		if !ok {
+			continue
+		}
+
+		assert.Equal(uint8(0x50), r.Status, tt.name)
+
+		failing, err := smartStatus(r)
+		assert.Equal(tt.err, err != nil, tt.name)
+		assert.Equal(tt.failing, failing, tt.name)
+	}
+}
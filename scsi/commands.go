@@ -14,15 +14,42 @@ const (
 	SCSI_INQUIRY          = 0x12
 	SCSI_MODE_SENSE_6     = 0x1a
 	SCSI_READ_CAPACITY_10 = 0x25
+	SCSI_LOG_SENSE        = 0x4d
 	SCSI_ATA_PASSTHRU_16  = 0x85
 
+	// ATA PASS-THROUGH byte 2 flags
+	SAT_CK_COND = 0x20 // Return the ATA registers in the sense data on completion
+
+	// SCSI status codes
+	SAM_STAT_CHECK_CONDITION = 0x02
+
 	// Minimum length of standard INQUIRY response
 	INQ_REPLY_LEN = 36
 
 	// SCSI-3 mode pages
 	RIGID_DISK_DRIVE_GEOMETRY_PAGE = 0x04
+	INFORMATIONAL_EXCEPTIONS_PAGE  = 0x1c
+
+	// Vital product data pages
+	VPD_BLOCK_DEVICE_CHARACTERISTICS = 0xb1
+
+	// Log pages
+	WRITE_ERROR_COUNTER_LPAGE = 0x02
+	READ_ERROR_COUNTER_LPAGE  = 0x03
+	TEMPERATURE_LPAGE         = 0x0d
+
+	// Temperature log page parameter codes
+	TEMPERATURE_CURRENT   = 0x0000
+	TEMPERATURE_REFERENCE = 0x0001
+
+	// Error counter log page parameter codes
+	ERROR_COUNTER_TOTAL_UNCORRECTED = 0x0006
+
+	// Log page control field
+	LPAGE_CONTROL_CUMULATIVE = 1
 
 	// Mode page control field
+	MPAGE_CONTROL_CURRENT = 0
 	MPAGE_CONTROL_DEFAULT = 2
 )
 
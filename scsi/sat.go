@@ -6,8 +6,6 @@
 package scsi
 
 import (
-	"bytes"
-	"encoding/binary"
 	"errors"
 	"fmt"
 
@@ -16,13 +14,20 @@ import (
 	"github.com/madper/smart/utils"
 )
 
+var (
+	errSMARTNotSupported       = errors.New("device does not support SMART")
+	errTemperatureNotAvailable = errors.New("device does not report its temperature")
+)
+
 // SATDevice is a simple wrapper around an embedded SCSIDevice type, which handles sending ATA
 // commands via SCSI pass-through (SCSI-ATA Translation).
 type SATDevice struct {
 	SCSIDevice
 }
 
-func (d *SATDevice) identify() (ata.IdentifyDeviceData, error) {
+// Identify sends an ATA IDENTIFY DEVICE command to the device via SCSI ATA PASS-THROUGH and
+// returns the decoded response.
+func (d *SATDevice) Identify() (ata.IdentifyDeviceData, error) {
 	var identBuf ata.IdentifyDeviceData
 
 	respBuf := make([]byte, 512)
@@ -36,11 +41,49 @@ func (d *SATDevice) identify() (ata.IdentifyDeviceData, error) {
 		return identBuf, fmt.Errorf("sendCDB ATA IDENTIFY: %v", err)
 	}
 
-	binary.Read(bytes.NewBuffer(respBuf), utils.NativeEndian, &identBuf)
+	if err := utils.DecodeInto(respBuf, &identBuf); err != nil {
+		return identBuf, err
+	}
 
 	return identBuf, nil
 }
 
+// readSMARTData sends a SMART READ DATA command to the device and returns the decoded attributes.
+func (d *SATDevice) readSMARTData() (ata.SmartPage, error) {
+	var smart ata.SmartPage
+
+	respBuf, err := d.readSMARTDataRaw()
+	if err != nil {
+		return smart, err
+	}
+
+	if err := utils.DecodeInto(respBuf, &smart); err != nil {
+		return smart, err
+	}
+
+	return smart, nil
+}
+
+// readSMARTDataRaw sends a SMART READ DATA command to the device and returns the undecoded
+// response.
+func (d *SATDevice) readSMARTDataRaw() ([]byte, error) {
+	cdb := CDB16{SCSI_ATA_PASSTHRU_16}
+	cdb[1] = 0x08                // ATA protocol (4 << 1, PIO data-in)
+	cdb[2] = 0x0e                // BYT_BLOK = 1, T_LENGTH = 2, T_DIR = 1
+	cdb[4] = ata.SMART_READ_DATA // feature LSB
+	cdb[10] = 0x4f               // low lba_mid
+	cdb[12] = 0xc2               // low lba_high
+	cdb[14] = ata.ATA_SMART      // command
+
+	respBuf := make([]byte, 512)
+
+	if err := d.sendCDB(cdb[:], &respBuf); err != nil {
+		return nil, fmt.Errorf("sendCDB SMART READ DATA: %v", err)
+	}
+
+	return respBuf, nil
+}
+
 // Read SMART log page (WIP / experimental)
 func (d *SATDevice) readSMARTLog(logPage uint8) ([]byte, error) {
 	respBuf := make([]byte, 512)
@@ -62,16 +105,27 @@ func (d *SATDevice) readSMARTLog(logPage uint8) ([]byte, error) {
 	return respBuf, nil
 }
 
+// SupportsSMART reports whether the device supports the SMART feature set, according to its ATA
+// IDENTIFY DEVICE data.
+func (d *SATDevice) SupportsSMART() (bool, error) {
+	identBuf, err := d.Identify()
+	if err != nil {
+		return false, err
+	}
+
+	return identBuf.SMARTSupported(), nil
+}
+
 func (d *SATDevice) PrintSMART(db *drivedb.DriveDb) error {
 	// Standard SCSI INQUIRY command
-	inqResp, err := d.inquiry()
+	inqResp, err := d.Inquiry()
 	if err != nil {
 		return fmt.Errorf("SgExecute INQUIRY: %v", err)
 	}
 
 	fmt.Println("SCSI INQUIRY:", inqResp)
 
-	identBuf, err := d.identify()
+	identBuf, err := d.Identify()
 	if err != nil {
 		return err
 	}
@@ -81,9 +135,10 @@ func (d *SATDevice) PrintSMART(db *drivedb.DriveDb) error {
 	fmt.Println("LU WWN Device Id:", identBuf.WWN())
 	fmt.Printf("Firmware Revision: %s\n", identBuf.FirmwareRevision())
 	fmt.Printf("Model Number: %s\n", identBuf.ModelNumber())
+	fmt.Printf("User Capacity: %d bytes (%s)\n", identBuf.Capacity(), utils.FormatBytes(identBuf.Capacity()))
 	fmt.Printf("Rotation Rate: %d\n", identBuf.RotationRate)
-	fmt.Printf("SMART support available: %v\n", identBuf.Word87>>14 == 1)
-	fmt.Printf("SMART support enabled: %v\n", identBuf.Word85&0x1 != 0)
+	fmt.Printf("SMART support available: %v\n", identBuf.SMARTSupported())
+	fmt.Printf("SMART support enabled: %v\n", identBuf.SMARTEnabled())
 	fmt.Println("ATA Major Version:", identBuf.ATAMajorVersion())
 	fmt.Println("ATA Minor Version:", identBuf.ATAMinorVersion())
 	fmt.Println("Transport:", identBuf.Transport())
@@ -91,27 +146,15 @@ func (d *SATDevice) PrintSMART(db *drivedb.DriveDb) error {
 	thisDrive := db.LookupDrive(identBuf.ModelNumber())
 	fmt.Printf("Drive DB contains %d entries. Using model: %s\n", len(db.Drives), thisDrive.Family)
 
-	// FIXME: Check that device supports SMART before trying to read data page
-
-	/*
-	 * SMART READ DATA
-	 */
-	cdb := CDB16{SCSI_ATA_PASSTHRU_16}
-	cdb[1] = 0x08                // ATA protocol (4 << 1, PIO data-in)
-	cdb[2] = 0x0e                // BYT_BLOK = 1, T_LENGTH = 2, T_DIR = 1
-	cdb[4] = ata.SMART_READ_DATA // feature LSB
-	cdb[10] = 0x4f               // low lba_mid
-	cdb[12] = 0xc2               // low lba_high
-	cdb[14] = ata.ATA_SMART      // command
-
-	respBuf := make([]byte, 512)
+	if !identBuf.SMARTSupported() {
+		return errSMARTNotSupported
+	}
 
-	if err := d.sendCDB(cdb[:], &respBuf); err != nil {
-		return fmt.Errorf("sendCDB SMART READ DATA: %v", err)
+	smart, err := d.readSMARTData()
+	if err != nil {
+		return err
 	}
 
-	smart := ata.SmartPage{}
-	binary.Read(bytes.NewBuffer(respBuf[:362]), utils.NativeEndian, &smart)
 	ata.PrintSMARTPage(smart, thisDrive)
 
 	// Read SMART log directory
@@ -121,7 +164,9 @@ func (d *SATDevice) PrintSMART(db *drivedb.DriveDb) error {
 	}
 
 	smartLogDir := ata.SmartLogDirectory{}
-	binary.Read(bytes.NewBuffer(logBuf), utils.NativeEndian, &smartLogDir)
+	if err := utils.DecodeInto(logBuf, &smartLogDir); err != nil {
+		return err
+	}
 	fmt.Printf("\nSMART log directory: %+v\n", smartLogDir)
 
 	// Read SMART error log
@@ -131,7 +176,9 @@ func (d *SATDevice) PrintSMART(db *drivedb.DriveDb) error {
 	}
 
 	sumErrLog := ata.SmartSummaryErrorLog{}
-	binary.Read(bytes.NewBuffer(logBuf), utils.NativeEndian, &sumErrLog)
+	if err := utils.DecodeInto(logBuf, &sumErrLog); err != nil {
+		return err
+	}
 	fmt.Printf("\nSummary SMART error log: %+v\n", sumErrLog)
 
 	// Read SMART self-test log
@@ -141,7 +188,9 @@ func (d *SATDevice) PrintSMART(db *drivedb.DriveDb) error {
 	}
 
 	selfTestLog := ata.SmartSelfTestLog{}
-	binary.Read(bytes.NewBuffer(logBuf), utils.NativeEndian, &selfTestLog)
+	if err := utils.DecodeInto(logBuf, &selfTestLog); err != nil {
+		return err
+	}
 	fmt.Printf("\nSMART self-test log: %+v\n", selfTestLog)
 
 	return nil
@@ -149,38 +198,51 @@ func (d *SATDevice) PrintSMART(db *drivedb.DriveDb) error {
 
 func (d *SATDevice) GetTemp(db *drivedb.DriveDb) (string, error) {
 	// Standard SCSI INQUIRY command
-	_, err := d.inquiry()
+	_, err := d.Inquiry()
 	if err != nil {
 		return "", errors.New(fmt.Sprintf("SgExecute INQUIRY: %v", err))
 	}
 
-	identBuf, err := d.identify()
+	identBuf, err := d.Identify()
 	if err != nil {
 		return "", err
 	}
 
 	thisDrive := db.LookupDrive(identBuf.ModelNumber())
 
-	// FIXME: Check that device supports SMART before trying to read data page
+	if !identBuf.SMARTSupported() {
+		return "", errSMARTNotSupported
+	}
 
-	/*
-	 * SMART READ DATA
-	 */
-	cdb := CDB16{SCSI_ATA_PASSTHRU_16}
-	cdb[1] = 0x08                // ATA protocol (4 << 1, PIO data-in)
-	cdb[2] = 0x0e                // BYT_BLOK = 1, T_LENGTH = 2, T_DIR = 1
-	cdb[4] = ata.SMART_READ_DATA // feature LSB
-	cdb[10] = 0x4f               // low lba_mid
-	cdb[12] = 0xc2               // low lba_high
-	cdb[14] = ata.ATA_SMART      // command
+	smart, err := d.readSMARTData()
+	if err != nil {
+		return "", err
+	}
 
-	respBuf := make([]byte, 512)
+	return ata.GetTempRaw(smart, thisDrive)
+}
 
-	if err := d.sendCDB(cdb[:], &respBuf); err != nil {
-		return "", errors.New(fmt.Sprintf("sendCDB SMART READ DATA: %v", err))
+// MediaErrorCount returns the number of reallocated and pending sectors, as reported by the
+// drive's SMART attributes.
+func (d *SATDevice) MediaErrorCount() (uint64, error) {
+	smart, err := d.readSMARTData()
+	if err != nil {
+		return 0, err
 	}
 
-	smart := ata.SmartPage{}
-	binary.Read(bytes.NewBuffer(respBuf[:362]), utils.NativeEndian, &smart)
-	return ata.GetTempRaw(smart, thisDrive)
+	return smart.MediaErrorCount(), nil
+}
+
+// Temperature returns the drive temperature, as reported by its SMART attributes.
+func (d *SATDevice) Temperature() (utils.Temperature, error) {
+	respBuf, err := d.readSMARTDataRaw()
+	if err != nil {
+		return 0, err
+	}
+
+	if t, ok := ata.SMARTDataTemperature(respBuf); ok {
+		return t, nil
+	}
+
+	return 0, errTemperatureNotAvailable
 }
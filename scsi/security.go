@@ -0,0 +1,142 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+// ATA Security feature set functions via SCSI / ATA Translation.
+
+package scsi
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/madper/smart/ata"
+)
+
+var (
+	errSecurityNotSupported      = errors.New("device does not support the ATA Security feature set")
+	errSecurityEnabled           = errors.New("device security is already enabled")
+	errSecurityFrozen            = errors.New("device security is frozen")
+	errSecurityLocked            = errors.New("device security is locked")
+	errSecurityCountExpired      = errors.New("device security password attempt counter expired")
+	errEnhancedEraseNotSupported = errors.New("device does not support enhanced security erase")
+	errPasswordTooLong           = fmt.Errorf("password exceeds %d bytes", ata.SECURITY_PASSWORD_LEN)
+)
+
+// Added to the device's own erase time estimate when computing the SECURITY ERASE UNIT timeout
+const securityEraseMargin = 30 * time.Minute
+
+// SecurityErase wipes the device with the ATA SECURITY ERASE UNIT command. The user password is
+// first set to password, which the device clears again on successful completion of the erase. If
+// enhanced is true, the enhanced erase mode is used, which also overwrites reallocated sectors.
+// The call blocks until the erase has completed, which may take several hours on rotational
+// drives. Devices which already have a user password set are refused. If the erase fails after
+// the password has been set, SECURITY DISABLE PASSWORD is sent to remove it again.
+func (d *SATDevice) SecurityErase(password string, enhanced bool) error {
+	if len(password) > ata.SECURITY_PASSWORD_LEN {
+		return errPasswordTooLong
+	}
+
+	ident, err := d.Identify()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case !ident.SecuritySupported():
+		return errSecurityNotSupported
+	case ident.SecurityEnabled():
+		return errSecurityEnabled
+	case ident.SecurityFrozen():
+		return errSecurityFrozen
+	case ident.SecurityLocked():
+		return errSecurityLocked
+	case ident.SecurityCountExpired():
+		return errSecurityCountExpired
+	case enhanced && !ident.EnhancedEraseSupported():
+		return errEnhancedEraseNotSupported
+	}
+
+	// Word 0 of the SECURITY SET PASSWORD data is zero, i.e. set the user password with high
+	// security. The password occupies words 1..16.
+	if err := d.securityDataOut(ata.ATA_SECURITY_SET_PASSWORD, securityPasswordData(password),
+		DEFAULT_TIMEOUT); err != nil {
+		return fmt.Errorf("sendCDB SECURITY SET PASSWORD: %v", err)
+	}
+
+	if err := d.securityErase(password, enhanced, &ident); err != nil {
+		// Don't leave the device with a password set that the caller may not know to clear
+		perr := d.securityDataOut(ata.ATA_SECURITY_DISABLE_PASSWORD, securityPasswordData(password),
+			DEFAULT_TIMEOUT)
+		if perr != nil {
+			return fmt.Errorf("%v (sendCDB SECURITY DISABLE PASSWORD: %v; user password may still be set)",
+				err, perr)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// securityErase sends SECURITY ERASE PREPARE and SECURITY ERASE UNIT, once the user password has
+// been set to password.
+func (d *SATDevice) securityErase(password string, enhanced bool, ident *ata.IdentifyDeviceData) error {
+	// SECURITY ERASE PREPARE must immediately precede SECURITY ERASE UNIT
+	cdb := CDB16{SCSI_ATA_PASSTHRU_16}
+	cdb[1] = 0x06 // ATA protocol (3 << 1, non-data)
+	cdb[14] = ata.ATA_SECURITY_ERASE_PREPARE
+
+	if err := d.sendCDBDir(cdb[:], SG_DXFER_NONE, nil, DEFAULT_TIMEOUT); err != nil {
+		return fmt.Errorf("sendCDB SECURITY ERASE PREPARE: %v", err)
+	}
+
+	// Word 0 bit 0 selects the user password, bit 1 the enhanced erase mode
+	buf := securityPasswordData(password)
+	if enhanced {
+		buf[0] = 0x02
+	}
+
+	// If the device does not report an estimate, wait indefinitely
+	var timeout time.Duration
+	if est := ident.SecurityEraseTime(enhanced); est != 0 {
+		timeout = est + securityEraseMargin
+	}
+
+	if err := d.securityDataOut(ata.ATA_SECURITY_ERASE_UNIT, buf, sgioTimeout(timeout)); err != nil {
+		return fmt.Errorf("sendCDB SECURITY ERASE UNIT: %v", err)
+	}
+
+	return nil
+}
+
+// securityPasswordData returns the 512-byte parameter data of an ATA Security command, with word 0
+// zero (i.e. the user password) and the password in words 1..16.
+func securityPasswordData(password string) []byte {
+	buf := make([]byte, 512)
+	copy(buf[2:], password)
+
+	return buf
+}
+
+// securityDataOut sends an ATA Security command which transfers a single 512-byte sector of
+// parameters to the device.
+func (d *SATDevice) securityDataOut(command uint8, buf []byte, timeout uint32) error {
+	cdb := CDB16{SCSI_ATA_PASSTHRU_16}
+	cdb[1] = 0x0a // ATA protocol (5 << 1, PIO data-out)
+	cdb[2] = 0x06 // BYT_BLOK = 1, T_LENGTH = 2, T_DIR = 0
+	cdb[6] = 0x01 // sector count
+	cdb[14] = command
+
+	return d.sendCDBDir(cdb[:], SG_DXFER_TO_DEV, buf, timeout)
+}
+
+// sgioTimeout converts a duration to an SG_IO timeout in milliseconds, where zero means no
+// timeout.
+func sgioTimeout(t time.Duration) uint32 {
+	if t == 0 || t/time.Millisecond > 0xffffffff {
+		return 0xffffffff
+	}
+
+	return uint32(t / time.Millisecond)
+}
@@ -6,13 +6,14 @@
 package scsi
 
 import (
-	"bytes"
 	"encoding/binary"
 	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"unsafe"
 
-	"golang.org/x/sys/unix"
-
+	"github.com/madper/smart/ata"
 	"github.com/madper/smart/drivedb"
 	"github.com/madper/smart/ioctl"
 	"github.com/madper/smart/utils"
@@ -63,7 +64,8 @@ type sgioError struct {
 	scsiStatus   uint8
 	hostStatus   uint16
 	driverStatus uint16
-	senseBuf     [32]byte // FIXME: This is not yet populated by anything
+	senseBuf     [32]byte
+	senseLen     uint8 // Number of valid bytes in senseBuf
 }
 
 func (e sgioError) Error() string {
@@ -81,16 +83,18 @@ type Device interface {
 // TODO: Make a constructor function for this.
 type SCSIDevice struct {
 	Name string
-	fd   int
-}
 
-func (d *SCSIDevice) Open() (err error) {
-	d.fd, err = unix.Open(d.Name, unix.O_RDWR, 0600)
-	return err
-}
+	// NonBlock opens the device node with O_NONBLOCK, so that the open itself does not hang on a
+	// faulty device or one without media. SG_IO commands always block until completion regardless.
+	NonBlock bool
 
-func (d *SCSIDevice) Close() error {
-	return unix.Close(d.fd)
+	// ReadOnly opens the device node with O_RDONLY instead of O_RDWR, so that only read access to
+	// it is required. The kernel's SG_IO command filter then only permits CDBs it considers safe
+	// for reading, e.g. INQUIRY and LOG SENSE, unless the caller has CAP_SYS_RAWIO. Notably, ATA
+	// PASS-THROUGH is not among them.
+	ReadOnly bool
+
+	fd int
 }
 
 func (d *SCSIDevice) execGenericIO(hdr *sgIoHdr) error {
@@ -111,9 +115,9 @@ func (d *SCSIDevice) execGenericIO(hdr *sgIoHdr) error {
 	return nil
 }
 
-// inquiry sends a SCSI INQUIRY command to a device and returns an InquiryResponse struct.
+// Inquiry sends a SCSI INQUIRY command to a device and returns an InquiryResponse struct.
 // TODO: Add support for Vital Product Data (VPD)
-func (d *SCSIDevice) inquiry() (InquiryResponse, error) {
+func (d *SCSIDevice) Inquiry() (InquiryResponse, error) {
 	var resp InquiryResponse
 
 	respBuf := make([]byte, INQ_REPLY_LEN)
@@ -125,31 +129,77 @@ func (d *SCSIDevice) inquiry() (InquiryResponse, error) {
 		return resp, err
 	}
 
-	binary.Read(bytes.NewBuffer(respBuf), utils.NativeEndian, &resp)
+	if err := utils.DecodeInto(respBuf, &resp); err != nil {
+		return resp, err
+	}
 
 	return resp, nil
 }
 
+// inquiryVPD sends a SCSI INQUIRY command for the specified vital product data page, and returns
+// the page.
+func (d *SCSIDevice) inquiryVPD(page uint8) ([]byte, error) {
+	respBuf := make([]byte, 252)
+
+	cdb := CDB6{SCSI_INQUIRY}
+	cdb[1] = 0x01 // EVPD
+	cdb[2] = page
+	binary.BigEndian.PutUint16(cdb[3:], uint16(len(respBuf)))
+
+	if err := d.sendCDB(cdb[:], &respBuf); err != nil {
+		return nil, err
+	}
+
+	if respBuf[1] != page {
+		return nil, fmt.Errorf("INQUIRY returned VPD page %#02x, expected %#02x", respBuf[1], page)
+	}
+
+	return respBuf, nil
+}
+
 // sendCDB sends a SCSI Command Descriptor Block to the device and writes the response into the
 // supplied []byte pointer.
 // TODO: Return SCSI status code, sense buf etc as part of error
 func (d *SCSIDevice) sendCDB(cdb []byte, respBuf *[]byte) error {
+	return d.sendCDBDir(cdb, SG_DXFER_FROM_DEV, *respBuf, DEFAULT_TIMEOUT)
+}
+
+// sendCDBDir sends a SCSI Command Descriptor Block to the device, transferring buf in the
+// specified direction (one of the SG_DXFER_* constants). buf may be empty for SG_DXFER_NONE. The
+// timeout is in milliseconds.
+func (d *SCSIDevice) sendCDBDir(cdb []byte, direction int32, buf []byte, timeout uint32) error {
 	senseBuf := make([]byte, 32)
 
 	// Populate required fields of "sg_io_hdr_t" struct
 	hdr := sgIoHdr{
 		interface_id:    'S',
-		dxfer_direction: SG_DXFER_FROM_DEV,
-		timeout:         DEFAULT_TIMEOUT,
+		dxfer_direction: direction,
+		timeout:         timeout,
 		cmd_len:         uint8(len(cdb)),
 		mx_sb_len:       uint8(len(senseBuf)),
-		dxfer_len:       uint32(len(*respBuf)),
-		dxferp:          uintptr(unsafe.Pointer(&(*respBuf)[0])),
+		dxfer_len:       uint32(len(buf)),
 		cmdp:            uintptr(unsafe.Pointer(&cdb[0])),
 		sbp:             uintptr(unsafe.Pointer(&senseBuf[0])),
 	}
 
-	return d.execGenericIO(&hdr)
+	if len(buf) > 0 {
+		hdr.dxferp = uintptr(unsafe.Pointer(&buf[0]))
+	}
+
+	err := d.execGenericIO(&hdr)
+
+	// The kernel only sees the buffers via the addresses stored in hdr, so keep them alive until
+	// the ioctl has returned
+	runtime.KeepAlive(cdb)
+	runtime.KeepAlive(buf)
+	runtime.KeepAlive(senseBuf)
+
+	if e, ok := err.(sgioError); ok {
+		e.senseLen = uint8(copy(e.senseBuf[:], senseBuf[:hdr.sb_len_wr]))
+		err = e
+	}
+
+	return err
 }
 
 // modeSense sends a SCSI MODE SENSE(6) command to a device.
@@ -168,6 +218,21 @@ func (d *SCSIDevice) modeSense(pageNum, subPageNum, pageControl uint8) ([]byte,
 	return respBuf, nil
 }
 
+// logSense sends a SCSI LOG SENSE command to a device and returns the log page.
+func (d *SCSIDevice) logSense(pageNum, pageControl uint8) ([]byte, error) {
+	respBuf := make([]byte, 252)
+
+	cdb := CDB10{SCSI_LOG_SENSE}
+	cdb[2] = (pageControl << 6) | (pageNum & 0x3f)
+	binary.BigEndian.PutUint16(cdb[7:], uint16(len(respBuf)))
+
+	if err := d.sendCDB(cdb[:], &respBuf); err != nil {
+		return respBuf, err
+	}
+
+	return respBuf, nil
+}
+
 // readCapacity sends a SCSI READ CAPACITY(10) command to a device and returns the capacity in bytes.
 func (d *SCSIDevice) readCapacity() (uint64, error) {
 	respBuf := make([]byte, 8)
@@ -184,6 +249,118 @@ func (d *SCSIDevice) readCapacity() (uint64, error) {
 	return capacity, nil
 }
 
+// SupportsSMART reports whether the device supports the Informational Exceptions mode page, which
+// SCSI devices use to report SMART failure predictions. A device which rejects the MODE SENSE
+// command is assumed not to support it.
+func (d *SCSIDevice) SupportsSMART() (bool, error) {
+	resp, err := d.modeSense(INFORMATIONAL_EXCEPTIONS_PAGE, 0, MPAGE_CONTROL_CURRENT)
+	if err != nil {
+		if _, ok := err.(sgioError); ok {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	offset := int(resp[3]) + 4 // mode parameter header + block descriptors
+
+	// The mode data length reported by the device is not truncated to the allocation length, so
+	// long block descriptors may place the page beyond the response buffer
+	if offset >= int(resp[0])+1 || offset >= len(resp) {
+		return false, nil
+	}
+
+	return resp[offset]&0x3f == INFORMATIONAL_EXCEPTIONS_PAGE, nil
+}
+
+// Temperature returns the current temperature reported in the Temperature log page.
+func (d *SCSIDevice) Temperature() (utils.Temperature, error) {
+	return d.temperatureParameter(TEMPERATURE_CURRENT)
+}
+
+// ReferenceTemperature returns the maximum temperature at which the device is rated to operate
+// continuously, as reported in the Temperature log page.
+func (d *SCSIDevice) ReferenceTemperature() (utils.Temperature, error) {
+	return d.temperatureParameter(TEMPERATURE_REFERENCE)
+}
+
+// temperatureParameter returns the temperature held in the specified parameter of the Temperature
+// log page.
+func (d *SCSIDevice) temperatureParameter(code uint16) (utils.Temperature, error) {
+	resp, err := d.logSense(TEMPERATURE_LPAGE, LPAGE_CONTROL_CUMULATIVE)
+	if err != nil {
+		return 0, err
+	}
+
+	// 0xff indicates that the temperature is not available
+	if v := logParameter(resp, code); len(v) >= 2 && v[1] != 0xff {
+		return utils.TemperatureFromCelsius(float64(v[1])), nil
+	}
+
+	return 0, errTemperatureNotAvailable
+}
+
+// MediaErrorCount returns the total number of uncorrected read and write errors, as reported in
+// the Read and Write Error Counter log pages.
+func (d *SCSIDevice) MediaErrorCount() (uint64, error) {
+	var count uint64
+
+	for _, page := range []uint8{READ_ERROR_COUNTER_LPAGE, WRITE_ERROR_COUNTER_LPAGE} {
+		resp, err := d.logSense(page, LPAGE_CONTROL_CUMULATIVE)
+		if err != nil {
+			return 0, err
+		}
+
+		// Counters are big-endian and of variable length, normally four or eight bytes
+		var n uint64
+		for _, b := range logParameter(resp, ERROR_COUNTER_TOTAL_UNCORRECTED) {
+			n = n<<8 | uint64(b)
+		}
+
+		count += n
+	}
+
+	return count, nil
+}
+
+// logParameter returns the value of the log parameter with the specified parameter code in a log
+// page, or nil if the page does not contain it.
+func logParameter(page []byte, code uint16) []byte {
+	pageLen := int(binary.BigEndian.Uint16(page[2:])) + 4
+	if pageLen > len(page) {
+		pageLen = len(page)
+	}
+
+	for off := 4; off+4 <= pageLen; off += int(page[off+3]) + 4 {
+		if binary.BigEndian.Uint16(page[off:]) == code {
+			end := off + 4 + int(page[off+3])
+			if end > pageLen {
+				end = pageLen
+			}
+
+			return page[off+4 : end]
+		}
+	}
+
+	return nil
+}
+
+// RotationRate returns the nominal medium rotation rate in RPM from the Block Device
+// Characteristics VPD page, 1 for a non-rotating medium, or 0 if the device does not report it.
+func (d *SCSIDevice) RotationRate() (int, error) {
+	resp, err := d.inquiryVPD(VPD_BLOCK_DEVICE_CHARACTERISTICS)
+	if err != nil {
+		// Devices which do not implement the page reject the command
+		if _, ok := err.(sgioError); ok {
+			return 0, nil
+		}
+
+		return 0, err
+	}
+
+	return int(binary.BigEndian.Uint16(resp[4:])), nil
+}
+
 // Regular SCSI (including SAS, but excluding SATA) SMART functions not yet fully implemented.
 func (d *SCSIDevice) PrintSMART(db *drivedb.DriveDb) error {
 	capacity, _ := d.readCapacity()
@@ -206,27 +383,62 @@ func (d *SCSIDevice) PrintSMART(db *drivedb.DriveDb) error {
 }
 
 func OpenSCSIAutodetect(name string) (Device, error) {
-	dev := SCSIDevice{Name: name}
+	return OpenSCSIDeviceAutodetect(SCSIDevice{Name: name})
+}
 
+// OpenSCSIDeviceAutodetect opens dev, honouring its options, and returns it wrapped as a
+// SATDevice if it is an ATA device.
+func OpenSCSIDeviceAutodetect(dev SCSIDevice) (Device, error) {
 	if err := dev.Open(); err != nil {
 		return nil, err
 	}
 
-	inquiry, err := dev.inquiry()
+	inquiry, err := dev.Inquiry()
 	if err != nil {
 		return nil, err
 	}
 
 	// Check if device is an ATA device.
-	// TODO: Handle USB-SATA bridges by probing the device with an ATA IDENTIFY command. Watch out
-	// for ATAPI devices.
 	if inquiry.VendorIdent == [8]byte{0x41, 0x54, 0x41, 0x20, 0x20, 0x20, 0x20, 0x20} {
 		return &SATDevice{dev}, nil
 	}
 
+	// USB-SATA bridges report the vendor of the bridge or enclosure in the INQUIRY response, so
+	// probe them with an ATA IDENTIFY DEVICE command via SAT instead. Bridges which do not
+	// implement SAT reject the command, in which case the device is treated as plain SCSI.
+	if isUSBAttached(dev.Name) {
+		sat := SATDevice{dev}
+
+		if ident, err := sat.Identify(); err == nil && isATAIdentify(&ident) {
+			return &sat, nil
+		}
+	}
+
 	return &dev, nil
 }
 
+// isUSBAttached reports whether the named SCSI block or generic device is attached via USB,
+// according to its sysfs device path.
+func isUSBAttached(name string) bool {
+	base := filepath.Base(name)
+
+	for _, class := range []string{"block", "scsi_generic"} {
+		p, err := filepath.EvalSymlinks(filepath.Join("/sys/class", class, base, "device"))
+		if err == nil {
+			return strings.Contains(p, "/usb")
+		}
+	}
+
+	return false
+}
+
+// isATAIdentify reports whether an IDENTIFY DEVICE response is plausibly that of an ATA device.
+// ATAPI devices (e.g. optical drives in USB enclosures) set bit 15 of word 0, and bridges which
+// silently ignore the command return an empty buffer.
+func isATAIdentify(ident *ata.IdentifyDeviceData) bool {
+	return ident.GeneralConfig&0x8000 == 0 && len(ident.ModelNumber()) > 0
+}
+
 func OpenSCSISAT(name string) (*SATDevice, error) {
 	dev := SCSIDevice{Name: name}
 
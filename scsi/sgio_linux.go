@@ -0,0 +1,28 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+//go:build linux
+
+package scsi
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+func (d *SCSIDevice) Open() (err error) {
+	flags := unix.O_RDWR
+	if d.ReadOnly {
+		flags = unix.O_RDONLY
+	}
+
+	if d.NonBlock {
+		flags |= unix.O_NONBLOCK
+	}
+
+	d.fd, err = unix.Open(d.Name, flags, 0600)
+	return err
+}
+
+func (d *SCSIDevice) Close() error {
+	return unix.Close(d.fd)
+}
@@ -0,0 +1,19 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+//go:build !linux
+
+package scsi
+
+import (
+	"github.com/madper/smart/ioctl"
+)
+
+// Open is not supported on this platform and always returns ioctl.ErrUnsupportedPlatform.
+func (d *SCSIDevice) Open() error {
+	return ioctl.ErrUnsupportedPlatform
+}
+
+func (d *SCSIDevice) Close() error {
+	return ioctl.ErrUnsupportedPlatform
+}
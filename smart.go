@@ -6,11 +6,135 @@
 package smart
 
 import (
+	"fmt"
 	"path/filepath"
+	"regexp"
+	"strings"
 
+	"github.com/madper/smart/megaraid"
 	"github.com/madper/smart/scsi"
 )
 
+var (
+	// DefaultScanPatterns are the glob patterns used by Scan if none are specified, which match
+	// all NVMe controllers and SCSI disks (including SATA disks attached via libata).
+	DefaultScanPatterns = []string{"/dev/nvme*", "/dev/sd*[^0-9]"}
+
+	// nvmeDeviceRE matches NVMe controller, namespace and partition device names, capturing the
+	// name of the controller.
+	nvmeDeviceRE = regexp.MustCompile(`^(nvme[0-9]+)(n[0-9]+(p[0-9]+)?)?$`)
+
+	// megaraidNameRE matches the names of MegaRAID physical disks returned by Scan.
+	megaraidNameRE = regexp.MustCompile(`^megaraid[0-9]+_[0-9]+$`)
+)
+
+// ScanOptions controls which devices are returned by Scan.
+type ScanOptions struct {
+	// Patterns are glob patterns of device paths, e.g. "/dev/nvme*". DefaultScanPatterns is used
+	// if empty.
+	Patterns []string
+
+	// IncludeMegaRAID additionally returns the physical disks attached to MegaRAID controllers,
+	// named as "megaraid<host>_<device id>". These names are not device paths, and cannot be
+	// opened with Open; they must be queried with the megaraid package instead. Batch functions
+	// such as InventoryReportWithOptions therefore skip them.
+	IncludeMegaRAID bool
+
+	// Retries is the number of times a failed MegaRAID physical disk list query is retried.
+	Retries int
+
+	// Concurrency is the maximum number of devices queried concurrently by batch functions such as
+	// InventoryReportWithOptions. A default of 8 is used if zero.
+	Concurrency int
+
+	// MaxRate is the maximum number of device queries started per second by batch functions, which
+	// avoids saturating a shared SAS link when many drives sit behind one expander. Zero means
+	// unlimited.
+	MaxRate float64
+}
+
+// Scan returns the paths of the devices in the system selected by opts. Matching NVMe namespace
+// and partition paths are reduced to their controller devices (e.g. /dev/nvme0n1 to /dev/nvme0),
+// since they would otherwise cause the same controller to be reported several times. Other NVMe
+// names, such as /dev/nvme-fabrics, are skipped.
+func Scan(opts ScanOptions) ([]string, error) {
+	var paths []string
+
+	patterns := opts.Patterns
+	if len(patterns) == 0 {
+		patterns = DefaultScanPatterns
+	}
+
+	seen := make(map[string]bool)
+
+	for _, pattern := range patterns {
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range files {
+			base := filepath.Base(file)
+			if strings.HasPrefix(base, "nvme") {
+				m := nvmeDeviceRE.FindStringSubmatch(base)
+				if m == nil {
+					continue
+				}
+
+				file = filepath.Join(filepath.Dir(file), m[1])
+			}
+
+			if !seen[file] {
+				seen[file] = true
+				paths = append(paths, file)
+			}
+		}
+	}
+
+	if opts.IncludeMegaRAID {
+		paths = append(paths, scanMegaRAID(opts.Retries)...)
+	}
+
+	return paths, nil
+}
+
+// isMegaRAIDName reports whether name is a MegaRAID physical disk name as returned by Scan.
+func isMegaRAIDName(name string) bool {
+	return megaraidNameRE.MatchString(name)
+}
+
+// scanMegaRAID returns the names of the disks attached to all MegaRAID controllers. A system
+// without MegaRAID controllers simply yields no disks.
+func scanMegaRAID(retries int) []string {
+	var names []string
+
+	m, err := megaraid.CreateMegasasIoctl()
+	if err != nil {
+		return nil
+	}
+
+	defer m.Close()
+
+	hosts, _ := m.ScanHosts()
+	for _, host := range hosts {
+		var devices []megaraid.MegasasPDAddress
+
+		for attempt := 0; attempt <= retries; attempt++ {
+			if devices, err = m.GetPDList(host); err == nil {
+				break
+			}
+		}
+
+		for _, pd := range devices {
+			if pd.SCSIDevType == 0 { // SCSI disk
+				names = append(names, fmt.Sprintf("megaraid%d_%d", host, pd.DeviceId))
+			}
+		}
+	}
+
+	return names
+}
+
 // TODO: Make this discover NVMe and MegaRAID devices also.
 func ScanDevices() []scsi.SCSIDevice {
 	var devices []scsi.SCSIDevice
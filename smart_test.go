@@ -0,0 +1,25 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+package smart
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScan(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	for _, name := range []string{"nvme0", "nvme0n1", "nvme0n1p1", "nvme1n1", "nvme-fabrics"} {
+		assert.NoError(os.WriteFile(filepath.Join(dir, name), nil, 0600))
+	}
+
+	paths, err := Scan(ScanOptions{Patterns: []string{filepath.Join(dir, "nvme*")}})
+	assert.NoError(err)
+	assert.Equal([]string{filepath.Join(dir, "nvme0"), filepath.Join(dir, "nvme1")}, paths)
+}
@@ -0,0 +1,39 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+// Temperature type with unit conversions.
+
+package utils
+
+import (
+	"fmt"
+)
+
+// Temperature is a temperature in Kelvin, the unit reported natively by NVMe devices. ATA and SCSI
+// devices report whole degrees Celsius, which are converted with TemperatureFromCelsius.
+type Temperature float64
+
+// TemperatureFromCelsius returns the Temperature corresponding to c degrees Celsius.
+func TemperatureFromCelsius(c float64) Temperature {
+	return Temperature(c + 273.15)
+}
+
+// Kelvin returns the temperature in Kelvin.
+func (t Temperature) Kelvin() float64 {
+	return float64(t)
+}
+
+// Celsius returns the temperature in degrees Celsius.
+func (t Temperature) Celsius() float64 {
+	return float64(t) - 273.15
+}
+
+// Fahrenheit returns the temperature in degrees Fahrenheit.
+func (t Temperature) Fahrenheit() float64 {
+	return t.Celsius()*9/5 + 32
+}
+
+// String returns the temperature in whole degrees Celsius, e.g. "37 Celsius".
+func (t Temperature) String() string {
+	return fmt.Sprintf("%.0f Celsius", t.Celsius())
+}
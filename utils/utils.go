@@ -6,10 +6,12 @@
 package utils
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"math/big"
 	"math/bits"
+	"strings"
 	"unsafe"
 )
 
@@ -83,3 +85,76 @@ func Log2b(x uint) int {
 
 	return bits.Len(x) - 1
 }
+
+// DecodeInto decodes the leading bytes of buf into v, which must be a pointer to a fixed-size
+// value (or a slice of them), in native byte order. Unlike a bare binary.Read, an error is
+// returned if buf is too short to hold all of v, rather than silently leaving the trailing fields
+// of v partially decoded. Bytes beyond the size of v are ignored.
+func DecodeInto(buf []byte, v interface{}) error {
+	size := binary.Size(v)
+	if size < 0 {
+		return fmt.Errorf("cannot decode into %T: not a fixed-size value", v)
+	}
+
+	if len(buf) < size {
+		return fmt.Errorf("short buffer decoding %T: got %d bytes, need %d", v, len(buf), size)
+	}
+
+	return binary.Read(bytes.NewReader(buf[:size]), NativeEndian, v)
+}
+
+// HexDump formats buf as lines of 16 hex bytes, prefixed with their offset and followed by their
+// printable ASCII representation. Runs of identical lines are collapsed to a single "*", in the
+// manner of hexdump(1), since raw device data structures are often largely zero-filled.
+func HexDump(buf []byte) string {
+	var (
+		sb   strings.Builder
+		prev []byte
+		star bool
+	)
+
+	for off := 0; off < len(buf); off += 16 {
+		end := off + 16
+		if end > len(buf) {
+			end = len(buf)
+		}
+
+		line := buf[off:end]
+
+		// Always print the final line, so that the total length is evident
+		if prev != nil && end < len(buf) && bytes.Equal(line, prev) {
+			if !star {
+				sb.WriteString("*\n")
+				star = true
+			}
+
+			continue
+		}
+
+		prev, star = line, false
+
+		fmt.Fprintf(&sb, "%04x ", off)
+
+		for i := 0; i < 16; i++ {
+			if i < len(line) {
+				fmt.Fprintf(&sb, " %02x", line[i])
+			} else {
+				sb.WriteString("   ")
+			}
+		}
+
+		sb.WriteString("  |")
+
+		for _, c := range line {
+			if c < 0x20 || c > 0x7e {
+				c = '.'
+			}
+
+			sb.WriteByte(c)
+		}
+
+		sb.WriteString("|\n")
+	}
+
+	return sb.String()
+}
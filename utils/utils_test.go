@@ -0,0 +1,54 @@
+// Copyright 2017-18 Daniel Swarbrick. All rights reserved.
+// Use of this source code is governed by a GPL license that can be found in the LICENSE file.
+
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeInto(t *testing.T) {
+	assert := assert.New(t)
+
+	type record struct {
+		A uint32
+		B uint16
+		C [2]byte
+	}
+
+	buf := make([]byte, 8)
+	NativeEndian.PutUint32(buf, 0xdeadbeef)
+	NativeEndian.PutUint16(buf[4:], 0x1234)
+	buf[6], buf[7] = 'o', 'k'
+
+	var r record
+	assert.NoError(DecodeInto(buf, &r))
+	assert.Equal(record{A: 0xdeadbeef, B: 0x1234, C: [2]byte{'o', 'k'}}, r)
+
+	// Bytes beyond the size of the value are ignored
+	var r2 record
+	assert.NoError(DecodeInto(append(buf, 0xff, 0xff, 0xff), &r2))
+	assert.Equal(r, r2)
+
+	// Slices of fixed-size values are decoded in full
+	rs := make([]record, 2)
+	assert.NoError(DecodeInto(append(buf, buf...), rs))
+	assert.Equal([]record{r, r}, rs)
+
+	// A short buffer is rejected, rather than partially decoded
+	var r3 record
+	assert.Error(DecodeInto(buf[:7], &r3))
+	assert.Equal(record{}, r3)
+	assert.Error(DecodeInto(buf, rs))
+
+	// Values without a fixed size cannot be decoded
+	var s struct {
+		Name string
+	}
+	assert.Error(DecodeInto(buf, &s))
+
+	var m map[string]int
+	assert.Error(DecodeInto(buf, &m))
+}